@@ -0,0 +1,268 @@
+// Package analytics counts how feature flags are evaluated,
+// per feature flag and per hour
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	db "github.com/antoineaugusti/feature-flags/db"
+	metrics "github.com/antoineaugusti/feature-flags/metrics"
+	"github.com/boltdb/bolt"
+)
+
+// The format of the keys of hourly counts, sorted by time
+const hourFormat = "2006-01-02T15"
+
+// MaxHours is how many hours of counts are kept, the current hour included
+const MaxHours = 31 * 24
+
+// Results of an evaluation
+const (
+	ResultHasAccess = "has_access"
+	ResultNotAccess = "not_access"
+)
+
+// Event is the evaluation of a feature flag for a user
+type Event struct {
+	// The key of the feature flag
+	Feature string
+	// ResultHasAccess or ResultNotAccess
+	Result string
+	// Why the result was given, like "group" or "user"
+	Variant string
+	// When the feature flag was evaluated
+	Time time.Time
+}
+
+// HourlyCounts counts the evaluations of a feature flag during an hour
+type HourlyCounts struct {
+	// The start of the hour, in UTC
+	Hour time.Time `json:"hour"`
+	// Evaluations giving access
+	HasAccess uint64 `json:"has_access"`
+	// Evaluations refusing access
+	NotAccess uint64 `json:"not_access"`
+	// Evaluations by variant
+	Variants map[string]uint64 `json:"variants"`
+}
+
+// Recorder receives evaluation events without blocking and stores
+// their counts in a bolt database at a regular interval
+type Recorder struct {
+	database *bolt.DB
+	events   chan Event
+	// Counts not stored yet, by feature flag and hour
+	pending map[string]map[string]*HourlyCounts
+	// Gives the current time, to forget old counts
+	now func() time.Time
+}
+
+// NewRecorder creates a recorder holding at most size events
+// waiting to be counted. See Run
+func NewRecorder(database *bolt.DB, size int) *Recorder {
+	return &Recorder{
+		database: database,
+		events:   make(chan Event, size),
+		pending:  make(map[string]map[string]*HourlyCounts),
+		now:      time.Now,
+	}
+}
+
+// Record sends an event to the recorder. The event is dropped
+// when the recorder is full. It does nothing on a nil recorder
+func (r *Recorder) Record(event Event) {
+	if r == nil {
+		return
+	}
+
+	select {
+	case r.events <- event:
+	default:
+		metrics.DroppedEvents.Inc()
+	}
+}
+
+// Run counts events and stores counts every interval until stop
+// is closed. Pending events are stored before returning
+func (r *Recorder) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-r.events:
+			r.count(event)
+		case <-ticker.C:
+			r.store()
+		case <-stop:
+			for {
+				select {
+				case event := <-r.events:
+					r.count(event)
+				default:
+					r.store()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stats gets the counts of a feature flag for the hours starting
+// at or after since, oldest first. Counts not stored yet are missing
+func (r *Recorder) Stats(featureKey string, since time.Time) ([]HourlyCounts, error) {
+	stats := make([]HourlyCounts, 0)
+
+	err := r.database.View(func(tx *bolt.Tx) error {
+		bucket := featureBucket(tx, featureKey)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		start := []byte(since.UTC().Truncate(time.Hour).Format(hourFormat))
+		for key, value := cursor.Seek(start); key != nil; key, value = cursor.Next() {
+			var counts HourlyCounts
+			if err := json.Unmarshal(value, &counts); err != nil {
+				return err
+			}
+			stats = append(stats, counts)
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// Remove deletes the stored counts of a feature flag. Counts not
+// stored yet are still stored. It does nothing on a nil recorder
+func (r *Recorder) Remove(featureKey string) error {
+	if r == nil {
+		return nil
+	}
+
+	return r.database.Update(func(tx *bolt.Tx) error {
+		if featureBucket(tx, featureKey) == nil {
+			return nil
+		}
+		return tx.Bucket([]byte(db.GetStatsBucketName())).DeleteBucket([]byte(featureKey))
+	})
+}
+
+// Add an event to the pending counts
+func (r *Recorder) count(event Event) {
+	hour := event.Time.UTC().Truncate(time.Hour)
+
+	hours, ok := r.pending[event.Feature]
+	if !ok {
+		hours = make(map[string]*HourlyCounts)
+		r.pending[event.Feature] = hours
+	}
+
+	counts, ok := hours[hour.Format(hourFormat)]
+	if !ok {
+		counts = &HourlyCounts{Hour: hour, Variants: make(map[string]uint64)}
+		hours[hour.Format(hourFormat)] = counts
+	}
+
+	counts.Variants[event.Variant]++
+	if event.Result == ResultHasAccess {
+		counts.HasAccess++
+	} else {
+		counts.NotAccess++
+	}
+}
+
+// Add the pending counts to the stored counts, and delete the
+// counts older than MaxHours
+func (r *Recorder) store() {
+	if len(r.pending) == 0 {
+		return
+	}
+
+	err := r.database.Update(func(tx *bolt.Tx) error {
+		stats, err := tx.CreateBucketIfNotExists([]byte(db.GetStatsBucketName()))
+		if err != nil {
+			return err
+		}
+
+		for featureKey, hours := range r.pending {
+			bucket, err := stats.CreateBucketIfNotExists([]byte(featureKey))
+			if err != nil {
+				return err
+			}
+
+			for hour, counts := range hours {
+				total := HourlyCounts{Hour: counts.Hour, Variants: make(map[string]uint64)}
+				if value := bucket.Get([]byte(hour)); value != nil {
+					if err := json.Unmarshal(value, &total); err != nil {
+						return err
+					}
+				}
+				total.add(*counts)
+
+				bytes, err := json.Marshal(total)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(hour), bytes); err != nil {
+					return err
+				}
+			}
+		}
+
+		return prune(stats, r.now().UTC().Truncate(time.Hour).Add(-(MaxHours-1)*time.Hour))
+	})
+
+	// Counts are kept to be stored later
+	if err != nil {
+		slog.Error("Unable to store evaluation counts", "error", err)
+		return
+	}
+	r.pending = make(map[string]map[string]*HourlyCounts)
+}
+
+func (c *HourlyCounts) add(other HourlyCounts) {
+	c.HasAccess += other.HasAccess
+	c.NotAccess += other.NotAccess
+	for variant, count := range other.Variants {
+		c.Variants[variant] += count
+	}
+}
+
+// Delete the counts of the hours starting before oldest
+func prune(stats *bolt.Bucket, oldest time.Time) error {
+	end := []byte(oldest.Format(hourFormat))
+
+	return stats.ForEach(func(featureKey, _ []byte) error {
+		bucket := stats.Bucket(featureKey)
+		if bucket == nil {
+			return nil
+		}
+
+		// Keys sort by hour, so old hours come first
+		var old [][]byte
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key, end) < 0; key, _ = cursor.Next() {
+			old = append(old, key)
+		}
+		for _, key := range old {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// The bucket holding the counts of a feature flag, nil when there is none
+func featureBucket(tx *bolt.Tx, featureKey string) *bolt.Bucket {
+	stats := tx.Bucket([]byte(db.GetStatsBucketName()))
+	if stats == nil {
+		return nil
+	}
+	return stats.Bucket([]byte(featureKey))
+}
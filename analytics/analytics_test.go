@@ -0,0 +1,131 @@
+package analytics
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	database := getTestDB()
+	defer closeDB(database)
+
+	hour := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	recorder := NewRecorder(database, 10)
+	recorder.now = func() time.Time { return hour.Add(2 * time.Hour) }
+
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "user", hour.Add(5 * time.Minute)})
+	recorder.Record(Event{"homepage_v2", ResultNotAccess, "none", hour.Add(10 * time.Minute)})
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "group", hour.Add(70 * time.Minute)})
+	recorder.Record(Event{"portfolio", ResultHasAccess, "enabled", hour})
+	run(recorder)
+
+	// Counts are added to the stored counts
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "user", hour.Add(20 * time.Minute)})
+	run(recorder)
+
+	stats, err := recorder.Stats("homepage_v2", hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(stats))
+	assert.True(t, hour.Equal(stats[0].Hour))
+	assert.Equal(t, uint64(2), stats[0].HasAccess)
+	assert.Equal(t, uint64(1), stats[0].NotAccess)
+	assert.Equal(t, map[string]uint64{"user": 2, "none": 1}, stats[0].Variants)
+	assert.Equal(t, map[string]uint64{"group": 1}, stats[1].Variants)
+
+	// Hours before since are ignored
+	stats, _ = recorder.Stats("homepage_v2", hour.Add(time.Hour))
+	assert.Equal(t, 1, len(stats))
+
+	stats, _ = recorder.Stats("unknown", hour)
+	assert.Equal(t, 0, len(stats))
+}
+
+func TestRecordDoesNotBlock(t *testing.T) {
+	database := getTestDB()
+	defer closeDB(database)
+
+	recorder := NewRecorder(database, 1)
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "user", time.Now()})
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "user", time.Now()})
+	run(recorder)
+
+	stats, _ := recorder.Stats("homepage_v2", time.Now())
+	assert.Equal(t, uint64(1), stats[0].HasAccess)
+
+	// A nil recorder ignores events
+	var disabled *Recorder
+	disabled.Record(Event{})
+}
+
+func TestRecorderForgetsOldCounts(t *testing.T) {
+	database := getTestDB()
+	defer closeDB(database)
+
+	hour := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	recorder := NewRecorder(database, 10)
+	recorder.now = func() time.Time { return hour }
+
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "user", hour.Add(-MaxHours * time.Hour)})
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "user", hour.Add(-(MaxHours - 1) * time.Hour)})
+	recorder.Record(Event{"portfolio", ResultHasAccess, "user", hour.Add(-(MaxHours + 1) * time.Hour)})
+	run(recorder)
+
+	// Hours older than MaxHours are deleted, for every feature flag
+	stats, _ := recorder.Stats("homepage_v2", hour.Add(-2*MaxHours*time.Hour))
+	assert.Equal(t, 1, len(stats))
+	assert.True(t, hour.Add(-(MaxHours-1)*time.Hour).Equal(stats[0].Hour))
+	stats, _ = recorder.Stats("portfolio", hour.Add(-2*MaxHours*time.Hour))
+	assert.Equal(t, 0, len(stats))
+}
+
+func TestRecorderRemove(t *testing.T) {
+	database := getTestDB()
+	defer closeDB(database)
+
+	recorder := NewRecorder(database, 10)
+	recorder.Record(Event{"homepage_v2", ResultHasAccess, "user", time.Now()})
+	recorder.Record(Event{"portfolio", ResultHasAccess, "user", time.Now()})
+	run(recorder)
+
+	assert.Nil(t, recorder.Remove("homepage_v2"))
+	assert.Nil(t, recorder.Remove("unknown"))
+
+	stats, _ := recorder.Stats("homepage_v2", time.Now())
+	assert.Equal(t, 0, len(stats))
+	stats, _ = recorder.Stats("portfolio", time.Now())
+	assert.Equal(t, 1, len(stats))
+
+	// A nil recorder has nothing to remove
+	var disabled *Recorder
+	assert.Nil(t, disabled.Remove("homepage_v2"))
+}
+
+// Count recorded events and store them
+func run(recorder *Recorder) {
+	stop := make(chan struct{})
+	close(stop)
+	recorder.Run(time.Hour, stop)
+}
+
+func getTestDB() *bolt.DB {
+	database, err := bolt.Open(getDBPath(), 0600, nil)
+	if err != nil {
+		panic(err)
+	}
+	return database
+}
+
+func closeDB(database *bolt.DB) {
+	database.Close()
+	if err := os.Remove(getDBPath()); err != nil {
+		panic(err)
+	}
+}
+
+func getDBPath() string {
+	return "/tmp/analytics_test.db"
+}
@@ -0,0 +1,62 @@
+// Package build describes the running binary
+package build
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Set when building, like
+// go build -ldflags "-X github.com/antoineaugusti/feature-flags/build.Version=1.2.0 -X github.com/antoineaugusti/feature-flags/build.Commit=$(git rev-parse HEAD)"
+var (
+	// The version of the binary
+	Version = "dev"
+	// The git commit the binary was built from, read from the
+	// build information of the Go toolchain when not set
+	Commit = ""
+)
+
+// When the process started
+var startTime = time.Now()
+
+// Info describes the running binary
+type Info struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	GoVersion string    `json:"go_version"`
+	StartedAt time.Time `json:"started_at"`
+	// How long the process has been running, like 1h2m3s
+	Uptime string `json:"uptime"`
+	// The uptime in seconds
+	UptimeSeconds int64 `json:"uptime_seconds"`
+}
+
+// GetInfo describes the running binary
+func GetInfo() Info {
+	uptime := time.Since(startTime)
+
+	return Info{
+		Version:       Version,
+		Commit:        commit(),
+		GoVersion:     runtime.Version(),
+		StartedAt:     startTime,
+		Uptime:        uptime.Truncate(time.Second).String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+	}
+}
+
+func commit() string {
+	if len(Commit) > 0 {
+		return Commit
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,189 @@
+// Package clientip finds the IP address of the client of a request.
+// Forwarding headers are only trusted when set by trusted proxies
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Forwarding headers which can be trusted
+const (
+	// The Forwarded header, described by RFC 7239
+	HeaderForwarded = "forwarded"
+	// The X-Forwarded-For header
+	HeaderXForwardedFor = "x-forwarded-for"
+)
+
+// Resolver finds the client IP address of requests, going through
+// the addresses given by trusted proxies from the closest one
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// New creates a resolver trusting proxies in networks written as
+// CIDRs, like 10.0.0.0/8, or as IP addresses. Only the given header,
+// set by these proxies, is read
+func New(proxies []string, header string) (*Resolver, error) {
+	switch header {
+	case HeaderForwarded, HeaderXForwardedFor:
+	default:
+		return nil, fmt.Errorf("Unknown trusted header %s, expected forwarded or x-forwarded-for", header)
+	}
+
+	r := &Resolver{header: header}
+
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if len(proxy) == 0 {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid trusted proxy %s, expected a CIDR or an IP address", proxy)
+			}
+			addr = addr.Unmap()
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %s, expected a CIDR or an IP address", proxy)
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+
+	return r, nil
+}
+
+// Parse creates a resolver trusting proxies separated by commas
+func Parse(proxies, header string) (*Resolver, error) {
+	return New(strings.Split(proxies, ","), header)
+}
+
+// ClientIP gets the IP address of the client of a request. The
+// address of the peer is used unless it is a trusted proxy. The trusted
+// header is then read from the last address to the first one, until an
+// address which is not a trusted proxy. The other header is ignored,
+// since clients can send it through proxies. A nil resolver trusts no proxy
+func (r *Resolver) ClientIP(req *http.Request) string {
+	peer, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	chain := xForwardedFor(req.Header)
+	if r.header == HeaderForwarded {
+		chain = forwardedFor(req.Header)
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(chain[i])
+		// Hidden or invalid addresses end the chain
+		if !ok {
+			break
+		}
+
+		client = addr
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse an IP address, with an optional port. IPv6 addresses
+// with a port are enclosed in brackets
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// The addresses of the X-Forwarded-For headers, from the client to
+// the closest proxy
+func xForwardedFor(header http.Header) []string {
+	var chain []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			chain = append(chain, strings.TrimSpace(addr))
+		}
+	}
+	return chain
+}
+
+// The for parameters of the Forwarded headers, described by RFC 7239,
+// from the client to the closest proxy
+func forwardedFor(header http.Header) []string {
+	var chain []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range splitQuoted(value, ',') {
+			// Elements without a for parameter hide the client
+			addr := "unknown"
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					addr = strings.Trim(value, `"`)
+				}
+			}
+			chain = append(chain, addr)
+		}
+	}
+	return chain
+}
+
+// Split a header value, ignoring separators in quoted strings
+func splitQuoted(value string, separator byte) []string {
+	parts := make([]string, 0)
+	quoted, escaped, start := false, false, 0
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && value[i] == '\\':
+			escaped = true
+		case value[i] == '"':
+			quoted = !quoted
+		case value[i] == separator && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, value[start:])
+}
@@ -0,0 +1,107 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := Parse("10.0.0.0/8, 192.168.1.1, fd00::/8", HeaderXForwardedFor)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{"no proxy", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted peer without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"single hop", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed first address", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}}, "198.51.100.1"},
+		{"several proxies", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 192.168.1.1", "10.0.0.2"}}, "198.51.100.1"},
+		{"only proxies", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"invalid address", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage, 10.0.0.2"}}, "10.0.0.2"},
+		{"forwarded is ignored", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1"}}, "10.0.0.1"},
+		{"spoofed forwarded", "10.0.0.1:1234", map[string][]string{
+			"Forwarded":       {"for=1.2.3.4"},
+			"X-Forwarded-For": {"198.51.100.2"},
+		}, "198.51.100.2"},
+		{"ipv4 mapped ipv6 peer", "[::ffff:10.0.0.1]:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, resolver.ClientIP(request(c.remoteAddr, c.headers)), c.name)
+	}
+}
+
+func TestClientIPForwarded(t *testing.T) {
+	resolver, err := Parse("10.0.0.0/8, fd00::/8", HeaderForwarded)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{"forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for=198.51.100.1;proto=https, For="10.0.0.2:8080"`}}, "198.51.100.1"},
+		{"x-forwarded-for is ignored", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "10.0.0.1"},
+		{"spoofed x-forwarded-for", "10.0.0.1:1234", map[string][]string{
+			"Forwarded":       {"for=198.51.100.1"},
+			"X-Forwarded-For": {"1.2.3.4"},
+		}, "198.51.100.1"},
+		{"forwarded ipv6", "[fd00::1]:1234", map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"forwarded quoted separator", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for=198.51.100.1;by="a,b;c", for=10.0.0.2`}}, "198.51.100.1"},
+		{"forwarded unknown", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=unknown, for=10.0.0.2"}}, "10.0.0.2"},
+		{"forwarded obfuscated", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=_hidden"}}, "10.0.0.1"},
+		{"forwarded without for", "10.0.0.1:1234", map[string][]string{"Forwarded": {"proto=https"}}, "10.0.0.1"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, resolver.ClientIP(request(c.remoteAddr, c.headers)), c.name)
+	}
+}
+
+func TestNilResolver(t *testing.T) {
+	var resolver *Resolver
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "10.0.0.1", resolver.ClientIP(req))
+
+	// Not an IP address
+	req.RemoteAddr = "pipe"
+	assert.Equal(t, "pipe", resolver.ClientIP(req))
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse("10.0.0.0/33", HeaderXForwardedFor)
+	assert.Equal(t, "Invalid trusted proxy 10.0.0.0/33, expected a CIDR or an IP address", err.Error())
+
+	_, err = Parse("10.0.0.1, proxy.internal", HeaderXForwardedFor)
+	assert.Equal(t, "Invalid trusted proxy proxy.internal, expected a CIDR or an IP address", err.Error())
+
+	_, err = Parse("10.0.0.1", "x-real-ip")
+	assert.Equal(t, "Unknown trusted header x-real-ip, expected forwarded or x-forwarded-for", err.Error())
+
+	resolver, err := Parse("", HeaderForwarded)
+	assert.Nil(t, err)
+	assert.Len(t, resolver.trusted, 0)
+}
+
+func request(remoteAddr string, headers map[string][]string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	for key, values := range headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	return req
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"fmt"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+)
+
+// Kinds of writes replicated through the raft log
+const (
+	opPutFeature    = "put_feature"
+	opRemoveFeature = "remove_feature"
+	opAddUser       = "add_user"
+	opRemoveUser    = "remove_user"
+	opPutSegment    = "put_segment"
+	opRemoveSegment = "remove_segment"
+	opAddChange     = "add_change"
+	opRemoveChanges = "remove_changes"
+)
+
+// A write made by a transaction, replayed by every node
+type command struct {
+	Op      string           `json:"op"`
+	Feature *m.FeatureFlag   `json:"feature,omitempty"`
+	Segment *m.Segment       `json:"segment,omitempty"`
+	Change  *m.FeatureChange `json:"change,omitempty"`
+	Key     string           `json:"key,omitempty"`
+	User    uint32           `json:"user,omitempty"`
+}
+
+// A transaction recording its successful writes
+type recordingTx struct {
+	repos.Tx
+	commands *[]command
+}
+
+func (tx recordingTx) record(err error, c command) error {
+	if err == nil {
+		*tx.commands = append(*tx.commands, c)
+	}
+	return err
+}
+
+func (tx recordingTx) PutFeature(feature m.FeatureFlag) error {
+	return tx.record(tx.Tx.PutFeature(feature), command{Op: opPutFeature, Feature: &feature})
+}
+
+func (tx recordingTx) RemoveFeature(featureKey string) error {
+	return tx.record(tx.Tx.RemoveFeature(featureKey), command{Op: opRemoveFeature, Key: featureKey})
+}
+
+func (tx recordingTx) AddUser(featureKey string, user uint32) error {
+	return tx.record(tx.Tx.AddUser(featureKey, user), command{Op: opAddUser, Key: featureKey, User: user})
+}
+
+func (tx recordingTx) RemoveUser(featureKey string, user uint32) error {
+	return tx.record(tx.Tx.RemoveUser(featureKey, user), command{Op: opRemoveUser, Key: featureKey, User: user})
+}
+
+func (tx recordingTx) PutSegment(segment m.Segment) error {
+	return tx.record(tx.Tx.PutSegment(segment), command{Op: opPutSegment, Segment: &segment})
+}
+
+func (tx recordingTx) RemoveSegment(segmentKey string) error {
+	return tx.record(tx.Tx.RemoveSegment(segmentKey), command{Op: opRemoveSegment, Key: segmentKey})
+}
+
+func (tx recordingTx) AddChange(change m.FeatureChange) error {
+	return tx.record(tx.Tx.AddChange(change), command{Op: opAddChange, Change: &change})
+}
+
+func (tx recordingTx) RemoveChanges(featureKey string) error {
+	return tx.record(tx.Tx.RemoveChanges(featureKey), command{Op: opRemoveChanges, Key: featureKey})
+}
+
+// Replay a write
+func (c command) apply(tx repos.Tx) error {
+	switch c.Op {
+	case opPutFeature:
+		return tx.PutFeature(*c.Feature)
+	case opRemoveFeature:
+		return tx.RemoveFeature(c.Key)
+	case opAddUser:
+		return tx.AddUser(c.Key, c.User)
+	case opRemoveUser:
+		return tx.RemoveUser(c.Key, c.User)
+	case opPutSegment:
+		return tx.PutSegment(*c.Segment)
+	case opRemoveSegment:
+		return tx.RemoveSegment(c.Key)
+	case opAddChange:
+		return tx.AddChange(*c.Change)
+	case opRemoveChanges:
+		return tx.RemoveChanges(c.Key)
+	}
+
+	return fmt.Errorf("Unknown command %s", c.Op)
+}
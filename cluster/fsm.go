@@ -0,0 +1,182 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/hashicorp/raft"
+)
+
+// Applies the raft log to the local store of a node
+type fsm struct {
+	store repos.Store
+	// Receives a value when the local store changed
+	applied chan struct{}
+}
+
+// Everything held by the local store, written to raft snapshots
+type fsmSnapshot struct {
+	Features m.FeatureFlags `json:"features"`
+	Segments m.Segments     `json:"segments"`
+	// The history of feature flags by key, oldest first
+	History map[string][]m.FeatureChange `json:"history"`
+}
+
+// Apply replays the writes of a committed transaction. The error
+// is returned to the node which submitted the transaction
+func (f *fsm) Apply(entry *raft.Log) interface{} {
+	var commands []command
+	if err := json.Unmarshal(entry.Data, &commands); err != nil {
+		return err
+	}
+
+	err := f.store.Update(func(tx repos.Tx) error {
+		for _, c := range commands {
+			if err := c.apply(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.notify()
+	return nil
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	snapshot := &fsmSnapshot{}
+	err := f.store.View(func(tx repos.Tx) (err error) {
+		if snapshot.Features, err = tx.GetFeatures(); err != nil {
+			return err
+		}
+
+		if snapshot.Segments, err = tx.GetSegments(); err != nil {
+			return err
+		}
+
+		return snapshot.readHistory(tx)
+	})
+
+	return snapshot, err
+}
+
+// Restore replaces the content of the local store by a snapshot
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var snapshot fsmSnapshot
+	if err := json.NewDecoder(rc).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	err := f.store.Update(func(tx repos.Tx) error {
+		features, err := tx.GetFeatures()
+		if err != nil {
+			return err
+		}
+		for _, feature := range features {
+			if err := tx.RemoveFeature(feature.Key); err != nil {
+				return err
+			}
+		}
+
+		segments, err := tx.GetSegments()
+		if err != nil {
+			return err
+		}
+		for _, segment := range segments {
+			if err := tx.RemoveSegment(segment.Key); err != nil {
+				return err
+			}
+		}
+
+		for _, segment := range snapshot.Segments {
+			if err := tx.PutSegment(segment); err != nil {
+				return err
+			}
+		}
+		for _, feature := range snapshot.Features {
+			if err := tx.PutFeature(feature); err != nil {
+				return err
+			}
+		}
+
+		return snapshot.restoreHistory(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	f.notify()
+	return nil
+}
+
+// Signal a change without waiting for it to be handled
+func (f *fsm) notify() {
+	select {
+	case f.applied <- struct{}{}:
+	default:
+	}
+}
+
+// Read the history of every feature flag
+func (s *fsmSnapshot) readHistory(tx repos.Tx) error {
+	keys, err := tx.GetChangedFeatures()
+	if err != nil {
+		return err
+	}
+
+	s.History = make(map[string][]m.FeatureChange, len(keys))
+	for _, key := range keys {
+		changes, err := tx.GetChanges(key)
+		if err != nil {
+			return err
+		}
+
+		// Changes are read newest first
+		for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+			changes[i], changes[j] = changes[j], changes[i]
+		}
+		s.History[key] = changes
+	}
+
+	return nil
+}
+
+// Replace the history of every feature flag, keeping revisions
+func (s *fsmSnapshot) restoreHistory(tx repos.Tx) error {
+	keys, err := tx.GetChangedFeatures()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := tx.RemoveChanges(key); err != nil {
+			return err
+		}
+	}
+
+	for _, changes := range s.History {
+		for _, change := range changes {
+			if err := tx.AddChange(change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,311 @@
+// Package cluster replicates feature flags and segments across
+// several servers with the raft consensus protocol
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	services "github.com/antoineaugusti/feature-flags/services"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// How long a write waits to be committed by the cluster
+const applyTimeout = 10 * time.Second
+
+// Returned by a transaction to discard its writes once recorded
+var errDryRun = fmt.Errorf("dry run")
+
+// A member of the cluster
+type Peer struct {
+	// Identifies the node in the cluster
+	ID string
+	// Where the node talks to the other nodes, like 10.0.0.1:7000
+	RaftAddress string
+	// The URL of the HTTP API of the node, like http://10.0.0.1:8080
+	APIAddress string
+}
+
+// Config describes a node and the cluster it belongs to
+type Config struct {
+	// The ID of this node, one of Peers
+	ID string
+	// Every member of the cluster, this node included
+	Peers []Peer
+	// Where the raft log and snapshots are kept. They
+	// are kept in memory when empty
+	Directory string
+}
+
+// Node is a member of a cluster. Every node serves reads from a local
+// copy of the feature flags, writes are only accepted by the leader
+type Node struct {
+	// The service reading and writing feature flags through the cluster
+	Service *services.FeatureService
+	peers   []Peer
+	// The host names of the HTTP APIs of the nodes
+	hosts map[string]bool
+	raft  *raft.Raft
+	// The local copy of the feature flags, only written by the raft log
+	store repos.Store
+	// Serializes writes
+	lock    sync.Mutex
+	closers []io.Closer
+	stop    chan struct{}
+}
+
+// Writes go through the raft log, reads use the local copy
+type replicatedStore struct {
+	node *Node
+}
+
+// ParsePeers reads a comma-separated list of peers
+// written as id=raft_address=api_url
+func ParsePeers(value string) ([]Peer, error) {
+	var peers []Peer
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(field), "=")
+		if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+			return nil, fmt.Errorf("Invalid cluster peer %s, expected id=raft_address=api_url", field)
+		}
+
+		peers = append(peers, Peer{ID: parts[0], RaftAddress: parts[1], APIAddress: parts[2]})
+	}
+
+	return peers, nil
+}
+
+// NewNode starts a node listening for the other nodes on its raft address.
+// The cluster is formed by Peers the first time its nodes start
+func NewNode(config Config) (*Node, error) {
+	self, err := config.self()
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := raft.NewTCPTransport(self.RaftAddress, nil, 3, applyTimeout, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Directory) == 0 {
+		store := raft.NewInmemStore()
+		return newNode(config, raft.DefaultConfig(), store, store, raft.NewInmemSnapshotStore(), transport)
+	}
+
+	if err := os.MkdirAll(config.Directory, 0700); err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	logs, err := raftboltdb.NewBoltStore(filepath.Join(config.Directory, "raft.db"))
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(config.Directory, 2, os.Stderr)
+	if err != nil {
+		logs.Close()
+		transport.Close()
+		return nil, err
+	}
+
+	node, err := newNode(config, raft.DefaultConfig(), logs, logs, snapshots, transport)
+	if err != nil {
+		logs.Close()
+		return nil, err
+	}
+	node.closers = append(node.closers, logs)
+
+	return node, nil
+}
+
+func newNode(config Config, raftConfig *raft.Config, logs raft.LogStore, stable raft.StableStore, snapshots raft.SnapshotStore, transport raft.Transport) (*Node, error) {
+	closeTransport := func() {
+		if closer, ok := transport.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+
+	raftConfig.LocalID = raft.ServerID(config.ID)
+
+	// Every node starts with the same configuration, so that
+	// any of them can be elected leader
+	exists, err := raft.HasExistingState(logs, stable, snapshots)
+	if err != nil {
+		closeTransport()
+		return nil, err
+	}
+	if !exists {
+		var servers []raft.Server
+		for _, peer := range config.Peers {
+			servers = append(servers, raft.Server{ID: raft.ServerID(peer.ID), Address: raft.ServerAddress(peer.RaftAddress)})
+		}
+
+		err = raft.BootstrapCluster(raftConfig, logs, stable, snapshots, transport, raft.Configuration{Servers: servers})
+		if err != nil {
+			closeTransport()
+			return nil, err
+		}
+	}
+
+	node := &Node{
+		peers: config.Peers,
+		hosts: peerHosts(config.Peers),
+		store: repos.NewMemoryStore(),
+		stop:  make(chan struct{}),
+	}
+
+	machine := &fsm{store: node.store, applied: make(chan struct{}, 1)}
+	node.raft, err = raft.NewRaft(raftConfig, machine, logs, stable, snapshots, transport)
+	if err != nil {
+		closeTransport()
+		return nil, err
+	}
+
+	node.Service, err = services.NewFeatureService(replicatedStore{node})
+	if err != nil {
+		node.raft.Shutdown()
+		return nil, err
+	}
+	go node.refresh(machine.applied)
+
+	return node, nil
+}
+
+// IsLeader tells if this node accepts writes
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// LeaderAddress gets the URL of the HTTP API of the leader,
+// empty when there is no leader
+func (n *Node) LeaderAddress() string {
+	_, id := n.raft.LeaderWithID()
+	for _, peer := range n.peers {
+		if peer.ID == string(id) {
+			return peer.APIAddress
+		}
+	}
+
+	return ""
+}
+
+// IsPeer tells if the identity of a verified client certificate is the
+// host name of the HTTP API of a node of the cluster. Nodes present the
+// certificate of their API when they forward changes to the leader
+func (n *Node) IsPeer(identity string) bool {
+	return n.hosts[identity]
+}
+
+// Find the host names or IP addresses of the HTTP APIs of peers
+func peerHosts(peers []Peer) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, peer := range peers {
+		if api, err := url.Parse(peer.APIAddress); err == nil && len(api.Hostname()) > 0 {
+			hosts[api.Hostname()] = true
+		}
+	}
+	return hosts
+}
+
+// Close stops taking part in the cluster
+func (n *Node) Close() error {
+	close(n.stop)
+	err := n.raft.Shutdown().Error()
+
+	for _, closer := range n.closers {
+		closer.Close()
+	}
+	return err
+}
+
+// Keep the snapshot of the service in sync with the local copy
+func (n *Node) refresh(applied <-chan struct{}) {
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-applied:
+			if err := n.Service.Refresh(context.Background()); err != nil {
+				slog.Error("Unable to refresh feature flags", "error", err)
+			}
+		}
+	}
+}
+
+// Run a transaction on the leader. Its writes are recorded against the
+// local copy, then discarded and replicated through the raft log
+func (n *Node) update(fn func(repos.Tx) error) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !n.IsLeader() {
+		return fmt.Errorf("This node is not the leader of the cluster")
+	}
+
+	// Writes committed under a previous leader must be applied first
+	if err := n.raft.Barrier(applyTimeout).Error(); err != nil {
+		return err
+	}
+
+	var commands []command
+	err := n.store.Update(func(tx repos.Tx) error {
+		if err := fn(recordingTx{tx, &commands}); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if err != errDryRun {
+		return err
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+
+	future := n.raft.Apply(data, applyTimeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Find this node among the peers
+func (c Config) self() (Peer, error) {
+	for _, peer := range c.Peers {
+		if peer.ID == c.ID {
+			return peer, nil
+		}
+	}
+
+	return Peer{}, fmt.Errorf("Node %s is not one of the cluster peers", c.ID)
+}
+
+func (s replicatedStore) View(fn func(repos.Tx) error) error {
+	return s.node.store.View(fn)
+}
+
+func (s replicatedStore) Update(fn func(repos.Tx) error) error {
+	return s.node.update(fn)
+}
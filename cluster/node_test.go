@@ -0,0 +1,248 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	h "github.com/antoineaugusti/feature-flags/http"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("a=127.0.0.1:7000=http://127.0.0.1:8080, b=127.0.0.1:7001=http://127.0.0.1:8081")
+	assert.Nil(t, err)
+	assert.Equal(t, []Peer{
+		{ID: "a", RaftAddress: "127.0.0.1:7000", APIAddress: "http://127.0.0.1:8080"},
+		{ID: "b", RaftAddress: "127.0.0.1:7001", APIAddress: "http://127.0.0.1:8081"},
+	}, peers)
+
+	_, err = ParsePeers("a=127.0.0.1:7000")
+	assert.Equal(t, "Invalid cluster peer a=127.0.0.1:7000, expected id=raft_address=api_url", err.Error())
+}
+
+func TestPeerHosts(t *testing.T) {
+	hosts := peerHosts([]Peer{
+		{ID: "a", APIAddress: "https://node-a.example.com:8080"},
+		{ID: "b", APIAddress: "https://[fd00::2]:8080"},
+		{ID: "c", APIAddress: "://invalid"},
+	})
+	assert.Equal(t, map[string]bool{"node-a.example.com": true, "fd00::2": true}, hosts)
+}
+
+func TestCluster(t *testing.T) {
+	nodes, servers := startCluster(t, 3)
+	defer func() {
+		for i := range nodes {
+			if nodes[i] != nil {
+				nodes[i].Close()
+			}
+			servers[i].Close()
+		}
+	}()
+
+	leader := waitForLeader(t, nodes)
+	var follower *Node
+	for _, node := range nodes {
+		if node != leader {
+			follower = node
+		}
+	}
+
+	// Writes are replicated to every node
+	assert.Nil(t, leader.Service.AddFeature(context.Background(), m.FeatureFlag{Key: "foo", Users: []uint32{1}}))
+	_, err := leader.Service.AddUser(context.Background(), "foo", 2)
+	assert.Nil(t, err)
+	for _, node := range nodes {
+		waitForFeature(t, node, "foo", func(feature m.FeatureFlag) bool { return feature.UserHasAccess(2) })
+	}
+
+	// Followers refuse writes
+	err = follower.Service.AddFeature(context.Background(), m.FeatureFlag{Key: "bar"})
+	assert.Equal(t, "This node is not the leader of the cluster", err.Error())
+
+	// Unless they come from the API, which sends them to the leader
+	res, err := http.Post(servers[indexOf(nodes, follower)].URL+"/features", "application/json", strings.NewReader(`{"key":"bar","enabled":true}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	for _, node := range nodes {
+		waitForFeature(t, node, "bar", func(feature m.FeatureFlag) bool { return feature.Enabled })
+	}
+
+	// Failed writes are not replicated
+	assert.Equal(t, "Feature already exists", leader.Service.AddFeature(context.Background(), m.FeatureFlag{Key: "foo"}).Error())
+
+	// Another leader is elected when the leader stops
+	i := indexOf(nodes, leader)
+	assert.Nil(t, leader.Close())
+	nodes[i] = nil
+
+	leader = waitForLeader(t, nodes)
+	assert.Nil(t, leader.Service.RemoveFeature(context.Background(), "bar"))
+	for _, node := range nodes {
+		if node != nil {
+			node := node
+			waitUntil(t, func() bool { return !hasFeature(node, "bar") })
+			assert.True(t, hasFeature(node, "foo"))
+
+			// Every node has the same history
+			history, err := node.Service.FeatureHistory(context.Background(), "bar")
+			assert.Nil(t, err)
+			assert.Len(t, history, 2)
+			assert.Equal(t, m.ActionRemoved, history[0].Action)
+			assert.Equal(t, uint64(2), history[0].Revision)
+		}
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	source := &fsm{store: repos.NewMemoryStore(), applied: make(chan struct{}, 1)}
+	_ = source.store.Update(func(tx repos.Tx) error {
+		_ = tx.PutSegment(m.Segment{Key: "beta_testers", Users: []uint32{42}})
+		_ = tx.AddChange(m.FeatureChange{Revision: 7, Action: m.ActionRemoved, Feature: m.FeatureFlag{Key: "old"}})
+		return tx.PutFeature(m.FeatureFlag{Key: "foo", Users: []uint32{1, 2}, Segments: []string{"beta_testers"}})
+	})
+
+	snapshot, err := source.Snapshot()
+	assert.Nil(t, err)
+	sink := &bufferSink{}
+	assert.Nil(t, snapshot.Persist(sink))
+
+	target := &fsm{store: repos.NewMemoryStore(), applied: make(chan struct{}, 1)}
+	_ = target.store.Update(func(tx repos.Tx) error {
+		_ = tx.AddChange(m.FeatureChange{Action: m.ActionCreated, Feature: m.FeatureFlag{Key: "bar"}})
+		return tx.PutFeature(m.FeatureFlag{Key: "bar"})
+	})
+	assert.Nil(t, target.Restore(ioutil.NopCloser(&sink.Buffer)))
+
+	_ = target.store.View(func(tx repos.Tx) error {
+		features, _ := tx.GetFeatures()
+		assert.Len(t, features, 1)
+		assert.Equal(t, "foo", features[0].Key)
+		assert.Equal(t, []uint32{1, 2}, features[0].Users)
+		exists, err := tx.SegmentExists("beta_testers")
+		assert.Nil(t, err)
+		assert.True(t, exists)
+
+		// The history of removed feature flags is kept, with its revisions
+		keys, _ := tx.GetChangedFeatures()
+		assert.Equal(t, []string{"old"}, keys)
+		history, _ := tx.GetChanges("old")
+		assert.Len(t, history, 1)
+		assert.Equal(t, uint64(7), history[0].Revision)
+		return nil
+	})
+}
+
+// Start nodes connected by in-memory transports, each of them
+// serving the HTTP API
+func startCluster(t *testing.T, size int) ([]*Node, []*httptest.Server) {
+	nodes := make([]*Node, size)
+	servers := make([]*httptest.Server, size)
+	handlers := make([]http.Handler, size)
+	transports := make([]*raft.InmemTransport, size)
+	var peers []Peer
+
+	for i := 0; i < size; i++ {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+
+		var address raft.ServerAddress
+		address, transports[i] = raft.NewInmemTransport("")
+		peers = append(peers, Peer{ID: fmt.Sprintf("node%d", i), RaftAddress: string(address), APIAddress: servers[i].URL})
+	}
+
+	for i := range transports {
+		for j := range transports {
+			if i != j {
+				transports[i].Connect(transports[j].LocalAddr(), transports[j])
+			}
+		}
+	}
+
+	for i := range nodes {
+		raftConfig := raft.DefaultConfig()
+		raftConfig.HeartbeatTimeout = 50 * time.Millisecond
+		raftConfig.ElectionTimeout = 50 * time.Millisecond
+		raftConfig.LeaderLeaseTimeout = 50 * time.Millisecond
+		raftConfig.CommitTimeout = 5 * time.Millisecond
+		raftConfig.LogOutput = ioutil.Discard
+
+		store := raft.NewInmemStore()
+		node, err := newNode(Config{ID: peers[i].ID, Peers: peers}, raftConfig, store, store, raft.NewInmemSnapshotStore(), transports[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nodes[i] = node
+		handlers[i] = h.NewRouter(h.APIHandler{FeatureService: node.Service, Cluster: node})
+	}
+
+	return nodes, servers
+}
+
+func waitForLeader(t *testing.T, nodes []*Node) *Node {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		for _, node := range nodes {
+			if node != nil && node.IsLeader() {
+				return node
+			}
+		}
+	}
+
+	t.Fatal("No leader was elected")
+	return nil
+}
+
+func waitForFeature(t *testing.T, node *Node, featureKey string, ok func(m.FeatureFlag) bool) {
+	waitUntil(t, func() bool {
+		snapshot, _ := node.Service.Snapshot(context.Background())
+		feature, found := snapshot.Feature(featureKey)
+		return found && ok(feature)
+	})
+}
+
+func waitUntil(t *testing.T, condition func() bool) {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+
+	t.Fatal("Changes were not replicated")
+}
+
+func hasFeature(node *Node, featureKey string) bool {
+	snapshot, _ := node.Service.Snapshot(context.Background())
+	_, ok := snapshot.Feature(featureKey)
+	return ok
+}
+
+func indexOf(nodes []*Node, node *Node) int {
+	for i := range nodes {
+		if nodes[i] == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// A snapshot sink writing to memory
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "buffer" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
@@ -0,0 +1,419 @@
+// Package config reads the settings of the server from a YAML file,
+// environment variables and command-line flags
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The prefix of environment variables, followed by the name of a
+// setting in upper case with underscores, like FEATURE_FLAGS_READ_TIMEOUT
+const EnvPrefix = "FEATURE_FLAGS_"
+
+// Where the value of a setting comes from, by increasing precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Config holds the settings of the server
+type Config struct {
+	// The YAML configuration file
+	File     string
+	Address  string
+	Backend  string
+	Database string
+	// How long to wait for the lock of the bolt database file
+	BoltTimeout time.Duration
+	Refresh     time.Duration
+	Primary     string
+	Node        string
+	Peers       string
+	// Where a node of a cluster keeps its raft log and snapshots
+	RaftDir string
+	Files   string
+	Prune   bool
+	Drift   bool
+
+	LogFormat     string
+	LogLevel      string
+	TraceExporter string
+	TraceEndpoint string
+
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	MaxHeaderBytes  int
+	MaxBodyBytes    int64
+	ShutdownTimeout time.Duration
+
+	// Requests per second of every IP address and of every client
+	// token, 0 to disable the limits
+	RateLimitIP         float64
+	RateLimitIPBurst    int
+	RateLimitToken      float64
+	RateLimitTokenBurst int
+	// Networks of the proxies whose forwarding header is trusted
+	TrustedProxies string
+	TrustedHeader  string
+
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+	TLSRoles    string
+	// How often the certificate files are checked for changes
+	TLSReload time.Duration
+
+	// How often evaluation counts are stored
+	StatsInterval time.Duration
+	// How many evaluations can wait to be counted
+	StatsBuffer int
+}
+
+// Settings is a configuration and where its values come from
+type Settings struct {
+	Config Config
+	// The arguments left after the flags, like a command
+	Args []string
+
+	flags   *flag.FlagSet
+	sources map[string]string
+}
+
+// Short flags, kept for compatibility, and their settings
+var aliases = map[string]string{
+	"a": "address",
+	"b": "backend",
+	"d": "database",
+	"r": "refresh",
+	"f": "primary",
+	"n": "node",
+	"c": "peers",
+	"y": "files",
+}
+
+// Bind the settings to flags, with their default values
+func define(flags *flag.FlagSet, c *Config) {
+	flags.StringVar(&c.File, "config", "", "YAML configuration file, with settings named like flags")
+	flags.StringVar(&c.Address, "address", ":8080", "address to listen")
+	flags.StringVar(&c.Backend, "backend", "bolt", "storage backend: bolt, sqlite3 or postgres")
+	flags.StringVar(&c.Database, "database", "bolt.db", "location of the database file, or data source name of the SQL database")
+	flags.DurationVar(&c.BoltTimeout, "bolt-timeout", time.Second, "how long to wait for another process to release the bolt database file")
+	flags.DurationVar(&c.Refresh, "refresh", 5*time.Second, "how often feature flags are reloaded from a SQL database shared by several servers, or reconciled with YAML files")
+	flags.StringVar(&c.Primary, "primary", "", "URL of a primary server to follow as a read-only replica, like http://primary:8080")
+	flags.StringVar(&c.Node, "node", "", "ID of this node, to run in cluster mode")
+	flags.StringVar(&c.Peers, "peers", "", "members of the cluster as id=raft_address=api_url, separated by commas")
+	flags.StringVar(&c.RaftDir, "raft-dir", "raft", "directory of the raft log and snapshots in cluster mode")
+	flags.StringVar(&c.Files, "files", "", "YAML file, or directory of YAML files, defining feature flags to reconcile")
+	flags.BoolVar(&c.Prune, "prune", false, "delete feature flags which are not defined by the YAML files")
+	flags.BoolVar(&c.Drift, "drift", false, "only report differences with the YAML files, without changing feature flags")
+
+	flags.StringVar(&c.LogFormat, "log-format", "text", "format of the logs: json or text")
+	flags.StringVar(&c.LogLevel, "log-level", "info", "minimum level of the logs: debug, info, warn or error")
+	flags.StringVar(&c.TraceExporter, "trace-exporter", "none", "where traces are sent: none, stdout or otlp")
+	flags.StringVar(&c.TraceEndpoint, "trace-endpoint", "", "URL of the OTLP/HTTP endpoint receiving traces, like http://localhost:4318/v1/traces")
+
+	flags.DurationVar(&c.ReadTimeout, "read-timeout", 15*time.Second, "maximum duration to read a request, including its body")
+	flags.DurationVar(&c.WriteTimeout, "write-timeout", 90*time.Second, "maximum duration to write a response, longer than the 60s long polling of GET /changes")
+	flags.DurationVar(&c.IdleTimeout, "idle-timeout", 120*time.Second, "maximum duration to keep an idle connection open")
+	flags.IntVar(&c.MaxHeaderBytes, "max-header-bytes", http.DefaultMaxHeaderBytes, "maximum size of the headers of a request, in bytes")
+	flags.Int64Var(&c.MaxBodyBytes, "max-body-bytes", 1<<20, "maximum size of the body of a request, in bytes")
+	flags.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "maximum duration to wait for in-flight requests when stopping on SIGINT or SIGTERM")
+
+	flags.Float64Var(&c.RateLimitIP, "rate-limit-ip", 0, "requests per second of every IP address, 0 for no limit")
+	flags.IntVar(&c.RateLimitIPBurst, "rate-limit-ip-burst", 100, "requests of an IP address at once, before being limited")
+	flags.Float64Var(&c.RateLimitToken, "rate-limit-token", 0, "requests per second of every client certificate, 0 for no limit")
+	flags.IntVar(&c.RateLimitTokenBurst, "rate-limit-token-burst", 100, "requests of a client certificate at once, before being limited")
+	flags.StringVar(&c.TrustedProxies, "trusted-proxies", "", "CIDRs or IP addresses of the proxies whose forwarding header is trusted, separated by commas")
+	flags.StringVar(&c.TrustedHeader, "trusted-header", "x-forwarded-for", "forwarding header set by trusted proxies: forwarded or x-forwarded-for")
+
+	flags.StringVar(&c.TLSCert, "tls-cert", "", "certificate file of the server, PEM encoded, to serve HTTPS")
+	flags.StringVar(&c.TLSKey, "tls-key", "", "private key file of the certificate, PEM encoded")
+	flags.StringVar(&c.TLSClientCA, "tls-client-ca", "", "CA certificates file, PEM encoded, signing the certificates clients must present")
+	flags.StringVar(&c.TLSRoles, "tls-roles", "", "roles of client certificates as identity=role, separated by commas, where the role is reader or writer")
+	flags.DurationVar(&c.TLSReload, "tls-reload", 10*time.Second, "how often the certificate files are checked for changes")
+
+	flags.DurationVar(&c.StatsInterval, "stats-interval", 10*time.Second, "how often evaluation counts are stored in the bolt database")
+	flags.IntVar(&c.StatsBuffer, "stats-buffer", 10000, "how many evaluations can wait to be counted before being dropped")
+
+	for alias, name := range aliases {
+		setting := flags.Lookup(name)
+		flags.Var(setting.Value, alias, "shorthand for -"+name)
+	}
+}
+
+// Load reads the settings given by command-line arguments, environment
+// variables as KEY=value and the configuration file. Flags take
+// precedence over environment variables, which take precedence over
+// the file. Settings are not validated, see Config.Validate
+func Load(args, environ []string, output io.Writer) (*Settings, error) {
+	s := &Settings{
+		flags:   flag.NewFlagSet("feature-flags", flag.ContinueOnError),
+		sources: make(map[string]string),
+	}
+	s.flags.SetOutput(output)
+	define(s.flags, &s.Config)
+
+	if err := s.flags.Parse(args); err != nil {
+		return nil, err
+	}
+	s.Args = s.flags.Args()
+
+	// Flags are applied again after the file and the environment
+	given := make(map[string]string)
+	s.flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	env := make(map[string]string)
+	for _, variable := range environ {
+		if key, value, ok := strings.Cut(variable, "="); ok && strings.HasPrefix(key, EnvPrefix) {
+			name := strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(key, EnvPrefix)), "_", "-")
+			env[name] = value
+		}
+	}
+
+	// The file can be given by the environment
+	if _, ok := given["config"]; !ok {
+		if path, ok := env["config"]; ok {
+			s.Config.File = path
+			s.sources["config"] = SourceEnv
+		}
+	}
+
+	if len(s.Config.File) > 0 {
+		if err := s.loadFile(s.Config.File); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedKeys(env) {
+		if name == "config" {
+			continue
+		}
+		if err := s.set(name, env[name], SourceEnv); err != nil {
+			return nil, fmt.Errorf("Invalid environment variable %s%s: %v", EnvPrefix, strings.ToUpper(strings.ReplaceAll(name, "-", "_")), err)
+		}
+	}
+
+	for _, name := range sortedKeys(given) {
+		s.flags.Set(name, given[name])
+		if setting, ok := aliases[name]; ok {
+			name = setting
+		}
+		s.sources[name] = SourceFlag
+	}
+
+	return s, nil
+}
+
+// Set the values of the settings of a YAML file
+func (s *Settings) loadFile(path string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(bytes, &values); err != nil {
+		return fmt.Errorf("Invalid configuration file %s: %v", path, err)
+	}
+
+	for _, name := range sortedKeys(values) {
+		value, err := formatValue(values[name])
+		if err == nil {
+			err = s.set(name, value, SourceFile)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid setting %s in %s: %v", name, path, err)
+		}
+	}
+
+	return nil
+}
+
+// Set a setting by its name, flag aliases excluded
+func (s *Settings) set(name, value, source string) error {
+	if _, ok := aliases[name]; ok || name == "config" || s.flags.Lookup(name) == nil {
+		return fmt.Errorf("unknown setting")
+	}
+
+	if err := s.flags.Set(name, value); err != nil {
+		return err
+	}
+	s.sources[name] = source
+	return nil
+}
+
+// Format a YAML value as a flag value. Lists are separated by commas
+func formatValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			formatted, err := formatValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = formatted
+		}
+		return strings.Join(items, ","), nil
+	case map[interface{}]interface{}:
+		return "", fmt.Errorf("expected a value or a list, not a mapping")
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Print writes the effective settings as YAML, usable as a
+// configuration file, with where their values come from. Passwords
+// of database URLs are hidden
+func (s *Settings) Print(w io.Writer) error {
+	if len(s.Config.File) > 0 {
+		if _, err := fmt.Fprintf(w, "# Configuration file: %s (%s)\n", s.Config.File, s.Source("config")); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0)
+	s.flags.VisitAll(func(f *flag.Flag) {
+		if _, ok := aliases[f.Name]; !ok && f.Name != "config" {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := s.flags.Lookup(name).Value.(flag.Getter).Get()
+		switch typed := value.(type) {
+		case time.Duration:
+			value = typed.String()
+		case string:
+			if name == "database" {
+				value = hidePassword(typed)
+			}
+		}
+
+		line, err := yaml.Marshal(map[string]interface{}{name: value})
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s # %s\n", strings.TrimSuffix(string(line), "\n"), s.Source(name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Source tells where the value of a setting comes from
+func (s *Settings) Source(name string) string {
+	if source, ok := s.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// Passwords of data source names, as key=value pairs
+var passwordPattern = regexp.MustCompile(`(password=)\S+`)
+
+// Hide the password of a data source name
+func hidePassword(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return passwordPattern.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate tells if the settings can be used together
+func (c Config) Validate() error {
+	switch c.Backend {
+	case "bolt", "sqlite3", "postgres":
+	default:
+		return fmt.Errorf("Unknown storage backend %s, expected bolt, sqlite3 or postgres", c.Backend)
+	}
+
+	if len(c.Primary) > 0 && len(c.Node) > 0 {
+		return fmt.Errorf("The primary and node settings cannot be used together")
+	}
+	if len(c.Node) > 0 && len(c.Peers) == 0 {
+		return fmt.Errorf("The node setting requires peers")
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"bolt-timeout", c.BoltTimeout},
+		{"read-timeout", c.ReadTimeout},
+		{"write-timeout", c.WriteTimeout},
+		{"idle-timeout", c.IdleTimeout},
+		{"shutdown-timeout", c.ShutdownTimeout},
+		{"refresh", c.Refresh},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			return fmt.Errorf("The %s setting cannot be negative", duration.name)
+		}
+	}
+
+	if c.TLSReload <= 0 {
+		return fmt.Errorf("The tls-reload setting must be positive")
+	}
+	if c.StatsInterval <= 0 {
+		return fmt.Errorf("The stats-interval setting must be positive")
+	}
+	if c.MaxHeaderBytes <= 0 {
+		return fmt.Errorf("The max-header-bytes setting must be positive")
+	}
+	if c.MaxBodyBytes <= 0 {
+		return fmt.Errorf("The max-body-bytes setting must be positive")
+	}
+	if c.RateLimitIP < 0 || c.RateLimitToken < 0 {
+		return fmt.Errorf("The rate-limit-ip and rate-limit-token settings cannot be negative")
+	}
+	if c.RateLimitIPBurst < 1 || c.RateLimitTokenBurst < 1 {
+		return fmt.Errorf("The rate-limit-ip-burst and rate-limit-token-burst settings must be positive")
+	}
+	if c.StatsBuffer <= 0 {
+		return fmt.Errorf("The stats-buffer setting must be positive")
+	}
+
+	if (len(c.TLSCert) > 0) != (len(c.TLSKey) > 0) {
+		return fmt.Errorf("The tls-cert and tls-key settings must be given together")
+	}
+	if len(c.TLSClientCA) > 0 && len(c.TLSCert) == 0 {
+		return fmt.Errorf("The tls-client-ca setting requires tls-cert and tls-key")
+	}
+	if len(c.TLSRoles) > 0 && len(c.TLSClientCA) == 0 {
+		return fmt.Errorf("The tls-roles setting requires tls-client-ca")
+	}
+
+	return nil
+}
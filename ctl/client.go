@@ -0,0 +1,132 @@
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	h "github.com/antoineaugusti/feature-flags/http"
+	m "github.com/antoineaugusti/feature-flags/models"
+	tlsconfig "github.com/antoineaugusti/feature-flags/tlsconfig"
+)
+
+// Client talks to the HTTP API of a server
+type Client struct {
+	// The URL of the server, like http://localhost:8080
+	Address string
+	HTTP    *http.Client
+}
+
+// APIError is returned when the API responds with an error status
+type APIError struct {
+	// The HTTP status code
+	Code int
+	// The status and message of the response
+	Status  string
+	Message string
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Message, e.Status)
+}
+
+// NewClient creates a client of the server of a profile, trusting
+// its CA and presenting its client certificate
+func NewClient(profile Profile) (*Client, error) {
+	transport, err := tlsconfig.NewTransport(tlsconfig.ClientFiles{CA: profile.CA, Certificate: profile.Cert, Key: profile.Key})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Address: strings.TrimRight(profile.Address, "/"),
+		HTTP:    &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}, nil
+}
+
+// Features gets every feature flag
+func (c *Client) Features() (features m.FeatureFlags, err error) {
+	err = c.do("GET", "/features", nil, &features)
+	return
+}
+
+// Feature gets a feature flag thanks to its key
+func (c *Client) Feature(featureKey string) (feature m.FeatureFlag, err error) {
+	err = c.do("GET", featurePath(featureKey), nil, &feature)
+	return
+}
+
+// CreateFeature creates a feature flag
+func (c *Client) CreateFeature(newFeature m.FeatureFlag) (feature m.FeatureFlag, err error) {
+	err = c.do("POST", "/features", newFeature, &feature)
+	return
+}
+
+// PatchFeature changes the properties of a feature flag given by a merge patch
+func (c *Client) PatchFeature(featureKey string, patch map[string]interface{}) (feature m.FeatureFlag, err error) {
+	err = c.do("PATCH", featurePath(featureKey), patch, &feature)
+	return
+}
+
+// AddUser gives access to a feature flag to a user
+func (c *Client) AddUser(featureKey string, user uint32) (feature m.FeatureFlag, err error) {
+	err = c.do("POST", fmt.Sprintf("%s/users/%d", featurePath(featureKey), user), nil, &feature)
+	return
+}
+
+// RemoveFeature deletes a feature flag
+func (c *Client) RemoveFeature(featureKey string) error {
+	return c.do("DELETE", featurePath(featureKey), nil, nil)
+}
+
+// HasAccess checks if a user or some groups have access to a feature flag
+func (c *Client) HasAccess(featureKey string, ar h.AccessRequest) (bool, error) {
+	var message h.APIMessage
+	if err := c.do("POST", featurePath(featureKey)+"/access", ar, &message); err != nil {
+		return false, err
+	}
+
+	return message.Status == "has_access", nil
+}
+
+// Send a request with an optional JSON body and decode the JSON response in result
+func (c *Client) do(method, path string, body interface{}, result interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(method, c.Address+path, &payload)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	res, err := c.HTTP.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		var message h.APIMessage
+		if err := json.NewDecoder(res.Body).Decode(&message); err != nil {
+			return APIError{Code: res.StatusCode, Status: "unknown", Message: res.Status}
+		}
+		return APIError{Code: res.StatusCode, Status: message.Status, Message: message.Message}
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+func featurePath(featureKey string) string {
+	return "/features/" + featureKey
+}
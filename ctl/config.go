@@ -0,0 +1,75 @@
+package ctl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The server used when no profile is configured
+const defaultAddress = "http://localhost:8080"
+
+// Config lists the servers managed by the command-line client
+type Config struct {
+	// The profile used when none is given
+	Default string `yaml:"default"`
+	// Servers indexed by profile name
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile describes how to reach a server
+type Profile struct {
+	// The URL of the server, like http://flags.internal:8080
+	Address string `yaml:"address"`
+	// A PEM file of CA certificates trusted in addition
+	// to the system ones, for HTTPS servers
+	CA string `yaml:"ca"`
+	// PEM files of the client certificate and its key,
+	// for servers authenticating clients
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// The configuration file used when none is given
+func defaultConfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".feature-flags.yml")
+}
+
+// LoadConfig reads a configuration file. A missing
+// file gives an empty configuration
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return config, fmt.Errorf("Unable to read %s: %s", path, err)
+	}
+	return config, nil
+}
+
+// Profile gets a profile, or the default profile when name is empty
+func (c Config) Profile(name string) (Profile, error) {
+	if len(name) == 0 {
+		name = c.Default
+	}
+
+	if len(name) == 0 {
+		return Profile{Address: defaultAddress}, nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok || len(profile.Address) == 0 {
+		return Profile{}, fmt.Errorf("Unknown profile %s", name)
+	}
+	return profile, nil
+}
@@ -0,0 +1,446 @@
+// Package ctl is a command-line client of the HTTP API, to
+// manage feature flags from a terminal or a script
+package ctl
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	h "github.com/antoineaugusti/feature-flags/http"
+	m "github.com/antoineaugusti/feature-flags/models"
+)
+
+// Exit codes of the command-line client
+const (
+	ExitOK = 0
+	// The request failed: unreachable server, unexpected response
+	ExitError = 1
+	// Invalid command-line arguments or configuration
+	ExitUsage = 2
+	// The feature flag does not exist
+	ExitNotFound = 3
+	// The server refused the change
+	ExitRefused = 4
+	// The user does not have access to the feature flag, for check
+	ExitNoAccess = 5
+)
+
+// A subcommand of the command-line client
+type command struct {
+	usage       string
+	description string
+	run         func(c *cli, args []string) error
+}
+
+// What subcommands work with
+type cli struct {
+	client *Client
+	json   bool
+	stdout io.Writer
+}
+
+// Returned by subcommands given invalid arguments
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+// Returned by check when the user does not have access
+var errNoAccess = fmt.Errorf("no access")
+
+var commands = map[string]command{
+	"list":           {"list", "List feature flags", list},
+	"get":            {"get KEY", "Show a feature flag", get},
+	"create":         {"create KEY [-enabled] [-percentage N] [-users IDS] [-groups GROUPS] [-segments SEGMENTS]", "Create a feature flag", create},
+	"enable":         {"enable KEY", "Enable a feature flag for everyone", setEnabled(true)},
+	"disable":        {"disable KEY", "Disable a feature flag, users, groups and percentage still apply", setEnabled(false)},
+	"set-percentage": {"set-percentage KEY PERCENTAGE", "Give access to a feature flag to a percentage of users", setPercentage},
+	"add-user":       {"add-user KEY USER", "Give access to a feature flag to a user", addUser},
+	"delete":         {"delete KEY", "Delete a feature flag", remove},
+	"check":          {"check KEY [-user ID] [-groups GROUPS] [-attribute NAME=VALUE]...", "Check if a user has access to a feature flag", check},
+}
+
+// Run runs the command-line client with the arguments following
+// "ctl" and gives the exit code of the process
+func Run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	profile := flags.String("profile", "", "profile of the server to manage, from the configuration file")
+	address := flags.String("address", "", "URL of the server to manage, instead of a profile")
+	ca := flags.String("ca", "", "PEM file of CA certificates trusted with -address")
+	cert := flags.String("cert", "", "PEM file of the client certificate presented with -address")
+	key := flags.String("key", "", "PEM file of the key of the client certificate")
+	config := flags.String("config", defaultConfigPath(), "configuration file listing profiles")
+	output := flags.String("o", "table", "output format: table or json")
+	flags.Usage = func() { printUsage(flags, stderr) }
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %s\n", flags.Arg(0))
+		flags.Usage()
+		return ExitUsage
+	}
+
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "Unknown output format %s\n", *output)
+		return ExitUsage
+	}
+
+	server := Profile{Address: *address, CA: *ca, Cert: *cert, Key: *key}
+	if len(*address) == 0 {
+		configuration, err := LoadConfig(*config)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitUsage
+		}
+
+		if server, err = configuration.Profile(*profile); err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitUsage
+		}
+	}
+
+	client, err := NewClient(server)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	c := &cli{client: client, json: *output == "json", stdout: stdout}
+	err = cmd.run(c, flags.Args()[1:])
+
+	switch e := err.(type) {
+	case nil:
+		return ExitOK
+	case usageError:
+		fmt.Fprintf(stderr, "%s\nUsage: ctl %s\n", e.message, cmd.usage)
+		return ExitUsage
+	case APIError:
+		fmt.Fprintln(stderr, e)
+		if e.Code == 404 {
+			return ExitNotFound
+		}
+		if e.Code < 500 {
+			return ExitRefused
+		}
+		return ExitError
+	}
+
+	if err == errNoAccess {
+		return ExitNoAccess
+	}
+
+	fmt.Fprintln(stderr, err)
+	return ExitError
+}
+
+func printUsage(flags *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "Usage: ctl [options] command [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].description)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nOptions:")
+	flags.PrintDefaults()
+}
+
+func list(c *cli, args []string) error {
+	if len(args) > 0 {
+		return usageError{"Too many arguments"}
+	}
+
+	features, err := c.client.Features()
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(features)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tENABLED\tPERCENTAGE\tUSERS\tGROUPS\tSEGMENTS")
+	for _, feature := range features {
+		fmt.Fprintf(tw, "%s\t%t\t%d\t%d\t%s\t%s\n", feature.Key, feature.Enabled, feature.Percentage,
+			len(feature.Users), strings.Join(feature.Groups, ","), strings.Join(feature.Segments, ","))
+	}
+	return tw.Flush()
+}
+
+func get(c *cli, args []string) error {
+	key, err := parseArgs(nil, args, 1)
+	if err != nil {
+		return err
+	}
+
+	feature, err := c.client.Feature(key[0])
+	if err != nil {
+		return err
+	}
+	return c.printFeature(feature)
+}
+
+func create(c *cli, args []string) error {
+	flags := newFlagSet("create")
+	enabled := flags.Bool("enabled", false, "enable the feature flag for everyone")
+	percentage := flags.Uint("percentage", 0, "percentage of users having access")
+	users := flags.String("users", "", "user IDs having access, separated by commas")
+	groups := flags.String("groups", "", "groups having access, separated by commas")
+	segments := flags.String("segments", "", "segments having access, separated by commas")
+
+	key, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	feature := m.FeatureFlag{
+		Key:        key[0],
+		Enabled:    *enabled,
+		Percentage: uint32(*percentage),
+		Groups:     splitList(*groups),
+		Segments:   splitList(*segments),
+	}
+	for _, value := range splitList(*users) {
+		user, err := parseUser(value)
+		if err != nil {
+			return err
+		}
+		feature.Users = append(feature.Users, user)
+	}
+
+	feature, err = c.client.CreateFeature(feature)
+	if err != nil {
+		return err
+	}
+	return c.printFeature(feature)
+}
+
+func setEnabled(enabled bool) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		key, err := parseArgs(nil, args, 1)
+		if err != nil {
+			return err
+		}
+
+		feature, err := c.client.PatchFeature(key[0], map[string]interface{}{"enabled": enabled})
+		if err != nil {
+			return err
+		}
+		return c.printFeature(feature)
+	}
+}
+
+func setPercentage(c *cli, args []string) error {
+	values, err := parseArgs(nil, args, 2)
+	if err != nil {
+		return err
+	}
+
+	percentage, err := strconv.ParseUint(values[1], 10, 32)
+	if err != nil || percentage > 100 {
+		return usageError{"The percentage must be an integer between 0 and 100"}
+	}
+
+	feature, err := c.client.PatchFeature(values[0], map[string]interface{}{"percentage": percentage})
+	if err != nil {
+		return err
+	}
+	return c.printFeature(feature)
+}
+
+func addUser(c *cli, args []string) error {
+	values, err := parseArgs(nil, args, 2)
+	if err != nil {
+		return err
+	}
+
+	user, err := parseUser(values[1])
+	if err != nil {
+		return err
+	}
+
+	feature, err := c.client.AddUser(values[0], user)
+	if err != nil {
+		return err
+	}
+	return c.printFeature(feature)
+}
+
+func remove(c *cli, args []string) error {
+	key, err := parseArgs(nil, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if err := c.client.RemoveFeature(key[0]); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]string{"deleted": key[0]})
+	}
+	fmt.Fprintf(c.stdout, "Feature %s deleted\n", key[0])
+	return nil
+}
+
+func check(c *cli, args []string) error {
+	var attributes attributeFlag
+	flags := newFlagSet("check")
+	user := flags.String("user", "", "ID of the user")
+	groups := flags.String("groups", "", "groups of the user, separated by commas")
+	flags.Var(&attributes, "attribute", "attribute of the user as NAME=VALUE, can be repeated")
+
+	key, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	ar := h.AccessRequest{Groups: splitList(*groups), Attributes: map[string]string(attributes)}
+	if len(*user) > 0 {
+		if ar.User, err = parseUser(*user); err != nil {
+			return err
+		}
+	}
+
+	access, err := c.client.HasAccess(key[0], ar)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		err = c.printJSON(map[string]interface{}{"feature": key[0], "access": access})
+	} else if access {
+		fmt.Fprintln(c.stdout, "has_access")
+	} else {
+		fmt.Fprintln(c.stdout, "not_access")
+	}
+
+	if err == nil && !access {
+		return errNoAccess
+	}
+	return err
+}
+
+func (c *cli) printFeature(feature m.FeatureFlag) error {
+	if c.json {
+		return c.printJSON(feature)
+	}
+
+	users := make([]string, 0, len(feature.Users))
+	for _, user := range feature.Users {
+		users = append(users, strconv.FormatUint(uint64(user), 10))
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Key:\t%s\n", feature.Key)
+	fmt.Fprintf(tw, "Enabled:\t%t\n", feature.Enabled)
+	fmt.Fprintf(tw, "Percentage:\t%d\n", feature.Percentage)
+	fmt.Fprintf(tw, "Users:\t%s\n", strings.Join(users, ","))
+	fmt.Fprintf(tw, "Groups:\t%s\n", strings.Join(feature.Groups, ","))
+	fmt.Fprintf(tw, "Segments:\t%s\n", strings.Join(feature.Segments, ","))
+	fmt.Fprintf(tw, "Managed:\t%t\n", feature.Managed)
+	return tw.Flush()
+}
+
+func (c *cli) printJSON(value interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+// Parse flags given before or after exactly count positional arguments
+func parseArgs(flags *flag.FlagSet, args []string, count int) ([]string, error) {
+	if flags == nil {
+		flags = newFlagSet("")
+	}
+
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, usageError{err.Error()}
+	}
+	positional = append(positional, flags.Args()...)
+
+	if len(positional) != count {
+		return nil, usageError{fmt.Sprintf("Expected %d arguments, got %d", count, len(positional))}
+	}
+	return positional, nil
+}
+
+func parseUser(value string) (uint32, error) {
+	user, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || user == 0 {
+		return 0, usageError{"User ID must be a positive integer"}
+	}
+	return uint32(user), nil
+}
+
+// Split a comma-separated list, giving nil for an empty string
+func splitList(value string) []string {
+	if len(value) == 0 {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// A repeatable NAME=VALUE flag
+type attributeFlag map[string]string
+
+func (a *attributeFlag) String() string {
+	return ""
+}
+
+func (a *attributeFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Attributes must be given as NAME=VALUE")
+	}
+
+	if *a == nil {
+		*a = make(attributeFlag)
+	}
+	(*a)[parts[0]] = parts[1]
+	return nil
+}
@@ -0,0 +1,212 @@
+package ctl
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	h "github.com/antoineaugusti/feature-flags/http"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	services "github.com/antoineaugusti/feature-flags/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommands(t *testing.T) {
+	server := getServer()
+	defer server.Close()
+
+	code, stdout, stderr := run("-address", server.URL, "create", "homepage_v2", "-users", "42,1337", "-groups", "dev")
+	assert.Equal(t, ExitOK, code, stderr)
+	assert.Contains(t, stdout, "Key:         homepage_v2")
+	assert.Contains(t, stdout, "Users:       42,1337")
+
+	code, stdout, _ = run("-address", server.URL, "enable", "homepage_v2")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, "Enabled:     true")
+
+	code, _, _ = run("-address", server.URL, "disable", "homepage_v2")
+	assert.Equal(t, ExitOK, code)
+
+	code, _, _ = run("-address", server.URL, "set-percentage", "homepage_v2", "20")
+	assert.Equal(t, ExitOK, code)
+
+	code, _, _ = run("-address", server.URL, "add-user", "homepage_v2", "7")
+	assert.Equal(t, ExitOK, code)
+
+	var feature m.FeatureFlag
+	code, stdout, _ = run("-address", server.URL, "-o", "json", "get", "homepage_v2")
+	assert.Equal(t, ExitOK, code)
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &feature))
+	assert.False(t, feature.Enabled)
+	assert.Equal(t, uint32(20), feature.Percentage)
+	assert.Equal(t, []uint32{7, 42, 1337}, feature.Users)
+
+	code, stdout, _ = run("-address", server.URL, "list")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "KEY          ENABLED  PERCENTAGE  USERS  GROUPS  SEGMENTS\nhomepage_v2  false    20          3      dev     \n", stdout)
+
+	code, stdout, _ = run("-address", server.URL, "check", "homepage_v2", "-user", "42")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "has_access\n", stdout)
+
+	code, stdout, _ = run("-address", server.URL, "check", "homepage_v2", "-groups", "marketing")
+	assert.Equal(t, ExitNoAccess, code)
+	assert.Equal(t, "not_access\n", stdout)
+
+	code, stdout, _ = run("-address", server.URL, "delete", "homepage_v2")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "Feature homepage_v2 deleted\n", stdout)
+}
+
+func TestExitCodes(t *testing.T) {
+	server := getServer()
+	defer server.Close()
+
+	code, _, stderr := run("-address", server.URL, "get", "unknown")
+	assert.Equal(t, ExitNotFound, code)
+	assert.Equal(t, "The feature was not found (feature_not_found)\n", stderr)
+
+	code, _, stderr = run("-address", server.URL, "create", "ab")
+	assert.Equal(t, ExitRefused, code)
+	assert.Equal(t, "Feature key must be between 3 and 50 characters (invalid_feature)\n", stderr)
+
+	code, _, stderr = run("-address", server.URL, "set-percentage", "homepage_v2", "101")
+	assert.Equal(t, ExitUsage, code)
+	assert.Equal(t, "The percentage must be an integer between 0 and 100\nUsage: ctl set-percentage KEY PERCENTAGE\n", stderr)
+
+	code, _, _ = run("-address", server.URL, "get")
+	assert.Equal(t, ExitUsage, code)
+
+	code, _, stderr = run("-address", server.URL, "rename")
+	assert.Equal(t, ExitUsage, code)
+	assert.Contains(t, stderr, "Unknown command rename")
+
+	code, _, _ = run("-address", "http://127.0.0.1:1", "list")
+	assert.Equal(t, ExitError, code)
+}
+
+func TestProfiles(t *testing.T) {
+	server := getServer()
+	defer server.Close()
+
+	file, _ := ioutil.TempFile("", "ctl")
+	defer os.Remove(file.Name())
+	file.WriteString("default: staging\nprofiles:\n  staging:\n    address: " + server.URL + "\n  production:\n    address: http://127.0.0.1:1\n")
+	file.Close()
+
+	code, _, _ := run("-config", file.Name(), "list")
+	assert.Equal(t, ExitOK, code)
+
+	code, _, _ = run("-config", file.Name(), "-profile", "production", "list")
+	assert.Equal(t, ExitError, code)
+
+	code, _, stderr := run("-config", file.Name(), "-profile", "unknown", "list")
+	assert.Equal(t, ExitUsage, code)
+	assert.Equal(t, "Unknown profile unknown\n", stderr)
+
+	config, err := LoadConfig("/tmp/does-not-exist.yml")
+	assert.Nil(t, err)
+	profile, _ := config.Profile("")
+	assert.Equal(t, "http://localhost:8080", profile.Address)
+}
+
+func TestTLSProfiles(t *testing.T) {
+	server := httptest.NewTLSServer(getHandler())
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "ctl")
+	defer os.RemoveAll(dir)
+	ca := writeFile(dir, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	// The certificate of the server is trusted thanks to the CA
+	code, _, stderr := run("-address", server.URL, "list")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, "certificate")
+
+	config := writeFile(dir, "config.yml", []byte("default: tls\nprofiles:\n  tls:\n    address: "+server.URL+"\n    ca: "+ca+"\n"))
+	code, _, _ = run("-config", config, "list")
+	assert.Equal(t, ExitOK, code)
+
+	code, _, _ = run("-address", server.URL, "-ca", ca, "list")
+	assert.Equal(t, ExitOK, code)
+
+	code, _, stderr = run("-address", server.URL, "-ca", config, "list")
+	assert.Equal(t, ExitUsage, code)
+	assert.Equal(t, "No certificate found in "+config+"\n", stderr)
+}
+
+func TestClientCertificate(t *testing.T) {
+	server := httptest.NewUnstartedServer(getHandler())
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "ctl")
+	defer os.RemoveAll(dir)
+	ca := writeFile(dir, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ctl"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	cert := writeFile(dir, "cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyFile := writeFile(dir, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+
+	// The server asks for a client certificate
+	code, _, _ := run("-address", server.URL, "-ca", ca, "list")
+	assert.Equal(t, ExitError, code)
+
+	config := writeFile(dir, "config.yml", []byte("default: tls\nprofiles:\n  tls:\n    address: "+server.URL+"\n    ca: "+ca+"\n    cert: "+cert+"\n    key: "+keyFile+"\n"))
+	code, _, _ = run("-config", config, "list")
+	assert.Equal(t, ExitOK, code)
+
+	code, _, _ = run("-address", server.URL, "-ca", ca, "-cert", cert, "-key", keyFile, "list")
+	assert.Equal(t, ExitOK, code)
+}
+
+func writeFile(dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+func run(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := Run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func getServer() *httptest.Server {
+	return httptest.NewServer(getHandler())
+}
+
+func getHandler() http.Handler {
+	service, err := services.NewFeatureService(repos.NewMemoryStore())
+	if err != nil {
+		panic(err)
+	}
+	return h.NewRouter(h.APIHandler{FeatureService: service})
+}
@@ -1,7 +1,7 @@
 package db
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/boltdb/bolt"
 )
@@ -11,14 +11,43 @@ func GetBucketName() string {
 	return "features"
 }
 
+// GetUsersBucketName gets the name of the bucket holding, for each
+// feature flag, a nested bucket with the IDs of its allowed users
+func GetUsersBucketName() string {
+	return "users"
+}
+
+// GetSegmentsBucketName gets the name of the bucket holding segments
+func GetSegmentsBucketName() string {
+	return "segments"
+}
+
+// GetStatsBucketName gets the name of the bucket holding, for each
+// feature flag, a nested bucket with its evaluation counts per hour
+func GetStatsBucketName() string {
+	return "stats"
+}
+
+// GetHistoryBucketName gets the name of the bucket holding, for each
+// feature flag, a nested bucket with its changes by revision
+func GetHistoryBucketName() string {
+	return "history"
+}
+
 // Generate the default bucket if it does not exist yet
-func GenerateDefaultBucket(name string, db *bolt.DB) {
-	_ = db.Update(func(tx *bolt.Tx) error {
+func GenerateDefaultBucket(name string, db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(name))
-		if err != nil {
-			log.Fatal(err)
-		}
+		return err
+	})
+}
 
+// CheckBucket makes sure that a database is open and that a bucket exists
+func CheckBucket(name string, db *bolt.DB) error {
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(name)) == nil {
+			return fmt.Errorf("The %s bucket does not exist", name)
+		}
 		return nil
 	})
 }
@@ -0,0 +1,136 @@
+// Package gitops keeps feature flags in sync with their definitions
+// in YAML files, for instance checked out from a git repository
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	services "github.com/antoineaugusti/feature-flags/services"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The content of a YAML file
+type document struct {
+	Features m.FeatureFlags `yaml:"features"`
+}
+
+// Reconciler makes the feature flags of a service match YAML files
+type Reconciler struct {
+	// A YAML file, or a directory holding .yml and .yaml files
+	Path string
+	// The service holding the feature flags
+	Service *services.FeatureService
+	// Delete feature flags which are not defined by the files
+	Prune bool
+	// Only report differences, without changing feature flags
+	DryRun bool
+}
+
+// Load reads the feature flags defined by a YAML file,
+// or by every .yml and .yaml file of a directory
+func Load(path string) (m.FeatureFlags, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = yamlFiles(path); err != nil {
+			return nil, err
+		}
+	}
+
+	features := make(m.FeatureFlags, 0)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc document
+		if err := yaml.UnmarshalStrict(content, &doc); err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %s", file, err)
+		}
+		features = append(features, doc.Features...)
+	}
+
+	return features, nil
+}
+
+// Reconcile loads the files and applies them to the service once
+func (r Reconciler) Reconcile() (services.ReconcileReport, error) {
+	features, err := Load(r.Path)
+	if err != nil {
+		return services.ReconcileReport{}, err
+	}
+
+	return r.Service.Reconcile(context.Background(), features, r.Prune, r.DryRun)
+}
+
+// Run reconciles at a regular interval until stop is closed
+func (r Reconciler) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			report, err := r.Reconcile()
+			if err != nil {
+				slog.Error("Unable to reconcile feature flags", "path", r.Path, "error", err)
+				continue
+			}
+
+			if !report.InSync() {
+				LogReport(report)
+			}
+		}
+	}
+}
+
+// LogReport logs how feature flags differed from their definitions
+func LogReport(report services.ReconcileReport) {
+	if len(report.Undefined) > 0 {
+		slog.Warn("Feature flags without definition", "features", strings.Join(report.Undefined, ","))
+	}
+
+	if report.InSync() {
+		return
+	}
+
+	level, message := slog.LevelInfo, "Reconciled feature flags"
+	if report.DryRun {
+		level, message = slog.LevelWarn, "Drift detected in feature flags"
+	}
+	slog.Log(context.Background(), level, message, "created", strings.Join(report.Created, ","),
+		"updated", strings.Join(report.Updated, ","), "removed", strings.Join(report.Removed, ","))
+}
+
+// List the YAML files of a directory, sorted by name
+func yamlFiles(directory string) ([]string, error) {
+	// Entries are sorted by name
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		extension := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (extension == ".yml" || extension == ".yaml") {
+			files = append(files, filepath.Join(directory, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
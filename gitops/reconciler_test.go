@@ -0,0 +1,110 @@
+package gitops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	services "github.com/antoineaugusti/feature-flags/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	directory := getTestDirectory()
+	defer os.RemoveAll(directory)
+
+	writeFile(directory, "b.yaml", `
+features:
+  - key: homepage_v2
+    enabled: true
+    users: [42, 1337]
+    groups: [dev]
+`)
+	writeFile(directory, "a.yml", `
+features:
+  - key: portfolio
+    percentage: 20
+`)
+	writeFile(directory, "README.md", "Not a definition")
+
+	features, err := Load(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(features))
+	assert.Equal(t, "portfolio", features[0].Key)
+	assert.Equal(t, uint32(20), features[0].Percentage)
+	assert.Equal(t, "homepage_v2", features[1].Key)
+	assert.Equal(t, []uint32{42, 1337}, features[1].Users)
+	assert.Equal(t, []string{"dev"}, features[1].Groups)
+
+	// A single file
+	features, err = Load(filepath.Join(directory, "a.yml"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(features))
+
+	// Unknown properties are refused
+	writeFile(directory, "c.yml", `
+features:
+  - key: typo
+    enabld: true
+`)
+	_, err = Load(directory)
+	assert.NotNil(t, err)
+}
+
+func TestReconciler(t *testing.T) {
+	directory := getTestDirectory()
+	defer os.RemoveAll(directory)
+
+	service, _ := services.NewFeatureService(repos.NewMemoryStore())
+	_ = service.AddFeature(context.Background(), m.FeatureFlag{Key: "manual"})
+
+	writeFile(directory, "features.yml", `
+features:
+  - key: homepage_v2
+    enabled: true
+`)
+
+	reconciler := Reconciler{Path: directory, Service: service, Prune: true, DryRun: true}
+	report, err := reconciler.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"homepage_v2"}, report.Created)
+	assert.Equal(t, []string{}, report.Removed)
+	assert.False(t, service.FeatureExists(context.Background(), "homepage_v2"))
+
+	reconciler.DryRun = false
+	_, err = reconciler.Reconcile()
+	assert.Nil(t, err)
+
+	snapshot, _ := service.Snapshot(context.Background())
+	feature, ok := snapshot.Feature("homepage_v2")
+	assert.True(t, ok)
+	assert.True(t, feature.Managed)
+	assert.True(t, feature.IsEnabled())
+	_, ok = snapshot.Feature("manual")
+	assert.True(t, ok)
+
+	// Removed definitions are pruned
+	writeFile(directory, "features.yml", "features: []\n")
+	report, err = reconciler.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"homepage_v2"}, report.Removed)
+	assert.False(t, service.FeatureExists(context.Background(), "homepage_v2"))
+	assert.True(t, service.FeatureExists(context.Background(), "manual"))
+}
+
+func getTestDirectory() string {
+	directory, err := os.MkdirTemp("", "gitops")
+	if err != nil {
+		panic(err)
+	}
+	return directory
+}
+
+func writeFile(directory, name, content string) {
+	if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0600); err != nil {
+		panic(err)
+	}
+}
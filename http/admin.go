@@ -0,0 +1,49 @@
+package http
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// The files of the web admin UI, embedded in the binary
+// so that it is enough to serve the UI
+//
+//go:embed admin
+var adminFiles embed.FS
+
+// The content types of the files of the web admin UI
+var adminAssets = map[string]string{
+	"index.html": "text/html; charset=utf-8",
+	"app.js":     "application/javascript; charset=utf-8",
+	"style.css":  "text/css; charset=utf-8",
+}
+
+func (handler APIHandler) AdminIndex(w http.ResponseWriter, r *http.Request) {
+	writeAdminAsset("index.html", w)
+}
+
+func (handler APIHandler) AdminAsset(w http.ResponseWriter, r *http.Request) {
+	writeAdminAsset(mux.Vars(r)["asset"], w)
+}
+
+func writeAdminAsset(name string, w http.ResponseWriter) {
+	contentType, ok := adminAssets[name]
+	if !ok {
+		writeMessage(http.StatusNotFound, "asset_not_found", "The file was not found", w)
+		return
+	}
+
+	content, err := adminFiles.ReadFile("admin/" + name)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	// Only load files served by this server
+	w.Header().Set("Content-Security-Policy", "default-src 'self'")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
@@ -0,0 +1,263 @@
+(function () {
+  "use strict";
+
+  var features = [];
+  var selected = null;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  // Send a request to the API and decode the JSON response. Errors
+  // are rejected with the message given by the API
+  function api(method, path, body) {
+    var options = {method: method, headers: {}};
+    if (body !== undefined) {
+      options.headers["Content-Type"] = "application/json";
+      options.body = JSON.stringify(body);
+    }
+
+    return fetch(path, options).then(function (response) {
+      return response.json().then(function (data) {
+        if (!response.ok) {
+          throw new Error(data.message || response.statusText);
+        }
+        return data;
+      }, function () {
+        throw new Error(response.statusText);
+      });
+    });
+  }
+
+  function featurePath(key) {
+    return "/features/" + encodeURIComponent(key);
+  }
+
+  function cell(row, text) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    row.appendChild(td);
+    return td;
+  }
+
+  function showResult(element, message, ok) {
+    element.textContent = message;
+    element.className = "result " + (ok ? "success" : "error");
+  }
+
+  // Split a comma separated list, ignoring blanks
+  function splitList(value) {
+    return value.split(",").map(function (item) {
+      return item.trim();
+    }).filter(function (item) {
+      return item.length > 0;
+    });
+  }
+
+  function parseUsers(value) {
+    return splitList(value).map(function (item) {
+      if (!/^[0-9]+$/.test(item) || Number(item) < 1 || Number(item) > 4294967295) {
+        throw new Error("Invalid user ID " + item + ", user IDs must be positive integers");
+      }
+      return Number(item);
+    });
+  }
+
+  function parseAttributes(value) {
+    var attributes = {};
+    splitList(value).forEach(function (item) {
+      var index = item.indexOf("=");
+      if (index < 1) {
+        throw new Error("Invalid attribute " + item + ", expected name=value");
+      }
+      attributes[item.slice(0, index).trim()] = item.slice(index + 1).trim();
+    });
+    return attributes;
+  }
+
+  function matches(feature, query) {
+    if (query.length === 0) {
+      return true;
+    }
+    return [feature.key].concat(feature.groups || [], feature.segments || []).some(function (value) {
+      return value.toLowerCase().indexOf(query) !== -1;
+    });
+  }
+
+  function renderList() {
+    var query = $("search").value.trim().toLowerCase();
+    var tbody = $("features");
+    tbody.textContent = "";
+
+    var visible = features.filter(function (feature) {
+      return matches(feature, query);
+    });
+    $("empty").hidden = visible.length > 0;
+
+    visible.forEach(function (feature) {
+      var row = document.createElement("tr");
+      if (selected === feature.key) {
+        row.className = "selected";
+      }
+
+      var link = document.createElement("a");
+      link.href = "#" + feature.key;
+      link.textContent = feature.key;
+      cell(row, "").appendChild(link);
+
+      var toggle = document.createElement("input");
+      toggle.type = "checkbox";
+      toggle.checked = feature.enabled;
+      toggle.disabled = feature.managed;
+      toggle.title = feature.managed ? "Managed by configuration files" : "Enable or disable the feature flag";
+      toggle.addEventListener("change", function () {
+        setEnabled(feature.key, toggle.checked);
+      });
+      cell(row, "").appendChild(toggle);
+
+      cell(row, feature.percentage + "%");
+      cell(row, (feature.users || []).length);
+      cell(row, (feature.groups || []).join(", "));
+      cell(row, (feature.segments || []).join(", "));
+      tbody.appendChild(row);
+    });
+  }
+
+  function load() {
+    return api("GET", "/features").then(function (data) {
+      features = data;
+      $("list-error").hidden = true;
+      renderList();
+      if (selected !== null) {
+        showDetails(selected);
+      }
+    }, function (error) {
+      $("list-error").textContent = "Unable to load feature flags: " + error.message;
+      $("list-error").hidden = false;
+    });
+  }
+
+  function find(key) {
+    for (var i = 0; i < features.length; i++) {
+      if (features[i].key === key) {
+        return features[i];
+      }
+    }
+    return null;
+  }
+
+  function setEnabled(key, enabled) {
+    api("PATCH", featurePath(key), {enabled: enabled}).then(load, function (error) {
+      window.alert(error.message);
+      load();
+    });
+  }
+
+  function showDetails(key) {
+    var feature = find(key);
+    selected = feature === null ? null : key;
+    $("details").hidden = feature === null;
+    if (feature === null) {
+      return;
+    }
+
+    $("details-key").textContent = feature.key;
+    $("managed").hidden = !feature.managed;
+    $("edit-percentage").value = feature.percentage;
+    $("edit-users").value = (feature.users || []).join(", ");
+    $("edit-groups").value = (feature.groups || []).join(", ");
+    Array.prototype.forEach.call($("edit").elements, function (element) {
+      element.disabled = feature.managed;
+    });
+
+    renderHistory(key);
+  }
+
+  function renderHistory(key) {
+    var list = $("history");
+    api("GET", featurePath(key) + "/history").then(function (changes) {
+      list.textContent = "";
+      if (changes.length === 0) {
+        var item = document.createElement("li");
+        item.textContent = "No changes";
+        list.appendChild(item);
+      }
+
+      changes.forEach(function (change) {
+        var feature = change.feature;
+        var item = document.createElement("li");
+        item.textContent = new Date(change.time).toLocaleString() + " - " + change.action +
+          " (revision " + change.revision + "): " +
+          (feature.enabled ? "enabled" : "disabled") + ", " + feature.percentage + "%, " +
+          change.user_count + " users, groups: " + ((feature.groups || []).join(", ") || "none");
+        list.appendChild(item);
+      });
+    }, function (error) {
+      list.textContent = error.message;
+    });
+  }
+
+  function saveSettings(event) {
+    event.preventDefault();
+    var result = $("edit-result");
+    var percentage = $("edit-percentage").value;
+
+    var patch;
+    try {
+      if (!/^[0-9]+$/.test(percentage) || Number(percentage) > 100) {
+        throw new Error("The percentage must be an integer between 0 and 100");
+      }
+      patch = {
+        percentage: Number(percentage),
+        users: parseUsers($("edit-users").value),
+        groups: splitList($("edit-groups").value)
+      };
+    } catch (error) {
+      showResult(result, error.message, false);
+      return;
+    }
+
+    api("PATCH", featurePath(selected), patch).then(function () {
+      showResult(result, "Saved", true);
+      load();
+    }, function (error) {
+      showResult(result, error.message, false);
+    });
+  }
+
+  function testAccess(event) {
+    event.preventDefault();
+    var result = $("access-result");
+
+    var request;
+    try {
+      request = {
+        user: Number($("access-user").value || 0),
+        groups: splitList($("access-groups").value),
+        attributes: parseAttributes($("access-attributes").value)
+      };
+    } catch (error) {
+      showResult(result, error.message, false);
+      return;
+    }
+
+    api("POST", featurePath(selected) + "/access", request).then(function (data) {
+      showResult(result, data.message, data.status === "has_access");
+    }, function (error) {
+      showResult(result, error.message, false);
+    });
+  }
+
+  $("search").addEventListener("input", renderList);
+  $("edit").addEventListener("submit", saveSettings);
+  $("access").addEventListener("submit", testAccess);
+  window.addEventListener("hashchange", function () {
+    showDetails(window.location.hash.slice(1));
+    $("edit-result").textContent = "";
+    $("access-result").textContent = "";
+    renderList();
+  });
+
+  selected = window.location.hash.slice(1) || null;
+  load();
+})();
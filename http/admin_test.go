@@ -0,0 +1,56 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminUI(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	res, _ := http.Get(server.URL + "/admin")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, "default-src 'self'", res.Header.Get("Content-Security-Policy"))
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Contains(t, string(body), `<script src="/admin/app.js"></script>`)
+
+	res, _ = http.Get(server.URL + "/admin/app.js")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/javascript; charset=utf-8", res.Header.Get("Content-Type"))
+	body, _ = ioutil.ReadAll(res.Body)
+	assert.Contains(t, string(body), "change.user_count")
+
+	res, _ = http.Get(server.URL + "/admin/style.css")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/css; charset=utf-8", res.Header.Get("Content-Type"))
+
+	res, _ = http.Get(server.URL + "/admin/unknown.js")
+	assertResponseWithStatusAndMessage(t, res, http.StatusNotFound, "asset_not_found", "The file was not found")
+}
+
+func TestFeatureHistory(t *testing.T) {
+	var history []m.FeatureChange
+	onStart()
+	defer onFinish()
+
+	createDummyFeatureFlag()
+	patchFeature(base+"/homepage_v2", "application/json", `{"enabled":true}`)
+
+	res, _ := http.Get(base + "/homepage_v2/history")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&history)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, m.ActionUpdated, history[0].Action)
+	assert.True(t, history[0].Feature.Enabled)
+	assert.Equal(t, m.ActionCreated, history[1].Action)
+
+	res, _ = http.Get(base + "/notfound/history")
+	assert404Response(t, res)
+}
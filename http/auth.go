@@ -0,0 +1,108 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	helpers "github.com/antoineaugusti/feature-flags/helpers"
+)
+
+// Roles given to the identities of client certificates
+const (
+	// Checks access to feature flags and reads feature flags and segments
+	RoleReader = "reader"
+	// Also makes changes
+	RoleWriter = "writer"
+)
+
+// Routes used by health checks and monitoring, which are served to
+// clients without a certificate
+var unauthenticatedRoutes = []string{"Health", "Readiness", "Metrics"}
+
+// ParseRoles parses roles given as identity=role, separated by commas
+func ParseRoles(value string) (map[string]string, error) {
+	roles := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+
+		index := strings.LastIndex(pair, "=")
+		if index < 1 {
+			return nil, fmt.Errorf("Invalid role %s, expected identity=role", pair)
+		}
+
+		identity, role := pair[:index], pair[index+1:]
+		if role != RoleReader && role != RoleWriter {
+			return nil, fmt.Errorf("Unknown role %s for %s, expected reader or writer", role, identity)
+		}
+		roles[identity] = role
+	}
+
+	return roles, nil
+}
+
+// ClientIdentities gets the names of the verified client certificate of
+// a request: its common name, DNS names and URIs, like SPIFFE IDs
+func ClientIdentities(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	certificate := r.TLS.PeerCertificates[0]
+	identities := make([]string, 0)
+	if len(certificate.Subject.CommonName) > 0 {
+		identities = append(identities, certificate.Subject.CommonName)
+	}
+	identities = append(identities, certificate.DNSNames...)
+	for _, uri := range certificate.URIs {
+		identities = append(identities, uri.String())
+	}
+
+	return identities
+}
+
+// Get the role of the first identity of the client having one
+func clientRole(roles map[string]string, r *http.Request) string {
+	for _, identity := range ClientIdentities(r) {
+		if role, ok := roles[identity]; ok {
+			return role
+		}
+	}
+	return ""
+}
+
+// Serve a route only to clients with a verified certificate, whose role
+// allows it when roles are given
+func authorize(roles map[string]string, route Route, next http.Handler) http.Handler {
+	if helpers.StringInSlice(route.Name, unauthenticatedRoutes) {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(ClientIdentities(r)) == 0 {
+			writeMessage(http.StatusUnauthorized, "unauthenticated", "A valid client certificate is required", w)
+			return
+		}
+		if len(roles) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch clientRole(roles, r) {
+		case RoleWriter:
+			next.ServeHTTP(w, r)
+		case RoleReader:
+			if !isReadRoute(route) {
+				writeMessage(http.StatusForbidden, "forbidden", "The reader role cannot make changes", w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		default:
+			writeMessage(http.StatusForbidden, "unknown_client", "The client certificate has no role", w)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles("checkout=reader, deploy.internal=writer,spiffe://prod/ns/app=reader,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"checkout":             RoleReader,
+		"deploy.internal":      RoleWriter,
+		"spiffe://prod/ns/app": RoleReader,
+	}, roles)
+
+	roles, err = ParseRoles("")
+	assert.Nil(t, err)
+	assert.Len(t, roles, 0)
+
+	_, err = ParseRoles("checkout")
+	assert.Equal(t, "Invalid role checkout, expected identity=role", err.Error())
+
+	_, err = ParseRoles("checkout=admin")
+	assert.Equal(t, "Unknown role admin for checkout, expected reader or writer", err.Error())
+}
+
+func TestClientIdentities(t *testing.T) {
+	request := requestWithCertificate("GET", "/features", "", &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "checkout"},
+		DNSNames: []string{"checkout.internal"},
+		URIs:     []*url.URL{{Scheme: "spiffe", Host: "prod", Path: "/ns/checkout"}},
+	})
+	assert.Equal(t, []string{"checkout", "checkout.internal", "spiffe://prod/ns/checkout"}, ClientIdentities(request))
+
+	assert.Len(t, ClientIdentities(httptest.NewRequest("GET", "/features", nil)), 0)
+}
+
+func TestAuthorizeRoles(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	roles := map[string]string{"checkout": RoleReader, "spiffe://prod/ns/deploy": RoleWriter}
+	router := NewRouter(APIHandler{FeatureService: getService(), Roles: roles})
+
+	reader := &x509.Certificate{Subject: pkix.Name{CommonName: "checkout"}}
+	writer := &x509.Certificate{URIs: []*url.URL{{Scheme: "spiffe", Host: "prod", Path: "/ns/deploy"}}}
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}
+
+	serve := func(method, path, body string, certificate *x509.Certificate) *http.Response {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, requestWithCertificate(method, path, body, certificate))
+		return recorder.Result()
+	}
+
+	// Writers make changes
+	res := serve("POST", "/features", getDummyFeaturePayload(), writer)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	// Readers read and check access, without making changes
+	res = serve("GET", "/features/homepage_v2", "", reader)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = serve("POST", "/features/homepage_v2/access", `{"groups":["dev"]}`, reader)
+	assertAccessToTheFeature(t, res)
+
+	res = serve("DELETE", "/features/homepage_v2", "", reader)
+	assertResponseWithStatusAndMessage(t, res, http.StatusForbidden, "forbidden", "The reader role cannot make changes")
+
+	// Clients without a role or without a certificate are refused
+	res = serve("GET", "/features", "", unknown)
+	assertResponseWithStatusAndMessage(t, res, http.StatusForbidden, "unknown_client", "The client certificate has no role")
+
+	res = serve("GET", "/features", "", nil)
+	assertResponseWithStatusAndMessage(t, res, http.StatusUnauthorized, "unauthenticated", "A valid client certificate is required")
+
+	// Health checks and metrics are served to every client
+	res = serve("GET", "/healthz", "", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = serve("GET", "/metrics", "", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestRequireClientCertificate(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	router := NewRouter(APIHandler{FeatureService: getService(), RequireClientCertificate: true})
+	serve := func(request *http.Request) *http.Response {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	// Every client with a verified certificate is served
+	certificate := &x509.Certificate{Subject: pkix.Name{CommonName: "checkout"}}
+	res := serve(requestWithCertificate("POST", "/features", getDummyFeaturePayload(), certificate))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	// Unverified certificates are ignored
+	request := requestWithCertificate("GET", "/features", "", certificate)
+	request.TLS.VerifiedChains = nil
+	res = serve(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusUnauthorized, "unauthenticated", "A valid client certificate is required")
+
+	res = serve(requestWithCertificate("GET", "/readyz", "", nil))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func requestWithCertificate(method, path, body string, certificate *x509.Certificate) *http.Request {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if certificate != nil {
+		request.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{certificate},
+			VerifiedChains:   [][]*x509.Certificate{{certificate}},
+		}
+	}
+	return request
+}
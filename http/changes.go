@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The longest a follower can wait for changes
+const maxChangesWait = 60 * time.Second
+
+func (handler APIHandler) ChangesFeed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var since uint64
+	if value := query.Get("since"); len(value) > 0 {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			writeMessage(400, "invalid_revision", "The revision must be a positive integer", w)
+			return
+		}
+	}
+
+	var wait time.Duration
+	if value := query.Get("wait"); len(value) > 0 {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+			writeMessage(400, "invalid_wait", "The wait must be a positive duration, like 30s", w)
+			return
+		}
+	}
+	if wait > maxChangesWait {
+		wait = maxChangesWait
+	}
+
+	// Wait for changes, unless there are changes already
+	handler.FeatureService.WaitForChanges(r.Context(), query.Get("epoch"), since, wait)
+
+	changes, err := handler.FeatureService.Changes(r.Context(), query.Get("epoch"), since)
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(http.StatusOK, changes, w)
+}
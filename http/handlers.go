@@ -2,8 +2,14 @@ package http
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
+	analytics "github.com/antoineaugusti/feature-flags/analytics"
+	clientip "github.com/antoineaugusti/feature-flags/clientip"
 	m "github.com/antoineaugusti/feature-flags/models"
 	services "github.com/antoineaugusti/feature-flags/services"
 	"github.com/gorilla/mux"
@@ -11,7 +17,49 @@ import (
 
 // Handles incoming requests
 type APIHandler struct {
-	FeatureService services.FeatureService
+	FeatureService *services.FeatureService
+	// Refuse requests modifying feature flags or segments,
+	// for replicas following another server
+	ReadOnly bool
+	// Forward requests modifying feature flags or segments to
+	// the leader, when several servers form a cluster
+	Cluster Cluster
+	// Counts evaluations of feature flags, nil to disable statistics
+	Analytics *analytics.Recorder
+	// Tells why the server cannot serve requests, like an unavailable
+	// database. The server is always ready when nil
+	Ready func() error
+	// Only serves clients with a certificate verified by the TLS
+	// configuration, except for health checks and metrics
+	RequireClientCertificate bool
+	// Roles of the identities of client certificates. When set, only
+	// clients whose certificate has a role are served
+	Roles map[string]string
+	// Sends requests forwarded to the leader of a cluster, the
+	// default transport when nil
+	Transport http.RoundTripper
+	// Maximum size of request bodies, DefaultMaxBodyBytes when 0
+	MaxBodyBytes int64
+	// Limits the requests of every IP address and of every client
+	// token. Requests are not limited when disabled
+	IPRateLimit    RateLimit
+	TokenRateLimit RateLimit
+	// Finds the IP address of clients behind trusted proxies. The
+	// address of the peer is used when nil
+	Proxies *clientip.Resolver
+}
+
+// Cluster tells which server of a cluster accepts changes
+type Cluster interface {
+	// IsLeader tells if this server accepts changes
+	IsLeader() bool
+	// LeaderAddress gets the URL of the server accepting
+	// changes, empty when there is none
+	LeaderAddress() string
+	// IsPeer tells if a name of a verified client certificate
+	// is the name of a server of the cluster, which sends
+	// changes to the leader
+	IsPeer(name string) bool
 }
 
 // A simple structure to respond with error messages
@@ -26,12 +74,32 @@ type APIMessage struct {
 
 // Describes the request when checking the access to a feature
 type AccessRequest struct {
-	Groups []string `json:"groups"`
-	User   uint32   `json:"user"`
+	Groups     []string          `json:"groups"`
+	User       uint32            `json:"user"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// Describes the request when checking the access to
+// several features for several users at once
+type BatchAccessRequest struct {
+	// The keys of the features to check. Every feature
+	// is checked when no key is given
+	Features []string `json:"features"`
+	// The users to check
+	Requests []AccessRequest `json:"requests"`
+}
+
+// Describes the response when checking the access to
+// several features for several users at once
+type BatchAccessResponse struct {
+	// The keys of the checked features
+	Features []string `json:"features"`
+	// Access[i][j] tells if the request i has access to the feature j
+	Access [][]bool `json:"access"`
 }
 
 func (handler APIHandler) FeatureIndex(w http.ResponseWriter, r *http.Request) {
-	features, err := handler.FeatureService.GetFeatures()
+	features, err := handler.FeatureService.GetFeatures(r.Context())
 	if err != nil {
 		panic(err)
 	}
@@ -47,13 +115,13 @@ func (handler APIHandler) FeatureShow(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	// Check if the feature exists
-	if !handler.featureExists(vars["featureKey"]) {
+	if !handler.featureExists(r, vars["featureKey"]) {
 		writeNotFound(w)
 		return
 	}
 
 	// Fetch the feature
-	feature, err := handler.FeatureService.GetFeature(vars["featureKey"])
+	feature, err := handler.FeatureService.GetFeature(r.Context(), vars["featureKey"])
 	if err != nil {
 		panic(err)
 	}
@@ -68,26 +136,32 @@ func (handler APIHandler) FeatureShow(w http.ResponseWriter, r *http.Request) {
 func (handler APIHandler) FeaturesAccess(w http.ResponseWriter, r *http.Request) {
 	var ar AccessRequest
 
-	// Get all features in the bucket
-	features, err := handler.FeatureService.GetFeatures()
+	// Get all features and segments
+	snapshot, err := handler.FeatureService.Snapshot(r.Context())
 	if err != nil {
 		panic(err)
 	}
 
 	// Decode the access request
+	_, span := tracer.Start(r.Context(), "DecodeAccessRequest")
 	err = json.NewDecoder(r.Body).Decode(&ar)
+	span.End()
 	if err != nil {
 		writeUnprocessableEntity(err, w)
 		return
 	}
 
 	// Keep only accessible features
+	_, span = tracer.Start(r.Context(), "EvaluateFeatures")
 	accessibleFeatures := make(m.FeatureFlags, 0)
-	for _, feature := range features {
-		if hasAccessToFeature(feature, ar) {
+	for _, feature := range snapshot.Features {
+		access, variant := evaluate(feature, snapshot.Segments, ar)
+		handler.track(feature.Key, access, variant)
+		if access {
 			accessibleFeatures = append(accessibleFeatures, feature)
 		}
 	}
+	span.End()
 
 	w.Header().Set("Content-Type", getJsonHeader())
 	w.WriteHeader(http.StatusOK)
@@ -100,43 +174,102 @@ func (handler APIHandler) FeatureAccess(w http.ResponseWriter, r *http.Request)
 	var ar AccessRequest
 	vars := mux.Vars(r)
 
-	// Check if the feature exists
-	if !handler.featureExists(vars["featureKey"]) {
-		writeNotFound(w)
-		return
+	snapshot, err := handler.FeatureService.Snapshot(r.Context())
+	if err != nil {
+		panic(err)
 	}
 
 	// Fetch the feature
-	feature, err := handler.FeatureService.GetFeature(vars["featureKey"])
-	if err != nil {
-		panic(err)
+	feature, ok := snapshot.Feature(vars["featureKey"])
+	if !ok {
+		writeNotFound(w)
+		return
 	}
 
 	// Decode the access request
+	_, span := tracer.Start(r.Context(), "DecodeAccessRequest")
 	err = json.NewDecoder(r.Body).Decode(&ar)
+	span.End()
 	if err != nil {
 		writeUnprocessableEntity(err, w)
 		return
 	}
 
-	if hasAccessToFeature(feature, ar) {
+	_, span = tracer.Start(r.Context(), "EvaluateFeature")
+	access, variant := evaluate(feature, snapshot.Segments, ar)
+	span.End()
+	handler.track(feature.Key, access, variant)
+	if access {
 		writeMessage(http.StatusOK, "has_access", "The user has access to the feature", w)
 	} else {
 		writeMessage(http.StatusOK, "not_access", "The user does not have access to the feature", w)
 	}
 }
 
+func (handler APIHandler) FeaturesBatchAccess(w http.ResponseWriter, r *http.Request) {
+	var batch BatchAccessRequest
+
+	// Decode the batch access request
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeUnprocessableEntity(err, w)
+		return
+	}
+
+	// Get all features and segments, so that every
+	// request is checked against the same version
+	snapshot, err := handler.FeatureService.Snapshot(r.Context())
+	if err != nil {
+		panic(err)
+	}
+
+	// Keep only requested features
+	features := snapshot.Features
+	if len(batch.Features) > 0 {
+		features = make(m.FeatureFlags, 0, len(batch.Features))
+		for _, key := range batch.Features {
+			feature, ok := snapshot.Feature(key)
+			if !ok {
+				writeNotFound(w)
+				return
+			}
+			features = append(features, feature)
+		}
+	}
+
+	response := BatchAccessResponse{
+		Features: make([]string, 0, len(features)),
+		Access:   make([][]bool, 0, len(batch.Requests)),
+	}
+	for _, feature := range features {
+		response.Features = append(response.Features, feature.Key)
+	}
+
+	for _, ar := range batch.Requests {
+		row := make([]bool, len(features))
+		for i, feature := range features {
+			row[i], _ = evaluate(feature, snapshot.Segments, ar)
+		}
+		response.Access = append(response.Access, row)
+	}
+
+	writeJSON(http.StatusOK, response, w)
+}
+
 func (handler APIHandler) FeatureRemove(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	// Check if the feature exists
-	if !handler.featureExists(vars["featureKey"]) {
+	if !handler.featureExists(r, vars["featureKey"]) {
 		writeNotFound(w)
 		return
 	}
 
 	// Delete it
-	err := handler.FeatureService.RemoveFeature(vars["featureKey"])
+	err := handler.FeatureService.RemoveFeature(r.Context(), vars["featureKey"])
+	if isManagedError(err) {
+		writeManaged(w)
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -157,11 +290,19 @@ func (handler APIHandler) FeatureCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := handler.FeatureService.AddFeature(feature)
-	if err != nil && err.Error() == "Feature already exists" {
+	err := handler.FeatureService.AddFeature(r.Context(), feature)
+	if err != nil && (err.Error() == "Feature already exists" || isMissingSegmentError(err)) {
 		writeMessage(400, "invalid_feature", err.Error(), w)
 		return
 	}
+	if err != nil {
+		panic(err)
+	}
+
+	// The feature as stored, which is never managed
+	if created, err := handler.FeatureService.GetFeature(r.Context(), feature.Key); err == nil {
+		feature = created
+	}
 
 	w.Header().Set("Content-Type", getJsonHeader())
 	w.WriteHeader(http.StatusCreated)
@@ -173,55 +314,168 @@ func (handler APIHandler) FeatureCreate(w http.ResponseWriter, r *http.Request)
 func (handler APIHandler) FeatureEdit(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	// Check if the feature exists
-	if !handler.featureExists(vars["featureKey"]) {
-		writeNotFound(w)
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeUnprocessableEntity(err, w)
 		return
 	}
 
-	// Fetch the feature
-	newFeature, err := handler.FeatureService.GetFeature(vars["featureKey"])
+	// Apply the patch to the current version of the feature, which
+	// cannot change until the result is stored
+	newFeature, err := handler.FeatureService.PatchFeature(r.Context(), vars["featureKey"], func(feature m.FeatureFlag) (patched m.FeatureFlag, err error) {
+		if err = applyPatch(feature, patch, r.Header.Get("Content-Type"), &patched); err != nil {
+			return patched, err
+		}
+
+		// Validate given values
+		if err = patched.Validate(); err != nil {
+			return patched, validationError{err}
+		}
+		return patched, nil
+	})
+
+	switch err.(type) {
+	case patchError:
+		writeMessage(400, "invalid_patch", err.Error(), w)
+		return
+	case decodeError:
+		writeUnprocessableEntity(err, w)
+		return
+	case validationError:
+		writeMessage(400, "invalid_feature", err.Error(), w)
+		return
+	}
+	if err != nil && err.Error() == "Unable to find feature" {
+		writeNotFound(w)
+		return
+	}
+	if isManagedError(err) {
+		writeManaged(w)
+		return
+	}
+	if isMissingSegmentError(err) {
+		writeMessage(400, "invalid_feature", err.Error(), w)
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
 
-	// Update the overwritten fields of the feature
-	if err = json.NewDecoder(r.Body).Decode(&newFeature); err != nil {
-		writeUnprocessableEntity(err, w)
+	w.Header().Set("Content-Type", getJsonHeader())
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(newFeature); err != nil {
+		panic(err)
+	}
+}
+
+func (handler APIHandler) FeatureAddUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	user, err := parseUserID(vars["userID"])
+	if err != nil {
+		writeMessage(400, "invalid_user", err.Error(), w)
 		return
 	}
 
-	// Validate given values
-	if err := newFeature.Validate(); err != nil {
-		writeMessage(400, "invalid_feature", err.Error(), w)
+	handler.editFeature(vars["featureKey"], w, r, func(featureKey string) (m.FeatureFlag, error) {
+		return handler.FeatureService.AddUser(r.Context(), featureKey, user)
+	})
+}
+
+func (handler APIHandler) FeatureRemoveUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	user, err := parseUserID(vars["userID"])
+	if err != nil {
+		writeMessage(400, "invalid_user", err.Error(), w)
+		return
+	}
+
+	handler.editFeature(vars["featureKey"], w, r, func(featureKey string) (m.FeatureFlag, error) {
+		return handler.FeatureService.RemoveUser(r.Context(), featureKey, user)
+	})
+}
+
+func (handler APIHandler) FeatureAddGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	handler.editFeature(vars["featureKey"], w, r, func(featureKey string) (m.FeatureFlag, error) {
+		return handler.FeatureService.AddGroup(r.Context(), featureKey, vars["group"])
+	})
+}
+
+func (handler APIHandler) FeatureRemoveGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	handler.editFeature(vars["featureKey"], w, r, func(featureKey string) (m.FeatureFlag, error) {
+		return handler.FeatureService.RemoveGroup(r.Context(), featureKey, vars["group"])
+	})
+}
+
+// Apply an edit to an existing feature and respond with the updated feature
+func (handler APIHandler) editFeature(featureKey string, w http.ResponseWriter, r *http.Request, edit func(string) (m.FeatureFlag, error)) {
+	// Check if the feature exists
+	if !handler.featureExists(r, featureKey) {
+		writeNotFound(w)
 		return
 	}
 
-	newFeature, err = handler.FeatureService.UpdateFeature(vars["featureKey"], newFeature)
+	feature, err := edit(featureKey)
+	if isManagedError(err) {
+		writeManaged(w)
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
 
 	w.Header().Set("Content-Type", getJsonHeader())
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(newFeature); err != nil {
+	if err := json.NewEncoder(w).Encode(feature); err != nil {
 		panic(err)
 	}
 }
 
-func (handler APIHandler) featureExists(featureKey string) bool {
-	return handler.FeatureService.FeatureExists(featureKey)
+func (handler APIHandler) featureExists(r *http.Request, featureKey string) bool {
+	return handler.FeatureService.FeatureExists(r.Context(), featureKey)
 }
 
 func getJsonHeader() string {
 	return "application/json"
 }
 
+func writeJSON(code int, value interface{}, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", getJsonHeader())
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		panic(err)
+	}
+}
+
 func writeNotFound(w http.ResponseWriter) {
 	writeMessage(http.StatusNotFound, "feature_not_found", "The feature was not found", w)
 }
 
+func writeManaged(w http.ResponseWriter) {
+	writeMessage(http.StatusForbidden, "managed_feature", "The feature is managed by configuration files and cannot be changed through the API", w)
+}
+
+// Tell if a change was refused because a feature is managed by configuration files
+func isManagedError(err error) bool {
+	return err != nil && err.Error() == "Feature is managed by configuration files"
+}
+
+// Tell if a feature flag was refused because it references a segment
+// which does not exist
+func isMissingSegmentError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "Segment ") && strings.HasSuffix(err.Error(), " does not exist")
+}
+
 func writeUnprocessableEntity(err error, w http.ResponseWriter) {
+	if isBodyTooLarge(err) {
+		writeRequestTooLarge(w)
+		return
+	}
 	writeMessage(422, "invalid_json", "Cannot decode the given JSON payload", w)
 }
 
@@ -234,25 +488,11 @@ func writeMessage(code int, status string, message string, w http.ResponseWriter
 	w.Write(bytes)
 }
 
-func hasAccessToFeature(feature m.FeatureFlag, ar AccessRequest) bool {
-	// Handle trivial case
-	if feature.IsEnabled() {
-		return true
+// Parse a user ID given in a URL
+func parseUserID(value string) (uint32, error) {
+	user, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || user == 0 {
+		return 0, fmt.Errorf("User ID must be a positive integer")
 	}
-
-	// Access thanks to a group?
-	if len(ar.Groups) > 0 {
-		for _, group := range ar.Groups {
-			if feature.GroupHasAccess(group) {
-				return true
-			}
-		}
-	}
-
-	// Access thanks to the user?
-	if ar.User > 0 {
-		return feature.UserHasAccess(ar.User)
-	}
-
-	return false
+	return uint32(user), nil
 }
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 
 	db "github.com/antoineaugusti/feature-flags/db"
 	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
 	s "github.com/antoineaugusti/feature-flags/services"
 	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
@@ -28,7 +30,11 @@ var (
 
 func onStart() {
 	database = getTestDB()
-	server = httptest.NewServer(NewRouter(APIHandler{FeatureService: s.FeatureService{DB: database}}))
+	service, err := s.NewFeatureService(repos.NewBoltStore(database))
+	if err != nil {
+		panic(err)
+	}
+	server = httptest.NewServer(NewRouter(APIHandler{FeatureService: service}))
 	base = fmt.Sprintf("%s/features", server.URL)
 }
 
@@ -170,6 +176,199 @@ func TestEditFeatureFlag(t *testing.T) {
 	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_feature", "Percentage must be between 0 and 100")
 }
 
+func TestMergePatchFeatureFlag(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	// Add the default dummy feature
+	createDummyFeatureFlag()
+
+	url := fmt.Sprintf("%s/%s", base, "homepage_v2")
+
+	// Set a percentage, other fields are left untouched
+	res := patchFeature(url, "application/merge-patch+json", `{"percentage":42}`)
+
+	assertJSONMatchesStructure(
+		t, res,
+		"homepage_v2",
+		false,
+		[]int{2},
+		[]string{"dev", "admin"},
+		42,
+	)
+
+	// Clear users with null, groups with an empty list
+	// and set the percentage back to 0
+	res = patchFeature(url, "application/merge-patch+json", `{"users":null,"groups":[],"percentage":0}`)
+
+	assertJSONMatchesStructure(
+		t, res,
+		"homepage_v2",
+		false,
+		[]int{},
+		[]string{},
+		0,
+	)
+
+	// The key cannot be changed
+	res = patchFeature(url, "application/json", `{"key":"other_key"}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, getService().FeatureExists(context.Background(), "homepage_v2"))
+	assert.False(t, getService().FeatureExists(context.Background(), "other_key"))
+}
+
+func TestJSONPatchFeatureFlag(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	// Add the default dummy feature
+	createDummyFeatureFlag()
+
+	url := fmt.Sprintf("%s/%s", base, "homepage_v2")
+
+	// Add a user and remove a group
+	payload := `[
+      {"op":"add","path":"/users/-","value":42},
+      {"op":"remove","path":"/groups/0"},
+      {"op":"replace","path":"/enabled","value":true}
+    ]`
+	res := patchFeature(url, "application/json-patch+json", payload)
+
+	assertJSONMatchesStructure(
+		t, res,
+		"homepage_v2",
+		true,
+		[]int{2, 42},
+		[]string{"admin"},
+		0,
+	)
+
+	// A failing test operation leaves the feature untouched
+	payload = `[
+      {"op":"test","path":"/percentage","value":10},
+      {"op":"replace","path":"/percentage","value":20}
+    ]`
+	res = patchFeature(url, "application/json-patch+json", payload)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	feature, _ := getService().GetFeature(context.Background(), "homepage_v2")
+	assert.Equal(t, uint32(0), feature.Percentage)
+
+	// Remove an unexisting user
+	res = patchFeature(url, "application/json-patch+json", `[{"op":"remove","path":"/users/5"}]`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Invalid JSON Patch document
+	res = patchFeature(url, "application/json-patch+json", `{"op":"remove"}`)
+	assert422Response(t, res)
+
+	// The patched feature is validated
+	res = patchFeature(url, "application/json-patch+json", `[{"op":"replace","path":"/percentage","value":101}]`)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_feature", "Percentage must be between 0 and 100")
+}
+
+func TestEditFeatureFlagMembers(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	// Add the default dummy feature
+	createDummyFeatureFlag()
+
+	url := fmt.Sprintf("%s/%s", base, "homepage_v2")
+
+	// Add a user
+	request, _ := http.NewRequest("POST", url+"/users/42", nil)
+	res, _ := http.DefaultClient.Do(request)
+
+	assertJSONMatchesStructure(
+		t, res,
+		"homepage_v2",
+		false,
+		[]int{2, 42},
+		[]string{"dev", "admin"},
+		0,
+	)
+
+	// Remove a user
+	request, _ = http.NewRequest("DELETE", url+"/users/2", nil)
+	res, _ = http.DefaultClient.Do(request)
+
+	assertJSONMatchesStructure(
+		t, res,
+		"homepage_v2",
+		false,
+		[]int{42},
+		[]string{"dev", "admin"},
+		0,
+	)
+
+	// Add a group
+	request, _ = http.NewRequest("POST", url+"/groups/beta", nil)
+	res, _ = http.DefaultClient.Do(request)
+
+	assertJSONMatchesStructure(
+		t, res,
+		"homepage_v2",
+		false,
+		[]int{42},
+		[]string{"dev", "admin", "beta"},
+		0,
+	)
+
+	// Remove a group
+	request, _ = http.NewRequest("DELETE", url+"/groups/dev", nil)
+	res, _ = http.DefaultClient.Do(request)
+
+	assertJSONMatchesStructure(
+		t, res,
+		"homepage_v2",
+		false,
+		[]int{42},
+		[]string{"admin", "beta"},
+		0,
+	)
+
+	// Invalid user IDs
+	for _, user := range []string{"0", "foo", "4294967296"} {
+		request, _ = http.NewRequest("POST", url+"/users/"+user, nil)
+		res, _ = http.DefaultClient.Do(request)
+
+		assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_user", "User ID must be a positive integer")
+	}
+
+	// Edit an unexisting feature
+	request, _ = http.NewRequest("POST", fmt.Sprintf("%s/%s/groups/dev", base, "notfound"), nil)
+	res, _ = http.DefaultClient.Do(request)
+
+	assert404Response(t, res)
+}
+
+func TestEditManagedFeatureFlag(t *testing.T) {
+	var feature m.FeatureFlag
+	onStart()
+	defer onFinish()
+
+	_, _ = getService().Reconcile(context.Background(), m.FeatureFlags{{Key: "homepage_v2"}}, false, false)
+	url := fmt.Sprintf("%s/%s", base, "homepage_v2")
+
+	res := patchFeature(url, "application/json", `{"enabled":true}`)
+	assertResponseWithStatusAndMessage(t, res, http.StatusForbidden, "managed_feature", "The feature is managed by configuration files and cannot be changed through the API")
+
+	request, _ := http.NewRequest("POST", url+"/users/42", nil)
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusForbidden, "managed_feature", "The feature is managed by configuration files and cannot be changed through the API")
+
+	request, _ = http.NewRequest("DELETE", url, nil)
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusForbidden, "managed_feature", "The feature is managed by configuration files and cannot be changed through the API")
+
+	// Features created through the API are never managed
+	res = createFeatureWithPayload(`{"key":"portfolio","managed":true}`)
+	json.NewDecoder(res.Body).Decode(&feature)
+	assert.False(t, feature.Managed)
+	assert.Nil(t, getService().RemoveFeature(context.Background(), "portfolio"))
+}
+
 func TestAccessFeatureFlags(t *testing.T) {
 	var features m.FeatureFlags
 	onStart()
@@ -261,6 +460,50 @@ func TestAccessFeatureFlag(t *testing.T) {
 	assertAccessToTheFeature(t, res)
 }
 
+func TestBatchAccessFeatureFlags(t *testing.T) {
+	var response BatchAccessResponse
+	onStart()
+	defer onFinish()
+
+	url := fmt.Sprintf("%s/access/batch", base)
+
+	// Add the default dummy feature and a disabled feature
+	createDummyFeatureFlag()
+	createFeatureWithPayload(`{"key":"testflag","enabled":false}`)
+
+	// Invalid JSON payload
+	reader = strings.NewReader(`{foo:bar}`)
+	request, _ := http.NewRequest("POST", url, reader)
+	res, _ := http.DefaultClient.Do(request)
+	assert422Response(t, res)
+
+	// Check every feature
+	reader = strings.NewReader(`{"requests":[{"user":2},{"user":3},{"groups":["admin"]}]}`)
+	request, _ = http.NewRequest("POST", url, reader)
+	res, _ = http.DefaultClient.Do(request)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&response)
+	assert.Equal(t, []string{"homepage_v2", "testflag"}, response.Features)
+	assert.Equal(t, [][]bool{{true, false}, {false, false}, {true, false}}, response.Access)
+
+	// Check specific features
+	reader = strings.NewReader(`{"features":["testflag","homepage_v2"],"requests":[{"user":2}]}`)
+	request, _ = http.NewRequest("POST", url, reader)
+	res, _ = http.DefaultClient.Do(request)
+
+	json.NewDecoder(res.Body).Decode(&response)
+	assert.Equal(t, []string{"testflag", "homepage_v2"}, response.Features)
+	assert.Equal(t, [][]bool{{false, true}}, response.Access)
+
+	// Check an unexisting feature
+	reader = strings.NewReader(`{"features":["notfound"],"requests":[{"user":2}]}`)
+	request, _ = http.NewRequest("POST", url, reader)
+	res, _ = http.DefaultClient.Do(request)
+
+	assert404Response(t, res)
+}
+
 func TestListFeatureFlags(t *testing.T) {
 	var features m.FeatureFlags
 	onStart()
@@ -302,6 +545,22 @@ func createFeatureWithPayload(payload string) *http.Response {
 	return res
 }
 
+func patchFeature(url, contentType, payload string) *http.Response {
+	reader = strings.NewReader(payload)
+	request, _ := http.NewRequest("PATCH", url, reader)
+	request.Header.Set("Content-Type", contentType)
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		panic(err)
+	}
+
+	return res
+}
+
+func getService() *s.FeatureService {
+	return &s.FeatureService{Store: repos.NewBoltStore(database)}
+}
+
 func createDummyFeatureFlag() *http.Response {
 	return createFeatureWithPayload(getDummyFeaturePayload())
 }
@@ -0,0 +1,27 @@
+package http
+
+import (
+	"net/http"
+
+	build "github.com/antoineaugusti/feature-flags/build"
+)
+
+// The server is alive as long as it responds
+func (handler APIHandler) Health(w http.ResponseWriter, r *http.Request) {
+	writeMessage(http.StatusOK, "alive", "The server is alive", w)
+}
+
+func (handler APIHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	if handler.Ready != nil {
+		if err := handler.Ready(); err != nil {
+			writeMessage(http.StatusServiceUnavailable, "not_ready", err.Error(), w)
+			return
+		}
+	}
+
+	writeMessage(http.StatusOK, "ready", "The server is ready to serve requests", w)
+}
+
+func (handler APIHandler) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(http.StatusOK, build.GetInfo(), w)
+}
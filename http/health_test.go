@@ -0,0 +1,60 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	build "github.com/antoineaugusti/feature-flags/build"
+	db "github.com/antoineaugusti/feature-flags/db"
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	res, _ := http.Get(server.URL + "/healthz")
+	assertResponseWithStatusAndMessage(t, res, http.StatusOK, "alive", "The server is alive")
+
+	// Always ready without a check
+	res, _ = http.Get(server.URL + "/readyz")
+	assertResponseWithStatusAndMessage(t, res, http.StatusOK, "ready", "The server is ready to serve requests")
+}
+
+func TestReadiness(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	ready := func() error { return db.CheckBucket(db.GetBucketName(), database) }
+	server := httptest.NewServer(NewRouter(APIHandler{FeatureService: getService(), Ready: ready}))
+	defer server.Close()
+
+	res, _ := http.Get(server.URL + "/readyz")
+	assertResponseWithStatusAndMessage(t, res, http.StatusOK, "ready", "The server is ready to serve requests")
+
+	_ = database.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(db.GetBucketName()))
+	})
+	res, _ = http.Get(server.URL + "/readyz")
+	assertResponseWithStatusAndMessage(t, res, http.StatusServiceUnavailable, "not_ready", "The features bucket does not exist")
+}
+
+func TestVersion(t *testing.T) {
+	var info build.Info
+	onStart()
+	defer onFinish()
+
+	build.Version = "1.2.0"
+	defer func() { build.Version = "dev" }()
+
+	res, _ := http.Get(server.URL + "/version")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&info)
+	assert.Equal(t, "1.2.0", info.Version)
+	assert.NotEmpty(t, info.Commit)
+	assert.NotEmpty(t, info.GoVersion)
+	assert.False(t, info.StartedAt.IsZero())
+}
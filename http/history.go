@@ -0,0 +1,23 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (handler APIHandler) FeatureHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Removed feature flags still have a history
+	history, err := handler.FeatureService.FeatureHistory(r.Context(), vars["featureKey"])
+	if err != nil {
+		panic(err)
+	}
+	if len(history) == 0 && !handler.featureExists(r, vars["featureKey"]) {
+		writeNotFound(w)
+		return
+	}
+
+	writeJSON(http.StatusOK, history, w)
+}
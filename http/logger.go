@@ -1,47 +1,146 @@
 package http
 
 import (
-	"log"
-	"net"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
 	"net/http"
 	"time"
+
+	clientip "github.com/antoineaugusti/feature-flags/clientip"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Serve and log an incoming request
+// The header holding the ID of a request
+const requestIDHeader = "X-Request-ID"
+
+// Request IDs sent by clients are ignored when they are longer
+const maxRequestIDLength = 128
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	clientIPKey
+)
+
+// Records the status code and the size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(bytes []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(bytes)
+	r.size += n
+	return n, err
+}
+
+// Unwrap gives the original response writer to http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Serve and log an incoming request. The request ID is taken from
+// the X-Request-ID header, or generated, and sent back
 func Logger(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		inner.ServeHTTP(w, r)
+		id := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+		// Requests forwarded to another server keep their ID
+		r.Header.Set(requestIDHeader, id)
+		w.Header().Set(requestIDHeader, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		inner.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		}
+
+		attributes := []slog.Attr{slog.String("request_id", id)}
+		if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+			attributes = append(attributes, slog.String("trace_id", span.TraceID().String()))
+		}
 
-		log.Printf(
-			"%s\t%s\t%s\t%s\t%s",
-			getIPAddress(r),
-			r.Method,
-			r.RequestURI,
-			name,
-			time.Since(start),
-		)
+		slog.Default().LogAttrs(r.Context(), level, "request", append(attributes,
+			slog.String("ip", requestIP(r)),
+			slog.String("method", r.Method),
+			slog.String("uri", r.RequestURI),
+			slog.String("route", name),
+			slog.Int("status", recorder.status),
+			slog.Int("size", recorder.size),
+			slog.Duration("duration", time.Since(start)),
+		)...)
 	})
 }
 
-// Extract the IP address from a request
-func getIPAddress(r *http.Request) string {
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+// RequestID gets the ID of the request being served
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
 
-	ips := []string{
-		// Forwarded headers first
-		r.Header.Get("X-Forwarded-For"),
-		r.Header.Get("x-forwarded-for"),
-		r.Header.Get("X-FORWARDED-FOR"),
-		// Client IP address by default
-		ip,
+// Accept request IDs made of printable ASCII characters
+func isValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
 	}
 
-	for _, ip := range ips {
-		if len(ip) > 0 {
-			return ip
+	for _, c := range id {
+		if c < ' ' || c > '~' {
+			return false
 		}
 	}
-	return ""
+	return true
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// Find the IP address of the client of a request, then serve it.
+// Forwarding headers are ignored when resolver is nil
+func identifyClient(resolver *clientip.Resolver, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolver.ClientIP(r)
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+	})
+}
+
+// ClientIP gets the IP address of the client of the request being
+// served, behind trusted proxies
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// Get the IP address of the client of a request. Requests which were
+// not identified by NewRouter, when Logger or Trace are used on their
+// own, get the address of the peer
+func requestIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+
+	var resolver *clientip.Resolver
+	return resolver.ClientIP(r)
 }
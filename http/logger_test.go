@@ -0,0 +1,82 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	clientip "github.com/antoineaugusti/feature-flags/clientip"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	var line map[string]interface{}
+	onStart()
+	defer onFinish()
+
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buffer, nil)))
+
+	// The request ID of the client is sent back
+	request, _ := http.NewRequest("GET", base+"/notfound", nil)
+	request.Header.Set("X-Request-ID", "abc-42")
+	res, _ := http.DefaultClient.Do(request)
+	assert.Equal(t, "abc-42", res.Header.Get("X-Request-ID"))
+
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, "INFO", line["level"])
+	assert.Equal(t, "abc-42", line["request_id"])
+	assert.Equal(t, "GET", line["method"])
+	assert.Equal(t, "/features/notfound", line["uri"])
+	assert.Equal(t, "FeatureShow", line["route"])
+	assert.Equal(t, float64(404), line["status"])
+	assert.Equal(t, float64(len(`{"status":"feature_not_found","message":"The feature was not found"}`)), line["size"])
+
+	// A request ID is generated otherwise
+	buffer.Reset()
+	res, _ = http.Get(base)
+	assert.Equal(t, 32, len(res.Header.Get("X-Request-ID")))
+
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, res.Header.Get("X-Request-ID"), line["request_id"])
+	assert.Equal(t, float64(200), line["status"])
+}
+
+func TestLoggerClientIP(t *testing.T) {
+	var buffer bytes.Buffer
+	var line map[string]interface{}
+	onStart()
+	defer onFinish()
+
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buffer, nil)))
+
+	serve := func(api APIHandler) string {
+		buffer.Reset()
+		request := httptest.NewRequest("GET", "/features", nil)
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", "1.2.3.4, 198.51.100.1")
+		NewRouter(api).ServeHTTP(httptest.NewRecorder(), request)
+
+		assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+		return line["ip"].(string)
+	}
+
+	// Forwarding headers are ignored without trusted proxies
+	assert.Equal(t, "10.0.0.1", serve(APIHandler{FeatureService: getService()}))
+
+	proxies, _ := clientip.Parse("10.0.0.0/8", clientip.HeaderXForwardedFor)
+	assert.Equal(t, "198.51.100.1", serve(APIHandler{FeatureService: getService(), Proxies: proxies}))
+
+	// The address of the peer is logged outside of the router
+	buffer.Reset()
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	Logger(http.NotFoundHandler(), "NotFound").ServeHTTP(httptest.NewRecorder(), request)
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, "10.0.0.1", line["ip"])
+}
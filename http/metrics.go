@@ -0,0 +1,43 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	analytics "github.com/antoineaugusti/feature-flags/analytics"
+	metrics "github.com/antoineaugusti/feature-flags/metrics"
+	m "github.com/antoineaugusti/feature-flags/models"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func (handler APIHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	promhttp.Handler().ServeHTTP(w, r)
+}
+
+// Count and time the requests served by a route
+func Instrument(inner http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		inner.ServeHTTP(recorder, r)
+
+		metrics.Requests.WithLabelValues(name, r.Method, strconv.Itoa(recorder.status)).Inc()
+		metrics.RequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Check the access to a feature and count the result
+func evaluate(feature m.FeatureFlag, segments map[string]m.Segment, ar AccessRequest) (bool, string) {
+	variant := feature.AccessReason(ar.User, ar.Groups, ar.Attributes, segments)
+	access := variant != m.AccessNone
+
+	result := analytics.ResultNotAccess
+	if access {
+		result = analytics.ResultHasAccess
+	}
+	metrics.Evaluations.WithLabelValues(feature.Key, result).Inc()
+
+	return access, variant
+}
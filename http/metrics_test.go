@@ -0,0 +1,33 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	createDummyFeatureFlag()
+	http.Post(base+"/homepage_v2/access", "application/json", strings.NewReader(`{"user":2}`))
+	http.Post(base+"/homepage_v2/access", "application/json", strings.NewReader(`{"user":3}`))
+	http.Get(base + "/notfound")
+
+	res, _ := http.Get(server.URL + "/metrics")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, _ := ioutil.ReadAll(res.Body)
+	metrics := string(body)
+
+	assert.Contains(t, metrics, `feature_flags_http_requests_total{code="201",method="POST",route="FeatureCreate"}`)
+	assert.Contains(t, metrics, `feature_flags_http_requests_total{code="404",method="GET",route="FeatureShow"}`)
+	assert.Contains(t, metrics, `feature_flags_http_request_duration_seconds_bucket{route="FeatureAccess",le="+Inf"}`)
+	assert.Contains(t, metrics, `feature_flags_bolt_transaction_duration_seconds_count{type="update"}`)
+	assert.Contains(t, metrics, "feature_flags_features 1\n")
+	assert.Contains(t, metrics, `feature_flags_evaluations_total{feature="homepage_v2",result="has_access"}`)
+	assert.Contains(t, metrics, `feature_flags_evaluations_total{feature="homepage_v2",result="not_access"}`)
+}
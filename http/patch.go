@@ -0,0 +1,73 @@
+package http
+
+import (
+	"encoding/json"
+	"mime"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// Error returned when a patch is well formed but cannot be applied
+type patchError struct {
+	err error
+}
+
+func (e patchError) Error() string {
+	return e.err.Error()
+}
+
+// Error returned when a patch, or the value it gives, cannot be decoded
+type decodeError struct {
+	err error
+}
+
+func (e decodeError) Error() string {
+	return e.err.Error()
+}
+
+// Error returned when a patch gives an invalid value
+type validationError struct {
+	err error
+}
+
+func (e validationError) Error() string {
+	return e.err.Error()
+}
+
+// Apply a patch to the JSON representation of a value and decode the
+// result in patched. A JSON Patch (RFC 6902) is used when the content
+// type is application/json-patch+json, a JSON Merge Patch (RFC 7396)
+// otherwise
+func applyPatch(value interface{}, patch []byte, contentType string, patched interface{}) error {
+	original, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var result []byte
+	if isJSONPatch(contentType) {
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return decodeError{err}
+		}
+
+		if result, err = operations.Apply(original); err != nil {
+			return patchError{err}
+		}
+	} else {
+		if result, err = jsonpatch.MergePatch(original, patch); err != nil {
+			return decodeError{err}
+		}
+	}
+
+	if err = json.Unmarshal(result, patched); err != nil {
+		return decodeError{err}
+	}
+	return nil
+}
+
+// Tell if a content type describes a JSON Patch document
+func isJSONPatch(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json-patch+json"
+}
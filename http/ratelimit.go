@@ -0,0 +1,207 @@
+package http
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	helpers "github.com/antoineaugusti/feature-flags/helpers"
+)
+
+// The default maximum size of the body of a request, in bytes
+const DefaultMaxBodyBytes = 1 << 20
+
+// The maximum number of clients whose token bucket is kept in memory
+const maxBuckets = 100000
+
+// Routes used by health checks and monitoring, which are not limited
+var unlimitedRoutes = []string{"Health", "Readiness", "Metrics"}
+
+// RateLimit is a token bucket: a client sends at most Burst requests
+// at once, then Rate requests per second
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled tells if requests are limited
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// The token buckets of clients, at most max of them. The list orders
+// the buckets from the most to the least recently used
+type limiter struct {
+	limit RateLimit
+	max   int
+
+	mu        sync.Mutex
+	buckets   map[string]*list.Element
+	recent    *list.List
+	lastSweep time.Time
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(limit RateLimit) *limiter {
+	return &limiter{limit: limit, max: maxBuckets, buckets: make(map[string]*list.Element), recent: list.New()}
+}
+
+// Take a token from the bucket of a client. When the bucket is empty,
+// tell how long to wait for the next token
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	var b *bucket
+	if element, ok := l.buckets[key]; ok {
+		l.recent.MoveToFront(element)
+		b = element.Value.(*bucket)
+	} else {
+		if len(l.buckets) >= l.max {
+			l.evict()
+		}
+		b = &bucket{key: key, tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = l.recent.PushFront(b)
+	}
+
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Forget the buckets which are full again, at most once a minute
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for element := l.recent.Back(); element != nil; element = l.recent.Back() {
+		if now.Sub(element.Value.(*bucket).last) <= refill {
+			return
+		}
+		l.remove(element)
+	}
+}
+
+// Forget the bucket of the client without request for the longest time
+func (l *limiter) evict() {
+	if element := l.recent.Back(); element != nil {
+		l.remove(element)
+	}
+}
+
+func (l *limiter) remove(element *list.Element) {
+	l.recent.Remove(element)
+	delete(l.buckets, element.Value.(*bucket).key)
+}
+
+// Refuse requests of clients sending too many requests, by IP address
+// and by client certificate. Limits are shared by every route given the
+// limiters. Changes sent to the leader by another server of the cluster,
+// with its certificate, were limited by the server receiving them
+func rateLimit(ips, tokens *limiter, cluster Cluster, route Route, next http.Handler) http.Handler {
+	if helpers.StringInSlice(route.Name, unlimitedRoutes) {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cluster != nil && isForwarded(cluster, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+
+		if ips != nil {
+			if ok, wait := ips.allow(ClientIP(r.Context()), now); !ok {
+				writeTooManyRequests(wait, w)
+				return
+			}
+		}
+
+		if token := clientToken(r); tokens != nil && len(token) > 0 {
+			if ok, wait := tokens.allow(token, now); !ok {
+				writeTooManyRequests(wait, w)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Tell if a request was sent by another server of the cluster, from
+// the verified certificate it presents. The address of the request is
+// not enough: it is shared by every client behind the same network
+func isForwarded(cluster Cluster, r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	names := ClientIdentities(r)
+	for _, ip := range r.TLS.PeerCertificates[0].IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	for _, name := range names {
+		if cluster.IsPeer(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identify the client of a request by its verified certificate. Empty
+// when it has none: unverified credentials could be changed at will
+func clientToken(r *http.Request) string {
+	if identities := ClientIdentities(r); len(identities) > 0 {
+		return identities[0]
+	}
+	return ""
+}
+
+func writeTooManyRequests(wait time.Duration, w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeMessage(http.StatusTooManyRequests, "rate_limited", "Too many requests, try again later", w)
+}
+
+// Refuse request bodies larger than max bytes
+func limitBody(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			writeRequestTooLarge(w)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Tell if a request body could not be read because it is too large
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+func writeRequestTooLarge(w http.ResponseWriter) {
+	writeMessage(http.StatusRequestEntityTooLarge, "request_too_large", "The request body is too large", w)
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 2, Burst: 3})
+	now := time.Now()
+
+	// The burst is available at once
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow("a", now)
+		assert.True(t, ok)
+	}
+	ok, wait := l.allow("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other clients have their own bucket
+	ok, _ = l.allow("b", now)
+	assert.True(t, ok)
+
+	// Tokens come back at the rate
+	ok, _ = l.allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = l.allow("a", now.Add(500*time.Millisecond))
+	assert.False(t, ok)
+
+	// Full buckets are forgotten
+	l.allow("c", now.Add(2*time.Minute))
+	assert.Len(t, l.buckets, 1)
+	assert.Equal(t, 1, l.recent.Len())
+}
+
+func TestLimiterMaxBuckets(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 2, Burst: 3})
+	l.max = 2
+	now := time.Now()
+
+	l.allow("a", now)
+	l.allow("b", now.Add(time.Second))
+	l.allow("a", now.Add(2*time.Second))
+
+	// The least recently seen client is forgotten
+	l.allow("c", now.Add(3*time.Second))
+	assert.Len(t, l.buckets, 2)
+	assert.Contains(t, l.buckets, "a")
+	assert.Contains(t, l.buckets, "c")
+	assert.Equal(t, 2, l.recent.Len())
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	router := NewRouter(APIHandler{FeatureService: getService(), IPRateLimit: RateLimit{Rate: 0.1, Burst: 2}})
+	serve := func(path, remoteAddr string) *http.Response {
+		request := httptest.NewRequest("GET", path, nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/features", "10.0.0.1:1234").StatusCode)
+	assert.Equal(t, http.StatusOK, serve("/segments", "10.0.0.1:1235").StatusCode)
+
+	res := serve("/features", "10.0.0.1:1236")
+	assertResponseWithStatusAndMessage(t, res, http.StatusTooManyRequests, "rate_limited", "Too many requests, try again later")
+	assert.Equal(t, "10", res.Header.Get("Retry-After"))
+
+	// Other addresses and health checks are not limited
+	assert.Equal(t, http.StatusOK, serve("/features", "10.0.0.2:1234").StatusCode)
+	assert.Equal(t, http.StatusOK, serve("/healthz", "10.0.0.1:1237").StatusCode)
+}
+
+func TestRateLimitByToken(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	router := NewRouter(APIHandler{FeatureService: getService(), TokenRateLimit: RateLimit{Rate: 0.1, Burst: 1}})
+	serve := func(request *http.Request) int {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	first := &x509.Certificate{Subject: pkix.Name{CommonName: "first"}}
+	second := &x509.Certificate{Subject: pkix.Name{CommonName: "second"}}
+
+	assert.Equal(t, http.StatusOK, serve(requestWithCertificate("GET", "/features", "", first)))
+	assert.Equal(t, http.StatusTooManyRequests, serve(requestWithCertificate("GET", "/features", "", first)))
+	assert.Equal(t, http.StatusOK, serve(requestWithCertificate("GET", "/features", "", second)))
+
+	// Requests without a certificate are only limited by IP address,
+	// unverified credentials are ignored
+	for i := 0; i < 2; i++ {
+		request := requestWithCertificate("GET", "/features", "", nil)
+		request.Header.Set("Authorization", "Bearer first")
+		assert.Equal(t, http.StatusOK, serve(request))
+	}
+}
+
+// A cluster of which this server is the leader
+type leaderCluster struct {
+	peers []string
+}
+
+func (c leaderCluster) IsLeader() bool        { return true }
+func (c leaderCluster) LeaderAddress() string { return "" }
+func (c leaderCluster) IsPeer(name string) bool {
+	for _, peer := range c.peers {
+		if peer == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRateLimitForwarded(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	cluster := leaderCluster{peers: []string{"node-b.example.com", "10.0.0.3"}}
+	router := NewRouter(APIHandler{FeatureService: getService(), Cluster: cluster, IPRateLimit: RateLimit{Rate: 0.1, Burst: 1}})
+	serve := func(remoteAddr string, certificate *x509.Certificate) int {
+		request := requestWithCertificate("GET", "/features", "", certificate)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", nil))
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", nil))
+
+	// Requests sent by other servers were limited by these servers
+	byName := &x509.Certificate{DNSNames: []string{"node-b.example.com"}}
+	byIP := &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.3")}}
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", byName))
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", byIP))
+	}
+
+	// The address of a server is not enough
+	assert.Equal(t, http.StatusOK, serve("10.0.0.3:1234", nil))
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.3:1234", nil))
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	onStart()
+	defer onFinish()
+
+	server := httptest.NewServer(NewRouter(APIHandler{FeatureService: getService(), MaxBodyBytes: 512}))
+	defer server.Close()
+
+	large := `{"key":"homepage_v2","groups":["` + strings.Repeat("a", 1024) + `"]}`
+
+	// Refused from the Content-Length header
+	res, _ := http.Post(server.URL+"/features", "application/json", strings.NewReader(large))
+	assertResponseWithStatusAndMessage(t, res, http.StatusRequestEntityTooLarge, "request_too_large", "The request body is too large")
+
+	// Refused while reading a body without length
+	res, _ = http.Post(server.URL+"/features/access", "application/json", io.MultiReader(strings.NewReader(large)))
+	assertResponseWithStatusAndMessage(t, res, http.StatusRequestEntityTooLarge, "request_too_large", "The request body is too large")
+
+	res, _ = http.Post(server.URL+"/features", "application/json", strings.NewReader(getDummyFeaturePayload()))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	request, _ := http.NewRequest("PATCH", server.URL+"/features/homepage_v2", io.MultiReader(strings.NewReader(large)))
+	request.Header.Set("Content-Type", "application/merge-patch+json")
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusRequestEntityTooLarge, "request_too_large", "The request body is too large")
+}
@@ -2,7 +2,10 @@ package http
 
 import (
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 
+	helpers "github.com/antoineaugusti/feature-flags/helpers"
 	"github.com/gorilla/mux"
 )
 
@@ -10,11 +13,41 @@ import (
 func NewRouter(api APIHandler) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
+	maxBodyBytes := api.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	// Limits are shared by every route
+	var ips, tokens *limiter
+	if api.IPRateLimit.Enabled() {
+		ips = newLimiter(api.IPRateLimit)
+	}
+	if api.TokenRateLimit.Enabled() {
+		tokens = newLimiter(api.TokenRateLimit)
+	}
+
 	for _, route := range getRoutes(api) {
 		var handler http.Handler
 
 		handler = route.HandlerFunc
+		if api.ReadOnly && !isReadRoute(route) {
+			handler = http.HandlerFunc(rejectWrite)
+		}
+		if api.Cluster != nil && !isReadRoute(route) {
+			handler = forwardToLeader(api.Cluster, api.Transport, handler)
+		}
+		if api.RequireClientCertificate || len(api.Roles) > 0 {
+			handler = authorize(api.Roles, route, handler)
+		}
+		handler = limitBody(maxBodyBytes, handler)
+		if ips != nil || tokens != nil {
+			handler = rateLimit(ips, tokens, api.Cluster, route, handler)
+		}
+		handler = Instrument(handler, route.Name)
 		handler = Logger(handler, route.Name)
+		handler = Trace(handler, route.Name, route.Pattern)
+		handler = identifyClient(api.Proxies, handler)
 
 		router.
 			Methods(route.Method).
@@ -25,3 +58,34 @@ func NewRouter(api APIHandler) *mux.Router {
 
 	return router
 }
+
+// Tell if a route does not modify feature flags or segments
+func isReadRoute(route Route) bool {
+	readRoutes := []string{"FeaturesAccess", "FeatureAccess", "FeaturesBatchAccess"}
+	return route.Method == "GET" || helpers.StringInSlice(route.Name, readRoutes)
+}
+
+func rejectWrite(w http.ResponseWriter, r *http.Request) {
+	writeMessage(http.StatusForbidden, "read_only", "This server is a read-only replica, send changes to the primary server", w)
+}
+
+// Send changes to the leader of the cluster when this server is not the leader
+func forwardToLeader(cluster Cluster, transport http.RoundTripper, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cluster.IsLeader() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leader, err := url.Parse(cluster.LeaderAddress())
+		if err != nil || len(leader.Host) == 0 {
+			writeMessage(http.StatusServiceUnavailable, "no_leader", "The cluster has no leader, try again later", w)
+			return
+		}
+
+		injectTraceContext(r)
+		proxy := httputil.NewSingleHostReverseProxy(leader)
+		proxy.Transport = transport
+		proxy.ServeHTTP(w, r)
+	})
+}
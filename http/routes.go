@@ -37,6 +37,20 @@ func getRoutes(api APIHandler) Routes {
 			"/features/{featureKey}",
 			api.FeatureRemove,
 		},
+		// curl http://localhost:8080/features/export > features.json
+		Route{
+			"FeaturesExport",
+			"GET",
+			"/features/export",
+			api.FeaturesExport,
+		},
+		// curl -H "Content-Type: application/json" -X POST -d @features.json "http://localhost:8080/features/import?conflict=skip&dry_run=true"
+		Route{
+			"FeaturesImport",
+			"POST",
+			"/features/import",
+			api.FeaturesImport,
+		},
 		Route{
 			"FeatureShow",
 			"GET",
@@ -50,6 +64,13 @@ func getRoutes(api APIHandler) Routes {
 			"/features/access",
 			api.FeaturesAccess,
 		},
+		// curl -H "Content-Type: application/json" -X POST -d '{"features":["blah"],"requests":[{"user":22},{"groups":["foo"]}]}' http://localhost:8080/features/access/batch
+		Route{
+			"FeaturesBatchAccess",
+			"POST",
+			"/features/access/batch",
+			api.FeaturesBatchAccess,
+		},
 		// curl -H "Content-Type: application/json" -X POST -d '{"groups":["foo"]}' http://localhost:8080/features/feature_test/access
 		Route{
 			"FeatureAccess",
@@ -58,11 +79,141 @@ func getRoutes(api APIHandler) Routes {
 			api.FeatureAccess,
 		},
 		// curl -H "Content-Type: application/json" -X PATCH -d '{"percentage": 42}' http://localhost:8080/features/blah
+		// curl -H "Content-Type: application/json-patch+json" -X PATCH -d '[{"op":"add","path":"/users/-","value":42}]' http://localhost:8080/features/blah
 		Route{
 			"FeatureEdit",
 			"PATCH",
 			"/features/{featureKey}",
 			api.FeatureEdit,
 		},
+		// curl http://localhost:8080/features/blah/history
+		Route{
+			"FeatureHistory",
+			"GET",
+			"/features/{featureKey}/history",
+			api.FeatureHistory,
+		},
+		// curl "http://localhost:8080/features/blah/stats?hours=48"
+		Route{
+			"FeatureStats",
+			"GET",
+			"/features/{featureKey}/stats",
+			api.FeatureStats,
+		},
+		// curl -X POST http://localhost:8080/features/blah/users/42
+		Route{
+			"FeatureAddUser",
+			"POST",
+			"/features/{featureKey}/users/{userID}",
+			api.FeatureAddUser,
+		},
+		// curl -X "DELETE" http://localhost:8080/features/blah/users/42
+		Route{
+			"FeatureRemoveUser",
+			"DELETE",
+			"/features/{featureKey}/users/{userID}",
+			api.FeatureRemoveUser,
+		},
+		// curl -X POST http://localhost:8080/features/blah/groups/dev
+		Route{
+			"FeatureAddGroup",
+			"POST",
+			"/features/{featureKey}/groups/{group}",
+			api.FeatureAddGroup,
+		},
+		// curl -X "DELETE" http://localhost:8080/features/blah/groups/dev
+		Route{
+			"FeatureRemoveGroup",
+			"DELETE",
+			"/features/{featureKey}/groups/{group}",
+			api.FeatureRemoveGroup,
+		},
+		Route{
+			"SegmentIndex",
+			"GET",
+			"/segments",
+			api.SegmentIndex,
+		},
+		// curl -H "Content-Type: application/json" -X POST -d '{"key":"beta_testers","users":[22,42],"groups":["beta"],"rules":[{"attribute":"country","operator":"in","values":["fr"]}]}' http://localhost:8080/segments
+		Route{
+			"SegmentCreate",
+			"POST",
+			"/segments",
+			api.SegmentCreate,
+		},
+		Route{
+			"SegmentShow",
+			"GET",
+			"/segments/{segmentKey}",
+			api.SegmentShow,
+		},
+		// curl -H "Content-Type: application/json" -X PATCH -d '{"groups":["beta","dev"]}' http://localhost:8080/segments/beta_testers
+		Route{
+			"SegmentEdit",
+			"PATCH",
+			"/segments/{segmentKey}",
+			api.SegmentEdit,
+		},
+		// curl -X "DELETE" http://localhost:8080/segments/beta_testers
+		Route{
+			"SegmentRemove",
+			"DELETE",
+			"/segments/{segmentKey}",
+			api.SegmentRemove,
+		},
+		Route{
+			"SegmentFeatures",
+			"GET",
+			"/segments/{segmentKey}/features",
+			api.SegmentFeatures,
+		},
+		// curl "http://localhost:8080/changes?epoch=ixq3v2&since=42&wait=30s"
+		Route{
+			"ChangesFeed",
+			"GET",
+			"/changes",
+			api.ChangesFeed,
+		},
+		// curl http://localhost:8080/healthz
+		Route{
+			"Health",
+			"GET",
+			"/healthz",
+			api.Health,
+		},
+		// curl http://localhost:8080/readyz
+		Route{
+			"Readiness",
+			"GET",
+			"/readyz",
+			api.Readiness,
+		},
+		// curl http://localhost:8080/version
+		Route{
+			"Version",
+			"GET",
+			"/version",
+			api.Version,
+		},
+		// curl http://localhost:8080/metrics
+		Route{
+			"Metrics",
+			"GET",
+			"/metrics",
+			api.Metrics,
+		},
+		// Open http://localhost:8080/admin in a browser
+		Route{
+			"AdminIndex",
+			"GET",
+			"/admin",
+			api.AdminIndex,
+		},
+		Route{
+			"AdminAsset",
+			"GET",
+			"/admin/{asset}",
+			api.AdminAsset,
+		},
 	}
 }
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	"github.com/gorilla/mux"
+)
+
+func (handler APIHandler) SegmentIndex(w http.ResponseWriter, r *http.Request) {
+	segments, err := handler.FeatureService.GetSegments(r.Context())
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(http.StatusOK, segments, w)
+}
+
+func (handler APIHandler) SegmentShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Check if the segment exists
+	if !handler.FeatureService.SegmentExists(r.Context(), vars["segmentKey"]) {
+		writeSegmentNotFound(w)
+		return
+	}
+
+	// Fetch the segment
+	segment, err := handler.FeatureService.GetSegment(r.Context(), vars["segmentKey"])
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(http.StatusOK, segment, w)
+}
+
+func (handler APIHandler) SegmentCreate(w http.ResponseWriter, r *http.Request) {
+	var segment m.Segment
+
+	if err := json.NewDecoder(r.Body).Decode(&segment); err != nil {
+		writeUnprocessableEntity(err, w)
+		return
+	}
+
+	if err := segment.Validate(); err != nil {
+		writeMessage(400, "invalid_segment", err.Error(), w)
+		return
+	}
+
+	err := handler.FeatureService.AddSegment(r.Context(), segment)
+	if err != nil && err.Error() == "Segment already exists" {
+		writeMessage(400, "invalid_segment", err.Error(), w)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(http.StatusCreated, segment, w)
+}
+
+func (handler APIHandler) SegmentEdit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Check if the segment exists
+	if !handler.FeatureService.SegmentExists(r.Context(), vars["segmentKey"]) {
+		writeSegmentNotFound(w)
+		return
+	}
+
+	// Fetch the segment
+	segment, err := handler.FeatureService.GetSegment(r.Context(), vars["segmentKey"])
+	if err != nil {
+		panic(err)
+	}
+
+	// Apply the patch to the current version of the segment
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeUnprocessableEntity(err, w)
+		return
+	}
+
+	var newSegment m.Segment
+	if err = applyPatch(segment, patch, r.Header.Get("Content-Type"), &newSegment); err != nil {
+		if _, ok := err.(patchError); ok {
+			writeMessage(400, "invalid_patch", err.Error(), w)
+			return
+		}
+		writeUnprocessableEntity(err, w)
+		return
+	}
+
+	// Validate given values
+	newSegment.Key = segment.Key
+	if err := newSegment.Validate(); err != nil {
+		writeMessage(400, "invalid_segment", err.Error(), w)
+		return
+	}
+
+	newSegment, err = handler.FeatureService.UpdateSegment(r.Context(), vars["segmentKey"], newSegment)
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(http.StatusOK, newSegment, w)
+}
+
+func (handler APIHandler) SegmentRemove(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Delete it, unless feature flags still reference it
+	err := handler.FeatureService.RemoveSegment(r.Context(), vars["segmentKey"])
+	if err != nil && err.Error() == "Unable to find segment" {
+		writeSegmentNotFound(w)
+		return
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "Segment is used by ") {
+		writeMessage(400, "segment_in_use", err.Error(), w)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	writeMessage(http.StatusOK, "segment_deleted", "The segment was successfully deleted", w)
+}
+
+func (handler APIHandler) SegmentFeatures(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Check if the segment exists
+	if !handler.FeatureService.SegmentExists(r.Context(), vars["segmentKey"]) {
+		writeSegmentNotFound(w)
+		return
+	}
+
+	features, err := handler.FeatureService.GetSegmentFeatures(r.Context(), vars["segmentKey"])
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(http.StatusOK, features, w)
+}
+
+func writeSegmentNotFound(w http.ResponseWriter) {
+	writeMessage(http.StatusNotFound, "segment_not_found", "The segment was not found", w)
+}
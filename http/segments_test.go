@@ -0,0 +1,164 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	services "github.com/antoineaugusti/feature-flags/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddSegment(t *testing.T) {
+	var segment m.Segment
+	onStart()
+	defer onFinish()
+
+	res := createDummySegment()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	json.NewDecoder(res.Body).Decode(&segment)
+	assert.Equal(t, "beta_testers", segment.Key)
+	assert.Equal(t, []uint32{42}, segment.Users)
+	assert.Equal(t, "country", segment.Rules[0].Attribute)
+
+	// Add a segment with the same key
+	res = createDummySegment()
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_segment", "Segment already exists")
+
+	// Add an invalid segment
+	reader = strings.NewReader(`{"key":"ab"}`)
+	request, _ := http.NewRequest("POST", segmentsURL(), reader)
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_segment", "Segment key must be between 3 and 50 characters")
+}
+
+// A store whose transactions always fail
+type failingStore struct{}
+
+func (failingStore) View(fn func(repos.Tx) error) error   { return fmt.Errorf("Database unavailable") }
+func (failingStore) Update(fn func(repos.Tx) error) error { return fmt.Errorf("Database unavailable") }
+
+func TestAddSegmentStoreError(t *testing.T) {
+	handler := APIHandler{FeatureService: &services.FeatureService{Store: failingStore{}}}
+	request := httptest.NewRequest("POST", "/segments", strings.NewReader(`{"key":"beta_testers","users":[42]}`))
+
+	// Unexpected errors are not reported as invalid segments
+	assert.PanicsWithError(t, "Database unavailable", func() {
+		handler.SegmentCreate(httptest.NewRecorder(), request)
+	})
+}
+
+func TestEditSegment(t *testing.T) {
+	var segment m.Segment
+	onStart()
+	defer onFinish()
+
+	createDummySegment()
+
+	reader = strings.NewReader(`{"users":null,"groups":["beta","dev"]}`)
+	request, _ := http.NewRequest("PATCH", segmentsURL()+"/beta_testers", reader)
+	res, _ := http.DefaultClient.Do(request)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&segment)
+	assert.Equal(t, []string{"beta", "dev"}, segment.Groups)
+	assert.Equal(t, 0, len(segment.Users))
+
+	// Edit an unexisting segment
+	request, _ = http.NewRequest("GET", segmentsURL()+"/unknown", nil)
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusNotFound, "segment_not_found", "The segment was not found")
+}
+
+func TestAccessThanksToSegment(t *testing.T) {
+	var features m.FeatureFlags
+	onStart()
+	defer onFinish()
+
+	createDummySegment()
+
+	// A feature cannot reference an unexisting segment
+	res := createFeatureWithPayload(`{"key":"homepage_v2","segments":["unknown"]}`)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_feature", "Segment unknown does not exist")
+
+	createFeatureWithPayload(`{"key":"homepage_v2","segments":["beta_testers"]}`)
+	url := fmt.Sprintf("%s/%s/access", base, "homepage_v2")
+
+	reader = strings.NewReader(`{"segments":["unknown"]}`)
+	request, _ := http.NewRequest("PATCH", base+"/homepage_v2", reader)
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_feature", "Segment unknown does not exist")
+
+	// Access thanks to a user of the segment
+	reader = strings.NewReader(`{"user":42}`)
+	request, _ = http.NewRequest("POST", url, reader)
+	res, _ = http.DefaultClient.Do(request)
+	assertAccessToTheFeature(t, res)
+
+	// Access thanks to the attributes
+	reader = strings.NewReader(`{"user":3,"attributes":{"country":"fr"}}`)
+	request, _ = http.NewRequest("POST", url, reader)
+	res, _ = http.DefaultClient.Do(request)
+	assertAccessToTheFeature(t, res)
+
+	reader = strings.NewReader(`{"user":3,"attributes":{"country":"us"}}`)
+	request, _ = http.NewRequest("POST", url, reader)
+	res, _ = http.DefaultClient.Do(request)
+	assertNoAccessToTheFeature(t, res)
+
+	// Editing the segment changes the access
+	reader = strings.NewReader(`{"users":[3]}`)
+	request, _ = http.NewRequest("PATCH", segmentsURL()+"/beta_testers", reader)
+	http.DefaultClient.Do(request)
+
+	reader = strings.NewReader(`{"user":3}`)
+	request, _ = http.NewRequest("POST", fmt.Sprintf("%s/access", base), reader)
+	res, _ = http.DefaultClient.Do(request)
+	json.NewDecoder(res.Body).Decode(&features)
+	assert.Equal(t, 1, len(features))
+
+	// List the features referencing the segment
+	request, _ = http.NewRequest("GET", segmentsURL()+"/beta_testers/features", nil)
+	res, _ = http.DefaultClient.Do(request)
+	json.NewDecoder(res.Body).Decode(&features)
+	assert.Equal(t, 1, len(features))
+	assert.Equal(t, "homepage_v2", features[0].Key)
+
+	// The segment cannot be deleted
+	request, _ = http.NewRequest("DELETE", segmentsURL()+"/beta_testers", nil)
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "segment_in_use", "Segment is used by 1 feature flags")
+
+	// Unknown segments are not found
+	request, _ = http.NewRequest("DELETE", segmentsURL()+"/unknown", nil)
+	res, _ = http.DefaultClient.Do(request)
+	assertResponseWithStatusAndMessage(t, res, http.StatusNotFound, "segment_not_found", "The segment was not found")
+}
+
+func createDummySegment() *http.Response {
+	payload := `{
+      "key":"beta_testers",
+      "users":[42],
+      "groups":["beta"],
+      "rules":[{"attribute":"country","operator":"in","values":["fr"]}]
+    }`
+
+	reader = strings.NewReader(payload)
+	request, _ := http.NewRequest("POST", segmentsURL(), reader)
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		panic(err)
+	}
+
+	return res
+}
+
+func segmentsURL() string {
+	return fmt.Sprintf("%s/segments", server.URL)
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServerConfig holds the limits of the HTTP server
+type ServerConfig struct {
+	// Maximum duration to read a request, including its body
+	ReadTimeout time.Duration
+	// Maximum duration to write a response. It must be longer than
+	// the wait of long polling requests to GET /changes
+	WriteTimeout time.Duration
+	// Maximum duration to keep an idle connection open
+	IdleTimeout time.Duration
+	// Maximum size of the headers of a request, in bytes
+	MaxHeaderBytes int
+	// Maximum duration to wait for in-flight requests when stopping
+	ShutdownTimeout time.Duration
+	// Serve requests over TLS, with certificates given by the
+	// configuration. Plain HTTP is served when nil
+	TLS *tls.Config
+}
+
+// NewServer creates an HTTP server listening to an address. The context
+// of requests is canceled when the server shuts down, which ends long
+// polling requests
+func NewServer(address string, handler http.Handler, config ServerConfig) *http.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+		TLSConfig:         config.TLS,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	server.RegisterOnShutdown(cancel)
+	return server
+}
+
+// Serve accepts requests until a signal is received. New connections
+// are then refused and in-flight requests are given at most timeout
+// to finish, before their connections are closed
+func Serve(server *http.Server, signals <-chan os.Signal, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			// Certificates are given by the configuration
+			errs <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case signal := <-signals:
+		slog.Info("Shutting down the server", "signal", signal.String(), "timeout", timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("Closing the connections of unfinished requests", "timeout", timeout)
+		if err := server.Close(); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// ListenAndServe returns as soon as Shutdown is called
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package http
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Find an address where nothing listens
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// Wait until a server accepts connections
+func waitForServer(t *testing.T, address string) {
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("The server at %s did not start", address)
+}
+
+func TestServeDrainsRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	address := freeAddress(t)
+	server := NewServer(address, handler, ServerConfig{ReadTimeout: time.Second, WriteTimeout: time.Second})
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- Serve(server, signals, time.Second)
+	}()
+	waitForServer(t, address)
+
+	responses := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + address)
+		assert.Nil(t, err)
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		responses <- string(body)
+	}()
+
+	// The in-flight request is served after the signal
+	<-started
+	signals <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, "done", <-responses)
+	assert.Nil(t, <-stopped)
+
+	// New connections are refused
+	_, err := net.Dial("tcp", address)
+	assert.NotNil(t, err)
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	address := freeAddress(t)
+	server := NewServer(address, handler, ServerConfig{})
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- Serve(server, signals, 50*time.Millisecond)
+	}()
+	waitForServer(t, address)
+
+	go http.Get("http://" + address)
+	<-started
+	signals <- syscall.SIGINT
+
+	// Unfinished requests are closed
+	assert.Nil(t, <-stopped)
+	_, err := net.Dial("tcp", address)
+	assert.NotNil(t, err)
+}
+
+func TestServeEndsLongPolling(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	address := freeAddress(t)
+	server := NewServer(address, handler, ServerConfig{})
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- Serve(server, signals, time.Minute)
+	}()
+	waitForServer(t, address)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, _ := http.Get("http://" + address)
+		responses <- res
+	}()
+	<-started
+
+	start := time.Now()
+	signals <- syscall.SIGINT
+	assert.Nil(t, <-stopped)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, http.StatusNoContent, (<-responses).StatusCode)
+}
+
+func TestServeListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	// The address is already used
+	server := NewServer(listener.Addr().String(), http.NotFoundHandler(), ServerConfig{})
+	assert.NotNil(t, Serve(server, make(chan os.Signal), time.Second))
+}
+
+func TestServerReadTimeout(t *testing.T) {
+	address := freeAddress(t)
+	server := NewServer(address, http.NotFoundHandler(), ServerConfig{ReadTimeout: 50 * time.Millisecond})
+	signals := make(chan os.Signal, 1)
+	go Serve(server, signals, time.Second)
+	defer func() { signals <- syscall.SIGTERM }()
+	waitForServer(t, address)
+
+	// A client sending its headers too slowly is disconnected
+	conn, err := net.Dial("tcp", address)
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(2 * time.Second))
+	_, err = io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestServerMaxHeaderBytes(t *testing.T) {
+	address := freeAddress(t)
+	server := NewServer(address, http.NotFoundHandler(), ServerConfig{MaxHeaderBytes: 1024})
+	signals := make(chan os.Signal, 1)
+	go Serve(server, signals, time.Second)
+	defer func() { signals <- syscall.SIGTERM }()
+	waitForServer(t, address)
+
+	req, _ := http.NewRequest("GET", "http://"+address, nil)
+	req.Header.Set("X-Large", strings.Repeat("a", 10000))
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, res.StatusCode)
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	analytics "github.com/antoineaugusti/feature-flags/analytics"
+	"github.com/gorilla/mux"
+)
+
+// Statistics cover at most the hours kept by the recorder
+const maxStatsHours = analytics.MaxHours
+
+// Evaluation counts of a feature flag
+type FeatureStats struct {
+	// The key of the feature flag
+	Feature string `json:"feature"`
+	// Evaluations giving access during the period
+	HasAccess uint64 `json:"has_access"`
+	// Evaluations refusing access during the period
+	NotAccess uint64 `json:"not_access"`
+	// Counts per hour, oldest first. Hours without evaluations are missing
+	Hours []analytics.HourlyCounts `json:"hours"`
+}
+
+func (handler APIHandler) FeatureStats(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if handler.Analytics == nil {
+		writeMessage(http.StatusNotImplemented, "stats_disabled", "Statistics are only recorded with the bolt storage backend", w)
+		return
+	}
+
+	if !handler.featureExists(r, vars["featureKey"]) {
+		writeNotFound(w)
+		return
+	}
+
+	hours := 24
+	if value := r.URL.Query().Get("hours"); len(value) > 0 {
+		var err error
+		if hours, err = strconv.Atoi(value); err != nil || hours < 1 || hours > maxStatsHours {
+			writeMessage(400, "invalid_hours", "The number of hours must be an integer between 1 and 744", w)
+			return
+		}
+	}
+
+	// The current hour is included
+	since := time.Now().Add(-time.Duration(hours-1) * time.Hour)
+	counts, err := handler.Analytics.Stats(vars["featureKey"], since)
+	if err != nil {
+		panic(err)
+	}
+
+	stats := FeatureStats{Feature: vars["featureKey"], Hours: counts}
+	for _, hour := range counts {
+		stats.HasAccess += hour.HasAccess
+		stats.NotAccess += hour.NotAccess
+	}
+
+	writeJSON(http.StatusOK, stats, w)
+}
+
+// Send an evaluation of a feature flag to the analytics pipeline
+func (handler APIHandler) track(featureKey string, access bool, variant string) {
+	result := analytics.ResultNotAccess
+	if access {
+		result = analytics.ResultHasAccess
+	}
+
+	handler.Analytics.Record(analytics.Event{
+		Feature: featureKey,
+		Result:  result,
+		Variant: variant,
+		Time:    time.Now(),
+	})
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	analytics "github.com/antoineaugusti/feature-flags/analytics"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	s "github.com/antoineaugusti/feature-flags/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureStats(t *testing.T) {
+	var stats FeatureStats
+	onStart()
+	defer onFinish()
+
+	// Statistics are disabled without analytics
+	res, _ := http.Get(base + "/homepage_v2/stats")
+	assertResponseWithStatusAndMessage(t, res, http.StatusNotImplemented, "stats_disabled", "Statistics are only recorded with the bolt storage backend")
+
+	service, _ := s.NewFeatureService(repos.NewBoltStore(database))
+	recorder := analytics.NewRecorder(database, 100)
+	server := httptest.NewServer(NewRouter(APIHandler{FeatureService: service, Analytics: recorder}))
+	defer server.Close()
+	url := server.URL + "/features"
+
+	createDummyFeatureFlag()
+	service.Refresh(context.Background())
+	http.Post(url+"/homepage_v2/access", "application/json", strings.NewReader(`{"user":2}`))
+	http.Post(url+"/homepage_v2/access", "application/json", strings.NewReader(`{"user":3}`))
+	http.Post(url+"/access", "application/json", strings.NewReader(`{"groups":["dev"]}`))
+	// Batch checks are not recorded
+	http.Post(url+"/access/batch", "application/json", strings.NewReader(`{"requests":[{"user":2}]}`))
+
+	stop := make(chan struct{})
+	close(stop)
+	recorder.Run(time.Hour, stop)
+
+	res, _ = http.Get(url + "/homepage_v2/stats")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&stats)
+	assert.Equal(t, "homepage_v2", stats.Feature)
+	assert.Equal(t, uint64(2), stats.HasAccess)
+	assert.Equal(t, uint64(1), stats.NotAccess)
+	assert.Equal(t, 1, len(stats.Hours))
+	assert.Equal(t, map[string]uint64{"user": 1, "none": 1, "group": 1}, stats.Hours[0].Variants)
+
+	res, _ = http.Get(url + "/homepage_v2/stats?hours=0")
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_hours", "The number of hours must be an integer between 1 and 744")
+
+	res, _ = http.Get(url + "/notfound/stats")
+	assert404Response(t, res)
+}
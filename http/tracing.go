@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/antoineaugusti/feature-flags/http")
+
+// Serve a request within a span, continuing the trace
+// given by the W3C trace context headers of the request
+func Trace(inner http.Handler, name, pattern string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", pattern),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("route.name", name),
+				attribute.String("client.address", requestIP(r)),
+			),
+		)
+		defer span.End()
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		inner.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// Send the trace context of a request along with it, when
+// forwarding it to another server
+func injectTraceContext(r *http.Request) {
+	otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	onStart()
+	defer onFinish()
+
+	createDummyFeatureFlag()
+	exporter.Reset()
+
+	// The trace of the client is continued
+	request, _ := http.NewRequest("PATCH", base+"/homepage_v2", strings.NewReader(`{"enabled":true}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res, _ := http.DefaultClient.Do(request)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	server, ok := spans["PATCH /features/{featureKey}"]
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+
+	// Calls to the service are children of the request,
+	// calls to the store are children of the service
+	service := spans["FeatureService.PatchFeature"]
+	assert.Equal(t, server.SpanContext.SpanID(), service.Parent.SpanID())
+	update := spans["repos.Update"]
+	assert.Equal(t, service.SpanContext.SpanID(), update.Parent.SpanID())
+	put := spans["repos.PutFeature"]
+	assert.Equal(t, update.SpanContext.SpanID(), put.Parent.SpanID())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", put.SpanContext.TraceID().String())
+
+	// Access checks
+	exporter.Reset()
+	http.Post(base+"/access", "application/json", strings.NewReader(`{"user":2}`))
+	spans = make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	server = spans["POST /features/access"]
+	assert.Equal(t, server.SpanContext.SpanID(), spans["FeatureService.Snapshot"].Parent.SpanID())
+	assert.Equal(t, server.SpanContext.SpanID(), spans["DecodeAccessRequest"].Parent.SpanID())
+	assert.Equal(t, server.SpanContext.SpanID(), spans["EvaluateFeatures"].Parent.SpanID())
+}
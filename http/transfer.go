@@ -0,0 +1,61 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	services "github.com/antoineaugusti/feature-flags/services"
+)
+
+func (handler APIHandler) FeaturesExport(w http.ResponseWriter, r *http.Request) {
+	export, err := handler.FeatureService.Export(r.Context())
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(http.StatusOK, export, w)
+}
+
+func (handler APIHandler) FeaturesImport(w http.ResponseWriter, r *http.Request) {
+	var export m.Export
+
+	query := r.URL.Query()
+
+	dryRun := false
+	if value := query.Get("dry_run"); len(value) > 0 {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			writeMessage(400, "invalid_import", "dry_run must be true or false", w)
+			return
+		}
+	}
+
+	conflict := query.Get("conflict")
+	if len(conflict) == 0 {
+		conflict = services.ConflictFail
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		writeUnprocessableEntity(err, w)
+		return
+	}
+
+	report, err := handler.FeatureService.Import(r.Context(), export, conflict, dryRun)
+	if err != nil {
+		importError, ok := err.(services.ImportError)
+		if !ok {
+			panic(err)
+		}
+
+		if importError.Conflict {
+			writeMessage(http.StatusConflict, "import_conflict", err.Error(), w)
+		} else {
+			writeMessage(400, "invalid_import", err.Error(), w)
+		}
+		return
+	}
+
+	writeJSON(http.StatusOK, report, w)
+}
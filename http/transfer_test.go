@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	s "github.com/antoineaugusti/feature-flags/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportFeatureFlags(t *testing.T) {
+	var export m.Export
+	onStart()
+	defer onFinish()
+
+	createDummySegment()
+	createDummyFeatureFlag()
+
+	res, _ := http.Get(base + "/export")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	json.NewDecoder(res.Body).Decode(&export)
+	assert.Equal(t, m.ExportVersion, export.Version)
+	assert.Equal(t, "homepage_v2", export.Features[0].Key)
+	assert.Equal(t, []uint32{2}, export.Features[0].Users)
+	assert.Equal(t, "beta_testers", export.Segments[0].Key)
+}
+
+func TestImportFeatureFlags(t *testing.T) {
+	var report s.ImportReport
+	onStart()
+	defer onFinish()
+
+	createDummyFeatureFlag()
+	payload := `{
+      "version":1,
+      "features":[{"key":"homepage_v2","enabled":true},{"key":"new_feature","segments":["beta_testers"]}],
+      "segments":[{"key":"beta_testers","users":[42]}]
+    }`
+
+	// Existing feature flags make the import fail by default
+	res := importFeatures("", payload)
+	assertResponseWithStatusAndMessage(t, res, http.StatusConflict, "import_conflict", "Feature homepage_v2 already exists")
+
+	// Dry run
+	res = importFeatures("?conflict=overwrite&dry_run=true", payload)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&report)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"homepage_v2"}, report.Features.Updated)
+	assert.Equal(t, []string{"new_feature"}, report.Features.Created)
+	assert.Equal(t, []string{"beta_testers"}, report.Segments.Created)
+	assert.False(t, getService().FeatureExists(context.Background(), "new_feature"))
+
+	// Import
+	res = importFeatures("?conflict=skip", payload)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&report)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, []string{"homepage_v2"}, report.Features.Skipped)
+	assert.True(t, getService().FeatureExists(context.Background(), "new_feature"))
+
+	// Invalid documents
+	res = importFeatures("?conflict=merge", payload)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_import", "Unknown conflict strategy merge")
+
+	res = importFeatures("?dry_run=maybe", payload)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_import", "dry_run must be true or false")
+
+	res = importFeatures("", `{"version":2}`)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_import", "Unsupported export version 2")
+
+	res = importFeatures("", `{"version":1,"features":[{"key":"other","segments":["unknown"]}]}`)
+	assertResponseWithStatusAndMessage(t, res, http.StatusBadRequest, "invalid_import", "Segment unknown does not exist")
+
+	res = importFeatures("", `{"version":1`)
+	assert422Response(t, res)
+}
+
+func importFeatures(query, payload string) *http.Response {
+	reader = strings.NewReader(payload)
+	request, _ := http.NewRequest("POST", base+"/import"+query, reader)
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		panic(err)
+	}
+
+	return res
+}
@@ -0,0 +1,27 @@
+// Package logging configures structured logs
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// New creates a logger writing to w. The format is json or text,
+// the level is debug, info, warn or error
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var minimum slog.Level
+	if err := minimum.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("Unknown log level %s, expected debug, info, warn or error", level)
+	}
+	options := &slog.HandlerOptions{Level: minimum}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("Unknown log format %s, expected json or text", format)
+}
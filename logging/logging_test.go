@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	var buffer bytes.Buffer
+
+	logger, err := New(&buffer, "json", "warn")
+	assert.Nil(t, err)
+	logger.Info("ignored")
+	logger.Warn("written", "status", 404)
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, "written", line["msg"])
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, float64(404), line["status"])
+
+	buffer.Reset()
+	logger, _ = New(&buffer, "text", "debug")
+	logger.Debug("written", "route", "FeatureShow")
+	assert.Contains(t, buffer.String(), "level=DEBUG msg=written route=FeatureShow")
+
+	_, err = New(&buffer, "xml", "info")
+	assert.Equal(t, "Unknown log format xml, expected json or text", err.Error())
+
+	_, err = New(&buffer, "json", "verbose")
+	assert.Equal(t, "Unknown log level verbose, expected debug, info, warn or error", err.Error())
+}
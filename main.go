@@ -1,37 +1,383 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	analytics "github.com/antoineaugusti/feature-flags/analytics"
+	clientip "github.com/antoineaugusti/feature-flags/clientip"
+	cluster "github.com/antoineaugusti/feature-flags/cluster"
+	config "github.com/antoineaugusti/feature-flags/config"
+	ctl "github.com/antoineaugusti/feature-flags/ctl"
 	db "github.com/antoineaugusti/feature-flags/db"
+	gitops "github.com/antoineaugusti/feature-flags/gitops"
 	h "github.com/antoineaugusti/feature-flags/http"
+	logging "github.com/antoineaugusti/feature-flags/logging"
+	offline "github.com/antoineaugusti/feature-flags/offline"
+	replica "github.com/antoineaugusti/feature-flags/replica"
+	repos "github.com/antoineaugusti/feature-flags/repos"
 	s "github.com/antoineaugusti/feature-flags/services"
+	tlsconfig "github.com/antoineaugusti/feature-flags/tlsconfig"
+	tracing "github.com/antoineaugusti/feature-flags/tracing"
 	"github.com/boltdb/bolt"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
-	address := flag.String("a", ":8080", "address to listen")
-	boltLocation := flag.String("d", "bolt.db", "location of the database file")
-	flag.Parse()
+	// Manage a server from the command line
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl.Run(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	settings, err := config.Load(os.Args[1:], os.Environ(), os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := settings.Config
+
+	// Show the settings given by the file, the environment and flags
+	if len(settings.Args) > 0 && settings.Args[0] == "config" {
+		os.Exit(runConfig(settings, settings.Args[1:]))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Work on the bolt database file while no server uses it
+	if len(settings.Args) > 0 && settings.Args[0] == "db" {
+		os.Exit(offline.Run(cfg.Database, cfg.BoltTimeout, settings.Args[1:], os.Stdout, os.Stderr))
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	stopTracing, err := tracing.Setup(cfg.TraceExporter, cfg.TraceEndpoint, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := serverOptions{
+		Address: cfg.Address,
+		HTTP: h.ServerConfig{
+			ReadTimeout:     cfg.ReadTimeout,
+			WriteTimeout:    cfg.WriteTimeout,
+			IdleTimeout:     cfg.IdleTimeout,
+			MaxHeaderBytes:  cfg.MaxHeaderBytes,
+			ShutdownTimeout: cfg.ShutdownTimeout,
+		},
+		MaxBodyBytes:   cfg.MaxBodyBytes,
+		IPRateLimit:    h.RateLimit{Rate: cfg.RateLimitIP, Burst: cfg.RateLimitIPBurst},
+		TokenRateLimit: h.RateLimit{Rate: cfg.RateLimitToken, Burst: cfg.RateLimitTokenBurst},
+	}
 
+	// Find the IP address of clients behind proxies
+	server.Proxies, err = clientip.Parse(cfg.TrustedProxies, cfg.TrustedHeader)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Only clients with a verified certificate are limited by token
+	if cfg.RateLimitToken > 0 && len(cfg.TLSClientCA) == 0 {
+		slog.Warn("Requests are not limited by client certificate without a client CA", "rate_limit_token", cfg.RateLimitToken)
+	}
+
+	// Serve HTTPS, and authenticate clients with a client CA
+	if len(cfg.TLSCert) > 0 {
+		if err := setupTLS(&server, cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch {
+	case len(cfg.Primary) > 0:
+		err = follow(server, cfg.Primary)
+	case len(cfg.Node) > 0:
+		err = join(server, cfg.Node, cfg.Peers, cfg.RaftDir)
+	default:
+		err = standalone(server, cfg)
+	}
+
+	// Send the last spans
+	if err := stopTracing(context.Background()); err != nil {
+		slog.Error("Unable to stop tracing", "error", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Options of the HTTP server
+type serverOptions struct {
+	Address string
+	HTTP    h.ServerConfig
+	// Clients must present a certificate signed by the client CA
+	RequireClientCertificate bool
+	// Roles of the identities of client certificates
+	Roles map[string]string
+	// Sends requests to other servers, presenting the certificate
+	// of this server. The default transport when nil
+	Transport http.RoundTripper
+	// Limits of the requests of clients
+	MaxBodyBytes   int64
+	IPRateLimit    h.RateLimit
+	TokenRateLimit h.RateLimit
+	// Finds the IP address of clients behind trusted proxies
+	Proxies *clientip.Resolver
+}
+
+// Serve the feature flags of a storage backend
+func standalone(server serverOptions, cfg config.Config) error {
 	// Open the DB connection
-	database, err := bolt.Open(*boltLocation, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	store, database, err := openStore(cfg.Backend, cfg.Database, cfg.BoltTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Load feature flags in memory
+	service, err := s.NewFeatureService(store)
+	if err != nil {
+		database.Close()
+		log.Fatal(err)
+	}
+
+	// Background tasks stop when stop is closed
+	stop := make(chan struct{})
+	var tasks sync.WaitGroup
+
+	// Count evaluations of feature flags in the bolt database
+	if boltDB, ok := database.(*bolt.DB); ok {
+		service.Analytics = analytics.NewRecorder(boltDB, cfg.StatsBuffer)
+		runTask(&tasks, func() { service.Analytics.Run(cfg.StatsInterval, stop) })
+	}
+
+	// Feature flags defined by YAML files
+	if len(cfg.Files) > 0 {
+		reconciler := gitops.Reconciler{Path: cfg.Files, Service: service, Prune: cfg.Prune, DryRun: cfg.Drift}
+
+		report, err := reconciler.Reconcile()
+		if err != nil {
+			database.Close()
+			log.Fatal(err)
+		}
+		gitops.LogReport(report)
+
+		if cfg.Refresh > 0 {
+			runTask(&tasks, func() { reconciler.Run(cfg.Refresh, stop) })
+		}
+	}
+
+	// Other servers can write to a SQL database
+	if cfg.Backend != "bolt" && cfg.Refresh > 0 {
+		runTask(&tasks, func() { refreshPeriodically(service, cfg.Refresh, stop) })
+	}
+
+	api := h.APIHandler{FeatureService: service, Analytics: service.Analytics, Ready: checkDatabase(database)}
+
+	err = serve(server, api)
+
+	// Pending evaluation counts are stored before closing the DB connection
+	close(stop)
+	tasks.Wait()
+	if err := database.Close(); err != nil {
+		slog.Error("Unable to close the database", "error", err)
+	}
+
+	return err
+}
+
+// Serve a read-only copy of the feature flags of a primary server
+func follow(server serverOptions, primary string) error {
+	service, err := s.NewFeatureService(repos.NewMemoryStore())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	follower := replica.NewFollower(primary, service)
+	if server.Transport != nil {
+		follower.Client.Transport = server.Transport
+	}
+
+	// Fetch feature flags before accepting requests
+	if err := follower.Sync(0); err != nil {
+		log.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go follower.Run(stop)
+
+	api := h.APIHandler{FeatureService: service, ReadOnly: true}
+	return serve(server, api)
+}
+
+// Serve the feature flags replicated by the nodes of a cluster
+func join(server serverOptions, id, peers, directory string) error {
+	members, err := cluster.ParsePeers(peers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	node, err := cluster.NewNode(cluster.Config{ID: id, Peers: members, Directory: directory})
 	if err != nil {
 		log.Fatal(err)
 	}
+	api := h.APIHandler{FeatureService: node.Service, Cluster: node, Ready: func() error {
+		if len(node.LeaderAddress()) == 0 {
+			return fmt.Errorf("The cluster has no leader")
+		}
+		return nil
+	}, Transport: server.Transport}
+
+	err = serve(server, api)
 
-	// Close the DB connection on exit
-	defer database.Close()
+	// Leave the cluster once requests are served
+	if err := node.Close(); err != nil {
+		slog.Error("Unable to leave the cluster", "error", err)
+	}
 
-	// Generate the default bucket
-	db.GenerateDefaultBucket(db.GetBucketName(), database)
+	return err
+}
 
-	api := h.APIHandler{FeatureService: s.FeatureService{DB: database}}
+// Serve requests until SIGINT or SIGTERM is received
+func serve(options serverOptions, api h.APIHandler) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	// Create and listen for the HTTP server
-	router := h.NewRouter(api)
-	log.Fatal(http.ListenAndServe(*address, router))
+	api.RequireClientCertificate, api.Roles = options.RequireClientCertificate, options.Roles
+	api.MaxBodyBytes = options.MaxBodyBytes
+	api.IPRateLimit, api.TokenRateLimit = options.IPRateLimit, options.TokenRateLimit
+	api.Proxies = options.Proxies
+	server := h.NewServer(options.Address, h.NewRouter(api), options.HTTP)
+	slog.Info("Listening", "address", options.Address, "tls", options.HTTP.TLS != nil)
+	return h.Serve(server, signals, options.HTTP.ShutdownTimeout)
+}
+
+// Serve HTTPS with certificates reloaded when their files change
+func setupTLS(server *serverOptions, cfg config.Config) error {
+	roles, err := h.ParseRoles(cfg.TLSRoles)
+	if err != nil {
+		return err
+	}
+
+	reloader, err := tlsconfig.NewReloader(tlsconfig.Files{Certificate: cfg.TLSCert, Key: cfg.TLSKey, ClientCA: cfg.TLSClientCA})
+	if err != nil {
+		return err
+	}
+	go reloader.Run(cfg.TLSReload, nil)
+
+	server.HTTP.TLS = reloader.ServerConfig()
+	server.RequireClientCertificate = len(cfg.TLSClientCA) > 0
+	server.Roles = roles
+	server.Transport = reloader
+	return nil
+}
+
+// Run a config command, giving the exit code
+func runConfig(settings *config.Settings, args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "Usage: feature-flags [flags] config print")
+		return 2
+	}
+
+	if err := settings.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Invalid settings are shown, then reported
+	if err := settings.Config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Run a background task, counted by a wait group
+func runTask(tasks *sync.WaitGroup, task func()) {
+	tasks.Add(1)
+	go func() {
+		defer tasks.Done()
+		task()
+	}()
+}
+
+// Open the database of a storage backend
+func openStore(backend, location string, timeout time.Duration) (repos.Store, io.Closer, error) {
+	if backend == "bolt" {
+		database, err := bolt.Open(location, 0600, &bolt.Options{Timeout: timeout})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Generate the default bucket
+		if err := db.GenerateDefaultBucket(db.GetBucketName(), database); err != nil {
+			database.Close()
+			return nil, nil, err
+		}
+
+		return repos.NewBoltStore(database), database, nil
+	}
+
+	database, err := sql.Open(backend, location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := repos.NewSQLStore(database, backend)
+	if err != nil {
+		database.Close()
+		return nil, nil, err
+	}
+
+	return store, database, nil
+}
+
+// Tell if the database of a storage backend can be used
+func checkDatabase(database io.Closer) func() error {
+	switch database := database.(type) {
+	case *bolt.DB:
+		return func() error {
+			return db.CheckBucket(db.GetBucketName(), database)
+		}
+	case *sql.DB:
+		return database.Ping
+	}
+	return nil
+}
+
+// Reload feature flags from the store at a regular interval until
+// stop is closed
+func refreshPeriodically(service *s.FeatureService, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := service.Refresh(context.Background()); err != nil {
+				slog.Error("Unable to refresh feature flags", "error", err)
+			}
+		}
+	}
 }
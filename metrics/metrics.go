@@ -0,0 +1,65 @@
+// Package metrics holds the Prometheus metrics of the service
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "feature_flags"
+
+var (
+	// Requests counts HTTP requests by route name, method and status code
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// RequestDuration observes how long HTTP requests take by route name
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// BoltTransactionDuration observes how long bolt transactions
+	// take, by type: view or update
+	BoltTransactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "bolt",
+		Name:      "transaction_duration_seconds",
+		Help:      "Duration of bolt transactions by type.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"type"})
+
+	// Features is the number of feature flags
+	Features = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "features",
+		Help:      "Number of feature flags.",
+	})
+
+	// Evaluations counts access checks by feature flag and result:
+	// has_access or not_access
+	Evaluations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evaluations_total",
+		Help:      "Number of access checks by feature flag and result.",
+	}, []string{"feature", "result"})
+
+	// DroppedEvents counts evaluation events dropped because
+	// the analytics pipeline was full
+	DroppedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "analytics",
+		Name:      "dropped_events_total",
+		Help:      "Number of evaluation events dropped because the analytics pipeline was full.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(Requests, RequestDuration, BoltTransactionDuration, Features, Evaluations, DroppedEvents)
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"fmt"
+)
+
+// The version of the export format written by this server
+const ExportVersion = 1
+
+// Holds feature flags and segments, to move them between servers
+type Export struct {
+	// The version of the format of the document
+	Version int `json:"version"`
+	// The exported feature flags
+	Features FeatureFlags `json:"features"`
+	// The exported segments
+	Segments Segments `json:"segments"`
+}
+
+// Self validate the document and every feature flag and segment it holds
+func (e Export) Validate() error {
+	if e.Version != ExportVersion {
+		return fmt.Errorf("Unsupported export version %d", e.Version)
+	}
+
+	segmentKeys := make(map[string]bool, len(e.Segments))
+	for _, segment := range e.Segments {
+		if err := segment.Validate(); err != nil {
+			return fmt.Errorf("Invalid segment %s: %s", segment.Key, err)
+		}
+
+		if segmentKeys[segment.Key] {
+			return fmt.Errorf("Segment %s appears more than once", segment.Key)
+		}
+		segmentKeys[segment.Key] = true
+	}
+
+	featureKeys := make(map[string]bool, len(e.Features))
+	for _, feature := range e.Features {
+		if err := feature.Validate(); err != nil {
+			return fmt.Errorf("Invalid feature %s: %s", feature.Key, err)
+		}
+
+		if featureKeys[feature.Key] {
+			return fmt.Errorf("Feature %s appears more than once", feature.Key)
+		}
+		featureKeys[feature.Key] = true
+	}
+
+	return nil
+}
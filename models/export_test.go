@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExport(t *testing.T) {
+	e := Export{
+		Version:  2,
+		Features: FeatureFlags{{Key: "homepage_v2"}},
+		Segments: Segments{{Key: "beta_testers"}},
+	}
+
+	err := e.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Unsupported export version 2", err.Error())
+
+	e.Version = ExportVersion
+	assert.Nil(t, e.Validate())
+
+	e.Features = append(e.Features, FeatureFlag{Key: "ab"})
+	err = e.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Invalid feature ab: Feature key must be between 3 and 50 characters", err.Error())
+
+	e.Features[1].Key = "homepage_v2"
+	err = e.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Feature homepage_v2 appears more than once", err.Error())
+
+	e.Features = e.Features[:1]
+	e.Segments = append(e.Segments, Segment{Key: "beta_testers"})
+	err = e.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Segment beta_testers appears more than once", err.Error())
+}
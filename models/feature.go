@@ -22,6 +22,14 @@ type FeatureFlag struct {
 	Groups []string `json:"groups"`
 	// Gives access to a feature to a percentage of users
 	Percentage uint32 `json:"percentage"`
+	// Gives access to a feature to the members of segments
+	Segments []string `json:"segments"`
+	// Tell if a feature flag is defined by configuration files.
+	// It cannot be changed through the API
+	Managed bool `json:"managed"`
+	// An index of Users for constant time lookups, see IndexUsers.
+	// Copies of a feature flag share it: it is never modified
+	usersIndex map[uint32]struct{}
 }
 
 type FeatureFlags []FeatureFlag
@@ -34,12 +42,17 @@ func (f FeatureFlag) Validate() error {
 	}
 
 	// Validate key
-	if len(f.Key) < 3 || len(f.Key) > 50 {
-		return fmt.Errorf("Feature key must be between 3 and 50 characters")
+	return validateKey("Feature", f.Key)
+}
+
+// Validate the key of a feature flag or a segment
+func validateKey(kind, key string) error {
+	if len(key) < 3 || len(key) > 50 {
+		return fmt.Errorf("%s key must be between 3 and 50 characters", kind)
 	}
 
-	if !regexp.MustCompile(`^[a-z0-9_]*$`).MatchString(f.Key) {
-		return fmt.Errorf("Feature key must only contain digits, lowercase letters and underscores")
+	if !regexp.MustCompile(`^[a-z0-9_]*$`).MatchString(key) {
+		return fmt.Errorf("%s key must only contain digits, lowercase letters and underscores", kind)
 	}
 	return nil
 }
@@ -51,7 +64,7 @@ func (f FeatureFlag) IsEnabled() bool {
 
 // IsPartiallyEnabled checks if a feature flag is partially enabled
 func (f FeatureFlag) IsPartiallyEnabled() bool {
-	return !f.IsEnabled() && (f.hasUsers() || f.hasGroups() || f.hasPercentage())
+	return !f.IsEnabled() && (f.hasUsers() || f.hasGroups() || f.hasPercentage() || f.hasSegments())
 }
 
 // GroupHasAccess checks if a group has access to a feature
@@ -68,6 +81,62 @@ func (f FeatureFlag) UserHasAccess(user uint32) bool {
 	return f.IsEnabled() || (f.IsPartiallyEnabled() && (f.userInUsers(user) || f.userIsAllowedByPercentage(user)))
 }
 
+// IndexUsers builds an index of the allowed users, so that checking
+// if a user has access does not walk the list of users. The index
+// is kept up to date by AddUser and RemoveUser, it must be built
+// again when Users is modified directly. AddUser and RemoveUser
+// change copies of Users and of the index, which can be shared with
+// other copies of the feature flag
+func (f *FeatureFlag) IndexUsers() {
+	f.usersIndex = make(map[uint32]struct{}, len(f.Users))
+	for _, user := range f.Users {
+		f.usersIndex[user] = struct{}{}
+	}
+}
+
+// AddUser gives access to the feature to a specific user
+func (f *FeatureFlag) AddUser(user uint32) {
+	if !f.userInUsers(user) {
+		// A full slice makes append copy the users
+		f.Users = append(f.Users[:len(f.Users):len(f.Users)], user)
+		if f.usersIndex != nil {
+			f.IndexUsers()
+		}
+	}
+}
+
+// RemoveUser removes a specific user from the list of allowed users
+func (f *FeatureFlag) RemoveUser(user uint32) {
+	users := make([]uint32, 0, len(f.Users))
+	for _, u := range f.Users {
+		if u != user {
+			users = append(users, u)
+		}
+	}
+	f.Users = users
+	if f.usersIndex != nil {
+		f.IndexUsers()
+	}
+}
+
+// AddGroup gives access to the feature to a specific group
+func (f *FeatureFlag) AddGroup(group string) {
+	if !f.groupInGroups(group) {
+		f.Groups = append(f.Groups, group)
+	}
+}
+
+// RemoveGroup removes a specific group from the list of allowed groups
+func (f *FeatureFlag) RemoveGroup(group string) {
+	groups := make([]string, 0, len(f.Groups))
+	for _, g := range f.Groups {
+		if g != group {
+			groups = append(groups, g)
+		}
+	}
+	f.Groups = groups
+}
+
 // Tell if specific users have access to the feature
 func (f FeatureFlag) hasUsers() bool {
 	return len(f.Users) > 0
@@ -83,6 +152,65 @@ func (f FeatureFlag) hasPercentage() bool {
 	return f.Percentage > 0
 }
 
+// Tell if members of segments have access to the feature
+func (f FeatureFlag) hasSegments() bool {
+	return len(f.Segments) > 0
+}
+
+// SegmentHasAccess checks if the members of a segment have access to a feature
+func (f FeatureFlag) SegmentHasAccess(segment string) bool {
+	return f.IsEnabled() || (f.IsPartiallyEnabled() && helpers.StringInSlice(segment, f.Segments))
+}
+
+// Reasons why a user has access to a feature
+const (
+	AccessEnabled = "enabled"
+	AccessGroup   = "group"
+	// The user is allowed explicitly or by the percentage
+	AccessUser    = "user"
+	AccessSegment = "segment"
+	// The user does not have access
+	AccessNone = "none"
+)
+
+// AccessReason tells why a user with some groups and attributes has
+// access to a feature, AccessNone when the user does not have access.
+// Segments are found by key in segments
+func (f FeatureFlag) AccessReason(user uint32, groups []string, attributes map[string]string, segments map[string]Segment) string {
+	// Handle trivial case
+	if f.IsEnabled() {
+		return AccessEnabled
+	}
+
+	// Access thanks to a group?
+	for _, group := range groups {
+		if f.GroupHasAccess(group) {
+			return AccessGroup
+		}
+	}
+
+	// Access thanks to the user?
+	if user > 0 && f.UserHasAccess(user) {
+		return AccessUser
+	}
+
+	// Access thanks to a segment?
+	for _, key := range f.Segments {
+		segment, ok := segments[key]
+		if ok && segment.Contains(user, groups, attributes) && f.SegmentHasAccess(key) {
+			return AccessSegment
+		}
+	}
+
+	return AccessNone
+}
+
+// HasAccess checks if a user with some groups and attributes has
+// access to a feature. See AccessReason
+func (f FeatureFlag) HasAccess(user uint32, groups []string, attributes map[string]string, segments map[string]Segment) bool {
+	return f.AccessReason(user, groups, attributes, segments) != AccessNone
+}
+
 // Check if a user has access to the feature thanks to the percentage value
 func (f FeatureFlag) userIsAllowedByPercentage(user uint32) bool {
 	return crc32.ChecksumIEEE(helpers.Uint32ToBytes(user))%100 < f.Percentage
@@ -90,6 +218,10 @@ func (f FeatureFlag) userIsAllowedByPercentage(user uint32) bool {
 
 // Check if a user is in the list of allowed users
 func (f FeatureFlag) userInUsers(user uint32) bool {
+	if f.usersIndex != nil {
+		_, ok := f.usersIndex[user]
+		return ok
+	}
 	return helpers.IntInSlice(user, f.Users)
 }
 
@@ -128,3 +128,85 @@ func TestUserHasAccess(t *testing.T) {
 	f.Enabled = false
 	assert.True(t, f.UserHasAccess(222))
 }
+
+func TestAccessReason(t *testing.T) {
+	f := FeatureFlag{
+		Key:        "foo",
+		Users:      []uint32{42},
+		Groups:     []string{"dev"},
+		Percentage: 0,
+		Segments:   []string{"beta"},
+	}
+	segments := map[string]Segment{"beta": {Key: "beta", Users: []uint32{7}}}
+
+	assert.Equal(t, AccessGroup, f.AccessReason(42, []string{"dev"}, nil, segments))
+	assert.Equal(t, AccessUser, f.AccessReason(42, []string{"ops"}, nil, segments))
+	assert.Equal(t, AccessSegment, f.AccessReason(7, nil, nil, segments))
+	assert.Equal(t, AccessNone, f.AccessReason(8, nil, nil, segments))
+	assert.Equal(t, AccessNone, f.AccessReason(7, nil, nil, nil))
+
+	assert.True(t, f.HasAccess(7, nil, nil, segments))
+	assert.False(t, f.HasAccess(8, nil, nil, segments))
+
+	f.Enabled = true
+	assert.Equal(t, AccessEnabled, f.AccessReason(8, nil, nil, nil))
+}
+
+func TestEditUsersAndGroups(t *testing.T) {
+	f := FeatureFlag{
+		Key:        "foo",
+		Enabled:    false,
+		Users:      []uint32{42},
+		Groups:     []string{"bar"},
+		Percentage: 0,
+	}
+
+	// Users are added only once
+	f.AddUser(1337)
+	f.AddUser(1337)
+	assert.Equal(t, []uint32{42, 1337}, f.Users)
+
+	f.RemoveUser(42)
+	f.RemoveUser(1)
+	assert.Equal(t, []uint32{1337}, f.Users)
+
+	// Groups are added only once
+	f.AddGroup("baz")
+	f.AddGroup("baz")
+	assert.Equal(t, []string{"bar", "baz"}, f.Groups)
+
+	f.RemoveGroup("bar")
+	f.RemoveGroup("klm")
+	assert.Equal(t, []string{"baz"}, f.Groups)
+}
+
+func TestIndexUsers(t *testing.T) {
+	f := FeatureFlag{
+		Key:        "foo",
+		Enabled:    false,
+		Users:      []uint32{42, 1337},
+		Groups:     []string{},
+		Percentage: 0,
+	}
+	f.IndexUsers()
+
+	assert.True(t, f.UserHasAccess(42))
+	assert.False(t, f.UserHasAccess(22))
+
+	// The index follows added and removed users
+	f.AddUser(22)
+	f.RemoveUser(42)
+	assert.True(t, f.UserHasAccess(22))
+	assert.False(t, f.UserHasAccess(42))
+	assert.Equal(t, []uint32{1337, 22}, f.Users)
+
+	// Copies are not changed
+	copied := f
+	copied.AddUser(7)
+	copied.RemoveUser(1337)
+	assert.True(t, f.UserHasAccess(1337))
+	assert.False(t, f.UserHasAccess(7))
+	assert.Equal(t, []uint32{1337, 22}, f.Users)
+	assert.True(t, copied.UserHasAccess(7))
+	assert.False(t, copied.UserHasAccess(1337))
+}
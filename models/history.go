@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// Actions recorded in the history of a feature flag
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionRemoved = "removed"
+)
+
+// A change of a feature flag
+type FeatureChange struct {
+	// The revision of the change, counted for each feature flag
+	// from 1. It is given by the store
+	Revision uint64 `json:"revision"`
+	// When the change was made
+	Time time.Time `json:"time"`
+	// One of ActionCreated, ActionUpdated or ActionRemoved
+	Action string `json:"action"`
+	// The feature flag after the change, or before it when removed.
+	// Its users are left out, they can be too many to be copied by
+	// every change
+	Feature FeatureFlag `json:"feature"`
+	// How many users are allowed after the change, or before it
+	// when removed
+	UserCount int `json:"user_count"`
+	// Users given access by the change, every user when created
+	AddedUsers []uint32 `json:"added_users"`
+	// Users whose access was removed by the change
+	RemovedUsers []uint32 `json:"removed_users"`
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"fmt"
+
+	helpers "github.com/antoineaugusti/feature-flags/helpers"
+)
+
+// Operators available in segment rules
+const (
+	// The attribute must be one of the values
+	OperatorIn = "in"
+	// The attribute must not be one of the values
+	OperatorNotIn = "not_in"
+)
+
+// Represents a reusable list of users, groups and attribute
+// rules that feature flags can give access to
+type Segment struct {
+	// The key of a segment
+	Key string `json:"key"`
+	// Specific user IDs in the segment
+	Users []uint32 `json:"users"`
+	// Specific groups in the segment
+	Groups []string `json:"groups"`
+	// Rules on the attributes of a user. A user is in the segment
+	// if every rule matches
+	Rules []Rule `json:"rules"`
+}
+
+type Segments []Segment
+
+// A rule on an attribute given when checking the access to a feature
+type Rule struct {
+	// The name of the attribute
+	Attribute string `json:"attribute"`
+	// How to compare the attribute with the values
+	Operator string `json:"operator"`
+	// The values to compare the attribute with
+	Values []string `json:"values"`
+}
+
+// Self validate the properties of a segment
+func (s Segment) Validate() error {
+	for _, rule := range s.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return validateKey("Segment", s.Key)
+}
+
+// Contains checks if a user, some groups or some attributes
+// are in the segment
+func (s Segment) Contains(user uint32, groups []string, attributes map[string]string) bool {
+	if user > 0 && helpers.IntInSlice(user, s.Users) {
+		return true
+	}
+
+	for _, group := range groups {
+		if helpers.StringInSlice(group, s.Groups) {
+			return true
+		}
+	}
+
+	return s.matchesRules(attributes)
+}
+
+// Check if every rule matches the given attributes
+func (s Segment) matchesRules(attributes map[string]string) bool {
+	if len(s.Rules) == 0 {
+		return false
+	}
+
+	for _, rule := range s.Rules {
+		if !rule.Matches(attributes) {
+			return false
+		}
+	}
+	return true
+}
+
+// Self validate the properties of a rule
+func (r Rule) Validate() error {
+	if len(r.Attribute) == 0 {
+		return fmt.Errorf("Rule attribute cannot be empty")
+	}
+
+	if r.Operator != OperatorIn && r.Operator != OperatorNotIn {
+		return fmt.Errorf("Rule operator must be %s or %s", OperatorIn, OperatorNotIn)
+	}
+	return nil
+}
+
+// Matches checks if a rule matches some attributes. A rule
+// never matches when the attribute is not given
+func (r Rule) Matches(attributes map[string]string) bool {
+	value, ok := attributes[r.Attribute]
+	if !ok {
+		return false
+	}
+
+	switch r.Operator {
+	case OperatorIn:
+		return helpers.StringInSlice(value, r.Values)
+	case OperatorNotIn:
+		return !helpers.StringInSlice(value, r.Values)
+	}
+	return false
+}
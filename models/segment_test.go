@@ -0,0 +1,77 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSegment(t *testing.T) {
+	s := Segment{
+		Key:    "ab",
+		Users:  []uint32{},
+		Groups: []string{},
+		Rules:  []Rule{},
+	}
+
+	err := s.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Segment key must be between 3 and 50 characters", err.Error())
+
+	s.Key = "beta_testers"
+	assert.Nil(t, s.Validate())
+
+	s.Rules = []Rule{{Attribute: "country", Operator: "equals", Values: []string{"fr"}}}
+	err = s.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Rule operator must be in or not_in", err.Error())
+
+	s.Rules = []Rule{{Attribute: "", Operator: OperatorIn, Values: []string{"fr"}}}
+	err = s.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, "Rule attribute cannot be empty", err.Error())
+}
+
+func TestSegmentContains(t *testing.T) {
+	s := Segment{
+		Key:    "beta_testers",
+		Users:  []uint32{42},
+		Groups: []string{"beta"},
+		Rules:  []Rule{},
+	}
+
+	assert.True(t, s.Contains(42, []string{}, nil))
+	assert.True(t, s.Contains(1, []string{"dev", "beta"}, nil))
+	assert.False(t, s.Contains(1, []string{"dev"}, nil))
+
+	// Every rule must match
+	s.Rules = []Rule{
+		{Attribute: "country", Operator: OperatorIn, Values: []string{"fr", "de"}},
+		{Attribute: "plan", Operator: OperatorNotIn, Values: []string{"free"}},
+	}
+
+	assert.True(t, s.Contains(1, nil, map[string]string{"country": "fr", "plan": "pro"}))
+	assert.False(t, s.Contains(1, nil, map[string]string{"country": "fr", "plan": "free"}))
+	assert.False(t, s.Contains(1, nil, map[string]string{"country": "us", "plan": "pro"}))
+
+	// A missing attribute never matches
+	assert.False(t, s.Contains(1, nil, map[string]string{"country": "fr"}))
+}
+
+func TestSegmentHasAccess(t *testing.T) {
+	f := FeatureFlag{
+		Key:        "foo",
+		Enabled:    false,
+		Users:      []uint32{},
+		Groups:     []string{},
+		Percentage: 0,
+		Segments:   []string{"beta_testers"},
+	}
+
+	assert.True(t, f.IsPartiallyEnabled())
+	assert.True(t, f.SegmentHasAccess("beta_testers"))
+	assert.False(t, f.SegmentHasAccess("other"))
+
+	f.Enabled = true
+	assert.True(t, f.SegmentHasAccess("other"))
+}
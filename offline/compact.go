@@ -0,0 +1,89 @@
+package offline
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Rewrite a database file to a new file holding only live data,
+// then replace the original file
+func compact(path string, timeout time.Duration, stdout io.Writer) error {
+	source, err := open(path, timeout, true)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	before, err := fileSize(path)
+	if err != nil {
+		return err
+	}
+
+	temporary := path + ".compact"
+	destination, err := bolt.Open(temporary, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	err = source.View(func(src *bolt.Tx) error {
+		return destination.Update(func(dst *bolt.Tx) error {
+			return src.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+				copied, err := dst.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(bucket, copied)
+			})
+		})
+	})
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	source.Close()
+	if err := os.Rename(temporary, path); err != nil {
+		return err
+	}
+
+	after, err := fileSize(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Compacted %s from %d to %d bytes\n", path, before, after)
+	return nil
+}
+
+// Copy records and nested buckets
+func copyBucket(source, destination *bolt.Bucket) error {
+	// Keys are copied in order, pages can be filled
+	destination.FillPercent = 1.0
+
+	return source.ForEach(func(key, value []byte) error {
+		if value != nil {
+			return destination.Put(key, value)
+		}
+
+		nested, err := destination.CreateBucket(key)
+		if err != nil {
+			return err
+		}
+		return copyBucket(source.Bucket(key), nested)
+	})
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,202 @@
+// Package offline inspects and repairs a bolt database file
+// directly, while no server is using it
+package offline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	db "github.com/antoineaugusti/feature-flags/db"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/boltdb/bolt"
+)
+
+// Exit codes of the offline commands
+const (
+	ExitOK = 0
+	// The command failed, or validate found problems
+	ExitError = 1
+	// Invalid command-line arguments
+	ExitUsage = 2
+)
+
+// An offline command, working on an open database
+type command struct {
+	description string
+	// Tell if the command writes to the database
+	writable bool
+	run      func(database *bolt.DB, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"list":     {"List feature flags", false, list},
+	"dump":     {"Write every feature flag and segment as an export document", false, dump},
+	"validate": {"Find invalid or unreadable records", false, validate},
+	"repair":   {"Fix or delete invalid and unreadable records", true, repair},
+}
+
+// Returned by validate when problems are found
+var errProblems = fmt.Errorf("Problems were found, run repair to fix them")
+
+// Run runs an offline command on the bolt database file at
+// path and gives the exit code of the process. It waits up to
+// timeout for another process to release the file
+func Run(path string, timeout time.Duration, args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		printUsage(stderr)
+		return ExitUsage
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+
+	if args[0] == "compact" {
+		return exitCode(compact(path, timeout, stdout), stderr)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %s\n", args[0])
+		printUsage(stderr)
+		return ExitUsage
+	}
+
+	database, err := open(path, timeout, !cmd.writable)
+	if err != nil {
+		return exitCode(err, stderr)
+	}
+	defer database.Close()
+
+	return exitCode(cmd.run(database, stdout), stderr)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: feature-flags -d bolt.db db command")
+	fmt.Fprintln(w, "\nCommands:")
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, name := range []string{"list", "dump", "validate", "repair"} {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(tw, "  compact\tRewrite the database file to reclaim unused space\n")
+	tw.Flush()
+}
+
+func exitCode(err error, stderr io.Writer) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	fmt.Fprintln(stderr, err)
+	return ExitError
+}
+
+// Open a database file which must not be used by a server
+func open(path string, timeout time.Duration, readOnly bool) (*bolt.DB, error) {
+	database, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("Unable to open %s, a server is probably using it", path)
+	}
+	return database, err
+}
+
+func list(database *bolt.DB, stdout io.Writer) error {
+	return database.View(func(tx *bolt.Tx) error {
+		features := tx.Bucket([]byte(db.GetBucketName()))
+		if features == nil {
+			return fmt.Errorf("The %s bucket does not exist", db.GetBucketName())
+		}
+		store := repos.NewBoltTx(tx)
+
+		tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tENABLED\tPERCENTAGE\tUSERS\tGROUPS\tSEGMENTS")
+		for _, key := range bucketKeys(features) {
+			feature, err := store.GetFeature(key)
+			if err != nil {
+				fmt.Fprintf(tw, "%s\tunreadable: %s\n", key, err)
+				continue
+			}
+
+			fmt.Fprintf(tw, "%s\t%t\t%d\t%d\t%s\t%s\n", key, feature.Enabled, feature.Percentage,
+				len(feature.Users), strings.Join(feature.Groups, ","), strings.Join(feature.Segments, ","))
+		}
+		return tw.Flush()
+	})
+}
+
+func dump(database *bolt.DB, stdout io.Writer) error {
+	export := m.Export{Version: m.ExportVersion}
+
+	err := database.View(func(tx *bolt.Tx) (err error) {
+		if tx.Bucket([]byte(db.GetBucketName())) == nil {
+			return fmt.Errorf("The %s bucket does not exist", db.GetBucketName())
+		}
+		store := repos.NewBoltTx(tx)
+
+		if export.Features, err = store.GetFeatures(); err != nil {
+			return err
+		}
+
+		export.Segments, err = store.GetSegments()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to dump the database, run validate to find unreadable records: %s", err)
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+func validate(database *bolt.DB, stdout io.Writer) error {
+	return database.View(func(tx *bolt.Tx) error {
+		problems := findProblems(tx)
+		for _, p := range problems {
+			fmt.Fprintf(stdout, "%s (repair: %s)\n", p.description, p.fix)
+		}
+
+		if len(problems) > 0 {
+			return errProblems
+		}
+		fmt.Fprintln(stdout, "No problems found")
+		return nil
+	})
+}
+
+func repair(database *bolt.DB, stdout io.Writer) error {
+	return database.Update(func(tx *bolt.Tx) error {
+		problems := findProblems(tx)
+		for _, p := range problems {
+			if err := p.apply(); err != nil {
+				return fmt.Errorf("Unable to repair, nothing was changed: %s: %s", p.description, err)
+			}
+			fmt.Fprintf(stdout, "%s (repaired: %s)\n", p.description, p.fix)
+		}
+
+		if len(problems) == 0 {
+			fmt.Fprintln(stdout, "No problems found")
+		}
+		return nil
+	})
+}
+
+// Keys of the records of a bucket, nested buckets excluded
+func bucketKeys(bucket *bolt.Bucket) []string {
+	var keys []string
+	_ = bucket.ForEach(func(key, value []byte) error {
+		if value != nil {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	return keys
+}
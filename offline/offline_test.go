@@ -0,0 +1,161 @@
+package offline
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	db "github.com/antoineaugusti/feature-flags/db"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListAndDump(t *testing.T) {
+	createDatabase(func(tx repos.Tx) error {
+		_ = tx.PutSegment(m.Segment{Key: "beta_testers", Users: []uint32{42}})
+		return tx.PutFeature(m.FeatureFlag{Key: "homepage_v2", Enabled: true, Users: []uint32{1, 2}, Groups: []string{"dev"}, Segments: []string{"beta_testers"}})
+	})
+	defer os.Remove(getDBPath())
+
+	code, stdout, _ := run("list")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "KEY          ENABLED  PERCENTAGE  USERS  GROUPS  SEGMENTS\nhomepage_v2  true     0           2      dev     beta_testers\n", stdout)
+
+	var export m.Export
+	code, stdout, _ = run("dump")
+	assert.Equal(t, ExitOK, code)
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &export))
+	assert.Nil(t, export.Validate())
+	assert.Equal(t, []uint32{1, 2}, export.Features[0].Users)
+	assert.Equal(t, "beta_testers", export.Segments[0].Key)
+}
+
+func TestValidateAndRepair(t *testing.T) {
+	createDatabase(func(tx repos.Tx) error {
+		_ = tx.PutSegment(m.Segment{Key: "beta_testers"})
+		_ = tx.PutFeature(m.FeatureFlag{Key: "valid", Segments: []string{"beta_testers"}})
+		_ = tx.PutFeature(m.FeatureFlag{Key: "too_much", Percentage: 142, Segments: []string{"unknown"}, Users: []uint32{1}})
+		return tx.PutFeature(m.FeatureFlag{Key: "gone", Users: []uint32{1}})
+	})
+	defer os.Remove(getDBPath())
+
+	// Corrupt the database
+	database := openDatabase()
+	_ = database.Update(func(tx *bolt.Tx) error {
+		features := tx.Bucket([]byte(db.GetBucketName()))
+		_ = features.Put([]byte("broken"), []byte("{not json"))
+		_ = features.Put([]byte("AB"), []byte(`{"key":"AB"}`))
+		return features.Delete([]byte("gone"))
+	})
+	database.Close()
+
+	code, stdout, stderr := run("validate")
+	assert.Equal(t, ExitError, code)
+	assert.Equal(t, "Problems were found, run repair to fix them\n", stderr)
+	assert.Contains(t, stdout, "feature AB: Feature key must be between 3 and 50 characters (repair: delete the feature)\n")
+	assert.Contains(t, stdout, "feature broken: cannot be decoded")
+	assert.Contains(t, stdout, "feature too_much: percentage 142 is greater than 100, reset to 0, segment unknown does not exist (repair: rewrite the feature)\n")
+	assert.Contains(t, stdout, "users of feature gone: the feature does not exist (repair: delete the users)\n")
+	assert.NotContains(t, stdout, "feature valid")
+
+	code, _, _ = run("repair")
+	assert.Equal(t, ExitOK, code)
+
+	code, stdout, _ = run("validate")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "No problems found\n", stdout)
+
+	database = openDatabase()
+	defer database.Close()
+	_ = repos.NewBoltStore(database).View(func(tx repos.Tx) error {
+		features, _ := tx.GetFeatures()
+		assert.Equal(t, 2, len(features))
+		assert.Equal(t, "too_much", features[0].Key)
+		assert.Equal(t, uint32(0), features[0].Percentage)
+		assert.Equal(t, []string{}, features[0].Segments)
+		assert.Equal(t, []uint32{1}, features[0].Users)
+		return nil
+	})
+}
+
+func TestCompact(t *testing.T) {
+	createDatabase(func(tx repos.Tx) error {
+		_ = tx.PutFeature(m.FeatureFlag{Key: "homepage_v2"})
+		for i := uint32(1); i <= 1000; i++ {
+			if err := tx.AddUser("homepage_v2", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer os.Remove(getDBPath())
+
+	code, stdout, stderr := run("compact")
+	assert.Equal(t, ExitOK, code, stderr)
+	assert.Contains(t, stdout, "Compacted "+getDBPath())
+
+	database := openDatabase()
+	defer database.Close()
+	_ = repos.NewBoltStore(database).View(func(tx repos.Tx) error {
+		feature, err := tx.GetFeature("homepage_v2")
+		assert.Nil(t, err)
+		assert.Equal(t, 1000, len(feature.Users))
+		return nil
+	})
+}
+
+func TestUsage(t *testing.T) {
+	code, _, _ := run()
+	assert.Equal(t, ExitUsage, code)
+
+	code, _, stderr := run("list")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, "no such file or directory")
+}
+
+func TestDatabaseInUse(t *testing.T) {
+	createDatabase(func(tx repos.Tx) error {
+		return tx.PutFeature(m.FeatureFlag{Key: "homepage_v2"})
+	})
+	defer os.Remove(getDBPath())
+
+	// Another process holds the file
+	database := openDatabase()
+	defer database.Close()
+
+	code, _, stderr := run("list")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, "a server is probably using it")
+}
+
+func run(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := Run(getDBPath(), 10*time.Millisecond, args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func createDatabase(fn func(repos.Tx) error) {
+	database := openDatabase()
+	defer database.Close()
+
+	db.GenerateDefaultBucket(db.GetBucketName(), database)
+	if err := repos.NewBoltStore(database).Update(fn); err != nil {
+		panic(err)
+	}
+}
+
+func openDatabase() *bolt.DB {
+	database, err := bolt.Open(getDBPath(), 0600, nil)
+	if err != nil {
+		panic(err)
+	}
+	return database
+}
+
+func getDBPath() string {
+	return "/tmp/offline_test.db"
+}
@@ -0,0 +1,155 @@
+package offline
+
+import (
+	"fmt"
+	"strings"
+
+	db "github.com/antoineaugusti/feature-flags/db"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/boltdb/bolt"
+)
+
+// A problem found in the database, and how repair fixes it
+type problem struct {
+	// What is wrong, like "feature foo: cannot be decoded"
+	description string
+	// How repair fixes it, like "delete the record"
+	fix string
+	// Fix the problem, within a writable transaction
+	apply func() error
+}
+
+// Find invalid and unreadable records. Problems can only
+// be applied when the transaction is writable
+func findProblems(tx *bolt.Tx) []problem {
+	if tx.Bucket([]byte(db.GetBucketName())) == nil {
+		return []problem{{
+			description: fmt.Sprintf("the %s bucket does not exist", db.GetBucketName()),
+			fix:         "create it",
+			apply: func() error {
+				_, err := tx.CreateBucket([]byte(db.GetBucketName()))
+				return err
+			},
+		}}
+	}
+
+	store := repos.NewBoltTx(tx)
+	problems, segments := segmentProblems(tx, store)
+	problems = append(problems, featureProblems(tx, store, segments)...)
+	return append(problems, userProblems(tx)...)
+}
+
+// Find problems with segments, and list valid segments
+func segmentProblems(tx *bolt.Tx, store repos.Tx) ([]problem, map[string]bool) {
+	var problems []problem
+	valid := make(map[string]bool)
+
+	bucket := tx.Bucket([]byte(db.GetSegmentsBucketName()))
+	if bucket == nil {
+		return problems, valid
+	}
+
+	for _, key := range bucketKeys(bucket) {
+		key := key
+		remove := func() error { return store.RemoveSegment(key) }
+
+		segment, err := store.GetSegment(key)
+		if err != nil {
+			problems = append(problems, problem{fmt.Sprintf("segment %s: cannot be decoded: %s", key, err), "delete the segment", remove})
+			continue
+		}
+
+		if err := segment.Validate(); err != nil || segment.Key != key {
+			if err == nil {
+				err = fmt.Errorf("stored with key %s", segment.Key)
+			}
+			problems = append(problems, problem{fmt.Sprintf("segment %s: %s", key, err), "delete the segment", remove})
+			continue
+		}
+
+		valid[key] = true
+	}
+
+	return problems, valid
+}
+
+// Find problems with feature flags
+func featureProblems(tx *bolt.Tx, store repos.Tx, segments map[string]bool) []problem {
+	var problems []problem
+
+	for _, key := range bucketKeys(tx.Bucket([]byte(db.GetBucketName()))) {
+		key := key
+		remove := func() error { return store.RemoveFeature(key) }
+
+		feature, err := store.GetFeature(key)
+		if err != nil {
+			problems = append(problems, problem{fmt.Sprintf("feature %s: cannot be decoded: %s", key, err), "delete the feature", remove})
+			continue
+		}
+
+		// Fix what can be fixed, and rewrite the feature flag
+		var fixes []string
+		if feature.Key != key {
+			fixes = append(fixes, fmt.Sprintf("stored with key %s", feature.Key))
+			feature.Key = key
+		}
+
+		// An invalid percentage gives access to nobody, rather than to everybody
+		err = feature.Validate()
+		if err != nil && err.Error() == "Percentage must be between 0 and 100" {
+			fixes = append(fixes, fmt.Sprintf("percentage %d is greater than 100, reset to 0", feature.Percentage))
+			feature.Percentage = 0
+			err = feature.Validate()
+		}
+		if err != nil {
+			problems = append(problems, problem{fmt.Sprintf("feature %s: %s", key, err), "delete the feature", remove})
+			continue
+		}
+
+		references := make([]string, 0, len(feature.Segments))
+		for _, segmentKey := range feature.Segments {
+			if segments[segmentKey] {
+				references = append(references, segmentKey)
+			} else {
+				fixes = append(fixes, fmt.Sprintf("segment %s does not exist", segmentKey))
+			}
+		}
+		feature.Segments = references
+
+		if len(fixes) > 0 {
+			fixed := feature
+			problems = append(problems, problem{
+				description: fmt.Sprintf("feature %s: %s", key, strings.Join(fixes, ", ")),
+				fix:         "rewrite the feature",
+				apply:       func() error { return store.PutFeature(fixed) },
+			})
+		}
+	}
+
+	return problems
+}
+
+// Find users of feature flags which do not exist
+func userProblems(tx *bolt.Tx) []problem {
+	var problems []problem
+
+	users := tx.Bucket([]byte(db.GetUsersBucketName()))
+	if users == nil {
+		return problems
+	}
+
+	features := tx.Bucket([]byte(db.GetBucketName()))
+	_ = users.ForEach(func(key, value []byte) error {
+		if value == nil && features.Get(key) == nil {
+			name := string(key)
+			problems = append(problems, problem{
+				description: fmt.Sprintf("users of feature %s: the feature does not exist", name),
+				fix:         "delete the users",
+				apply:       func() error { return users.DeleteBucket([]byte(name)) },
+			})
+		}
+		return nil
+	})
+
+	return problems
+}
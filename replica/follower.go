@@ -0,0 +1,98 @@
+// Package replica keeps a local copy of the feature flags
+// and segments of a primary server
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	services "github.com/antoineaugusti/feature-flags/services"
+)
+
+// How long to wait before following the primary server again after an error
+const retryDelay = time.Second
+
+// Follower applies the changes of a primary server to a local service
+type Follower struct {
+	// The URL of the primary server, like http://primary:8080
+	Primary string
+	// The service holding the local copy
+	Service *services.FeatureService
+	// How long the primary server holds a request when nothing changed
+	Wait time.Duration
+	// The client used to reach the primary server
+	Client *http.Client
+	// The latest change log and revision of the primary server applied
+	epoch    string
+	revision uint64
+}
+
+// NewFollower creates a follower of a primary server
+func NewFollower(primary string, service *services.FeatureService) *Follower {
+	wait := 30 * time.Second
+
+	return &Follower{
+		Primary: primary,
+		Service: service,
+		Wait:    wait,
+		Client:  &http.Client{Timeout: wait + 10*time.Second},
+	}
+}
+
+// Sync fetches and applies the changes of the primary server once,
+// waiting at most wait for changes to happen
+func (f *Follower) Sync(wait time.Duration) error {
+	query := url.Values{}
+	query.Set("epoch", f.epoch)
+	query.Set("since", strconv.FormatUint(f.revision, 10))
+	query.Set("wait", wait.String())
+
+	res, err := f.Client.Get(f.Primary + "/changes?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d from the primary server", res.StatusCode)
+	}
+
+	var changes services.Changes
+	if err := json.NewDecoder(res.Body).Decode(&changes); err != nil {
+		return err
+	}
+
+	if err := f.Service.ApplyChanges(context.Background(), changes); err != nil {
+		return err
+	}
+
+	f.epoch, f.revision = changes.Epoch, changes.Revision
+	return nil
+}
+
+// Run follows the primary server until stop is closed
+func (f *Follower) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err := f.Sync(f.Wait); err != nil {
+			slog.Warn("Unable to follow the primary server", "primary", f.Primary, "error", err)
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package replica
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	h "github.com/antoineaugusti/feature-flags/http"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	services "github.com/antoineaugusti/feature-flags/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollower(t *testing.T) {
+	primary := getService()
+	server := httptest.NewServer(h.NewRouter(h.APIHandler{FeatureService: primary}))
+	defer server.Close()
+
+	local := getService()
+	follower := NewFollower(server.URL, local)
+
+	// Everything is fetched at first
+	_ = primary.AddFeature(context.Background(), m.FeatureFlag{Key: "foo", Users: []uint32{42}})
+	_ = primary.AddSegment(context.Background(), m.Segment{Key: "beta_testers"})
+
+	assert.Nil(t, follower.Sync(0))
+	assert.True(t, hasFeature(local, "foo"))
+	assert.True(t, hasSegment(local, "beta_testers"))
+
+	// Then only changes
+	_ = primary.AddFeature(context.Background(), m.FeatureFlag{Key: "bar"})
+	_ = primary.RemoveFeature(context.Background(), "foo")
+	_ = primary.RemoveSegment(context.Background(), "beta_testers")
+
+	assert.Nil(t, follower.Sync(0))
+	assert.False(t, hasFeature(local, "foo"))
+	assert.True(t, hasFeature(local, "bar"))
+	assert.False(t, hasSegment(local, "beta_testers"))
+
+	// Changes are awaited
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = primary.AddUser(context.Background(), "bar", 1337)
+	}()
+
+	assert.Nil(t, follower.Sync(5*time.Second))
+	snapshot, _ := local.Snapshot(context.Background())
+	feature, _ := snapshot.Feature("bar")
+	assert.True(t, feature.UserHasAccess(1337))
+
+	// Everything is fetched again from a restarted primary
+	restarted := getService()
+	_ = restarted.AddFeature(context.Background(), m.FeatureFlag{Key: "baz"})
+	follower.Primary = httptest.NewServer(h.NewRouter(h.APIHandler{FeatureService: restarted})).URL
+
+	assert.Nil(t, follower.Sync(0))
+	assert.False(t, hasFeature(local, "bar"))
+	assert.True(t, hasFeature(local, "baz"))
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	local := getService()
+	_ = local.AddFeature(context.Background(), m.FeatureFlag{Key: "foo", Enabled: true})
+
+	server := httptest.NewServer(h.NewRouter(h.APIHandler{FeatureService: local, ReadOnly: true}))
+	defer server.Close()
+
+	res, _ := server.Client().Post(server.URL+"/features", "application/json", nil)
+	assert.Equal(t, 403, res.StatusCode)
+
+	res, _ = server.Client().Post(server.URL+"/features/foo/access", "application/json", nil)
+	assert.Equal(t, 422, res.StatusCode)
+
+	res, _ = server.Client().Get(server.URL + "/features/foo")
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func getService() *services.FeatureService {
+	service, err := services.NewFeatureService(repos.NewMemoryStore())
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+func hasFeature(service *services.FeatureService, featureKey string) bool {
+	snapshot, _ := service.Snapshot(context.Background())
+	_, ok := snapshot.Feature(featureKey)
+	return ok
+}
+
+func hasSegment(service *services.FeatureService, segmentKey string) bool {
+	snapshot, _ := service.Snapshot(context.Background())
+	_, ok := snapshot.Segments[segmentKey]
+	return ok
+}
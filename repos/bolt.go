@@ -0,0 +1,51 @@
+package repos
+
+import (
+	"time"
+
+	metrics "github.com/antoineaugusti/feature-flags/metrics"
+	"github.com/boltdb/bolt"
+)
+
+// A store backed by a bolt database
+type boltStore struct {
+	db *bolt.DB
+}
+
+// A transaction of a bolt database
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// NewBoltStore creates a store backed by a bolt database. The
+// features bucket must exist, see db.GenerateDefaultBucket
+func NewBoltStore(db *bolt.DB) Store {
+	return boltStore{db}
+}
+
+// NewBoltTx reads and writes feature flags and segments within a
+// transaction of a bolt database, for tools working on the file directly
+func NewBoltTx(tx *bolt.Tx) Tx {
+	return boltTx{tx}
+}
+
+func (s boltStore) View(fn func(Tx) error) error {
+	defer observeTransaction("view", time.Now())
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s boltStore) Update(fn func(Tx) error) error {
+	defer observeTransaction("update", time.Now())
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+// Record the duration of a transaction
+func observeTransaction(kind string, start time.Time) {
+	metrics.BoltTransactionDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,101 @@
+package repos_test
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	db "github.com/antoineaugusti/feature-flags/db"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/antoineaugusti/feature-flags/repos/storetest"
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltStore(t *testing.T) {
+	storetest.TestStore(t, func() (repos.Store, func()) {
+		database := getTestDB()
+		return repos.NewBoltStore(database), func() { closeDB(database) }
+	})
+}
+
+func TestUsersStoredOutsideFeature(t *testing.T) {
+	database := getTestDB()
+	defer closeDB(database)
+	store := repos.NewBoltStore(database)
+
+	_ = store.Update(func(tx repos.Tx) error {
+		return tx.PutFeature(m.FeatureFlag{Key: "foo", Users: []uint32{1337, 22, 42}})
+	})
+
+	// Users are not part of the stored feature
+	_ = database.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(db.GetBucketName())).Get([]byte("foo"))
+		assert.NotContains(t, string(value), "users")
+		return nil
+	})
+
+	// Users are replaced, unchanged users are kept
+	_ = store.Update(func(tx repos.Tx) error {
+		return tx.PutFeature(m.FeatureFlag{Key: "foo", Users: []uint32{7, 42, 22, 7}})
+	})
+	_ = store.View(func(tx repos.Tx) error {
+		feature, _ := tx.GetFeature("foo")
+		assert.Equal(t, []uint32{7, 22, 42}, feature.Users)
+		return nil
+	})
+}
+
+func TestUsersStoredInsideFeature(t *testing.T) {
+	database := getTestDB()
+	defer closeDB(database)
+	store := repos.NewBoltStore(database)
+
+	// A feature stored by an older version
+	_ = database.Update(func(tx *bolt.Tx) error {
+		value := `{"key":"foo","enabled":false,"users":[42,22],"groups":[],"percentage":0}`
+		return tx.Bucket([]byte(db.GetBucketName())).Put([]byte("foo"), []byte(value))
+	})
+
+	_ = store.View(func(tx repos.Tx) error {
+		feature, _ := tx.GetFeature("foo")
+		assert.Equal(t, []uint32{42, 22}, feature.Users)
+		assert.Equal(t, []string{}, feature.Segments)
+		return nil
+	})
+
+	// Users are moved when a single user is added
+	_ = store.Update(func(tx repos.Tx) error {
+		return tx.AddUser("foo", 1)
+	})
+
+	_ = store.View(func(tx repos.Tx) error {
+		feature, _ := tx.GetFeature("foo")
+		assert.Equal(t, []uint32{1, 22, 42}, feature.Users)
+		return nil
+	})
+}
+
+func getTestDB() *bolt.DB {
+	database, err := bolt.Open(getDBPath(), 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db.GenerateDefaultBucket(db.GetBucketName(), database)
+
+	return database
+}
+
+func getDBPath() string {
+	return "/tmp/repos_test.db"
+}
+
+func closeDB(database *bolt.DB) {
+	database.Close()
+	if err := os.Remove(getDBPath()); err != nil {
+		panic(err)
+	}
+}
@@ -1,6 +1,7 @@
 package repos
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 
@@ -9,11 +10,19 @@ import (
 	"github.com/boltdb/bolt"
 )
 
+// The representation of a feature flag in the features bucket.
+// Users are kept in a nested bucket of the users bucket, they are
+// only present here for feature flags stored by older versions
+type storedFeature struct {
+	m.FeatureFlag
+	Users []uint32 `json:"users,omitempty"`
+}
+
 // Update a feature flag
-func PutFeature(tx *bolt.Tx, feature m.FeatureFlag) error {
-	features := tx.Bucket([]byte(db.GetBucketName()))
+func (t boltTx) PutFeature(feature m.FeatureFlag) error {
+	features := t.tx.Bucket([]byte(db.GetBucketName()))
 
-	bytes, err := json.Marshal(feature)
+	bytes, err := json.Marshal(storedFeature{FeatureFlag: feature})
 	if err != nil {
 		return err
 	}
@@ -23,20 +32,18 @@ func PutFeature(tx *bolt.Tx, feature m.FeatureFlag) error {
 		return err
 	}
 
-	return nil
+	return t.putUsers(feature.Key, feature.Users)
 }
 
 // GetFeatures gets a list of feature flags
-func GetFeatures(tx *bolt.Tx) (m.FeatureFlags, error) {
-	featuresBucket := tx.Bucket([]byte(db.GetBucketName()))
+func (t boltTx) GetFeatures() (m.FeatureFlags, error) {
+	featuresBucket := t.tx.Bucket([]byte(db.GetBucketName()))
 	cursor := featuresBucket.Cursor()
 
 	features := make(m.FeatureFlags, 0)
 
 	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-		feature := m.FeatureFlag{}
-
-		err := json.Unmarshal(value, &feature)
+		feature, err := t.decodeFeature(value)
 		if err != nil {
 			return nil, err
 		}
@@ -47,33 +54,206 @@ func GetFeatures(tx *bolt.Tx) (m.FeatureFlags, error) {
 }
 
 // Tell if a feature exists
-func FeatureExists(tx *bolt.Tx, featureKey string) bool {
-	features := tx.Bucket([]byte(db.GetBucketName()))
+func (t boltTx) FeatureExists(featureKey string) (bool, error) {
+	features := t.tx.Bucket([]byte(db.GetBucketName()))
 	bytes := features.Get([]byte(featureKey))
-	return bytes != nil
+	return bytes != nil, nil
 }
 
 // GetFeature gets a feature flag thanks to its key
-func GetFeature(tx *bolt.Tx, featureKey string) (m.FeatureFlag, error) {
-	features := tx.Bucket([]byte(db.GetBucketName()))
+func (t boltTx) GetFeature(featureKey string) (m.FeatureFlag, error) {
+	features := t.tx.Bucket([]byte(db.GetBucketName()))
 
 	bytes := features.Get([]byte(featureKey))
 	if bytes == nil {
 		return m.FeatureFlag{}, fmt.Errorf("Unable to find feature")
 	}
 
-	feature := m.FeatureFlag{}
+	return t.decodeFeature(bytes)
+}
+
+// Delete a feature flag thanks to its key
+func (t boltTx) RemoveFeature(featureKey string) error {
+	features := t.tx.Bucket([]byte(db.GetBucketName()))
+	if err := features.Delete([]byte(featureKey)); err != nil {
+		return err
+	}
+
+	return t.putUsers(featureKey, nil)
+}
+
+// AddUser gives access to a feature flag to a user
+// without rewriting the other users
+func (t boltTx) AddUser(featureKey string, user uint32) error {
+	if err := t.migrateUsers(featureKey); err != nil {
+		return err
+	}
+
+	users, err := t.usersBucket(featureKey)
+	if err != nil {
+		return err
+	}
+
+	return users.Put(userToBytes(user), []byte{})
+}
+
+// RemoveUser removes a user from the allowed users of a feature flag
+// without rewriting the other users
+func (t boltTx) RemoveUser(featureKey string, user uint32) error {
+	if err := t.migrateUsers(featureKey); err != nil {
+		return err
+	}
 
-	err := json.Unmarshal(bytes, &feature)
+	users, err := t.usersBucket(featureKey)
+	if err != nil {
+		return err
+	}
+
+	return users.Delete(userToBytes(user))
+}
+
+// Decode a stored feature flag and load its users
+func (t boltTx) decodeFeature(value []byte) (m.FeatureFlag, error) {
+	stored := storedFeature{}
+
+	err := json.Unmarshal(value, &stored)
 	if err != nil {
 		return m.FeatureFlag{}, err
 	}
 
+	feature := stored.FeatureFlag
+	feature.Users = t.getUsers(feature.Key)
+	if len(feature.Users) == 0 && stored.Users != nil {
+		feature.Users = stored.Users
+	}
+	feature.IndexUsers()
+
+	// Feature flags stored by older versions have no segments
+	if feature.Segments == nil {
+		feature.Segments = []string{}
+	}
+
 	return feature, nil
 }
 
-// Delete a feature flag thanks to its key
-func RemoveFeature(tx *bolt.Tx, featureKey string) error {
-	features := tx.Bucket([]byte(db.GetBucketName()))
-	return features.Delete([]byte(featureKey))
+// Move the users of a feature flag stored by an older version
+// to the users bucket
+func (t boltTx) migrateUsers(featureKey string) error {
+	features := t.tx.Bucket([]byte(db.GetBucketName()))
+
+	bytes := features.Get([]byte(featureKey))
+	if bytes == nil {
+		return fmt.Errorf("Unable to find feature")
+	}
+
+	stored := storedFeature{}
+	if err := json.Unmarshal(bytes, &stored); err != nil {
+		return err
+	}
+
+	if len(stored.Users) == 0 {
+		return nil
+	}
+
+	feature, err := t.decodeFeature(bytes)
+	if err != nil {
+		return err
+	}
+
+	return t.PutFeature(feature)
+}
+
+// Get the allowed users of a feature flag, sorted by ID
+func (t boltTx) getUsers(featureKey string) []uint32 {
+	users := make([]uint32, 0)
+
+	bucket := t.tx.Bucket([]byte(db.GetUsersBucketName()))
+	if bucket == nil {
+		return users
+	}
+
+	nested := bucket.Bucket([]byte(featureKey))
+	if nested == nil {
+		return users
+	}
+
+	cursor := nested.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		users = append(users, bytesToUser(key))
+	}
+
+	return users
+}
+
+// Replace the allowed users of a feature flag. Only the users which
+// were added or removed are written
+func (t boltTx) putUsers(featureKey string, users []uint32) error {
+	bucket, err := t.tx.CreateBucketIfNotExists([]byte(db.GetUsersBucketName()))
+	if err != nil {
+		return err
+	}
+
+	nested := bucket.Bucket([]byte(featureKey))
+	if len(users) == 0 {
+		if nested == nil {
+			return nil
+		}
+		return bucket.DeleteBucket([]byte(featureKey))
+	}
+
+	if nested == nil {
+		if nested, err = bucket.CreateBucket([]byte(featureKey)); err != nil {
+			return err
+		}
+	}
+
+	added := make(map[uint32]bool, len(users))
+	for _, user := range users {
+		added[user] = true
+	}
+
+	// Keys are copied: they cannot be used once the bucket changes
+	var removed [][]byte
+	cursor := nested.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		if user := bytesToUser(key); added[user] {
+			delete(added, user)
+		} else {
+			removed = append(removed, append([]byte{}, key...))
+		}
+	}
+
+	for _, key := range removed {
+		if err := nested.Delete(key); err != nil {
+			return err
+		}
+	}
+	for user := range added {
+		if err := nested.Put(userToBytes(user), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get the nested bucket holding the users of a feature flag
+func (t boltTx) usersBucket(featureKey string) (*bolt.Bucket, error) {
+	bucket, err := t.tx.CreateBucketIfNotExists([]byte(db.GetUsersBucketName()))
+	if err != nil {
+		return nil, err
+	}
+
+	return bucket.CreateBucketIfNotExists([]byte(featureKey))
+}
+
+// Big endian keys keep users sorted by ID in the bucket
+func userToBytes(user uint32) []byte {
+	bytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(bytes, user)
+	return bytes
+}
+
+func bytesToUser(bytes []byte) uint32 {
+	return binary.BigEndian.Uint32(bytes)
 }
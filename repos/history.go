@@ -0,0 +1,121 @@
+package repos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	db "github.com/antoineaugusti/feature-flags/db"
+	m "github.com/antoineaugusti/feature-flags/models"
+)
+
+// Record a change of a feature flag
+func (t boltTx) AddChange(change m.FeatureChange) error {
+	bucket, err := t.tx.CreateBucketIfNotExists([]byte(db.GetHistoryBucketName()))
+	if err != nil {
+		return err
+	}
+
+	changes, err := bucket.CreateBucketIfNotExists([]byte(change.Feature.Key))
+	if err != nil {
+		return err
+	}
+
+	// The sequence of the bucket is not used: it is lost when
+	// the database is compacted
+	next := uint64(1)
+	if last, _ := changes.Cursor().Last(); last != nil {
+		next = binary.BigEndian.Uint64(last) + 1
+	}
+	if change.Revision < next {
+		change.Revision = next
+	}
+
+	value, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	if err = changes.Put(revisionToBytes(change.Revision), value); err != nil {
+		return err
+	}
+
+	if change.Revision <= maxChanges {
+		return nil
+	}
+
+	// Keys are copied: they cannot be used once the bucket changes
+	var removed [][]byte
+	oldest := revisionToBytes(change.Revision - maxChanges)
+	cursor := changes.Cursor()
+	for key, _ := cursor.First(); key != nil && bytes.Compare(key, oldest) <= 0; key, _ = cursor.Next() {
+		removed = append(removed, append([]byte{}, key...))
+	}
+
+	for _, key := range removed {
+		if err := changes.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChanges gets the history of a feature flag, newest first
+func (t boltTx) GetChanges(featureKey string) ([]m.FeatureChange, error) {
+	history := make([]m.FeatureChange, 0)
+
+	bucket := t.tx.Bucket([]byte(db.GetHistoryBucketName()))
+	if bucket == nil {
+		return history, nil
+	}
+
+	changes := bucket.Bucket([]byte(featureKey))
+	if changes == nil {
+		return history, nil
+	}
+
+	cursor := changes.Cursor()
+	for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+		change := m.FeatureChange{}
+		if err := json.Unmarshal(value, &change); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	return history, nil
+}
+
+// GetChangedFeatures gets the keys of the feature flags having a history
+func (t boltTx) GetChangedFeatures() ([]string, error) {
+	keys := make([]string, 0)
+
+	bucket := t.tx.Bucket([]byte(db.GetHistoryBucketName()))
+	if bucket == nil {
+		return keys, nil
+	}
+
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		keys = append(keys, string(key))
+	}
+
+	return keys, nil
+}
+
+// Delete the history of a feature flag
+func (t boltTx) RemoveChanges(featureKey string) error {
+	bucket := t.tx.Bucket([]byte(db.GetHistoryBucketName()))
+	if bucket == nil || bucket.Bucket([]byte(featureKey)) == nil {
+		return nil
+	}
+
+	return bucket.DeleteBucket([]byte(featureKey))
+}
+
+// Big endian keys keep changes sorted by revision in the bucket
+func revisionToBytes(revision uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, revision)
+	return key
+}
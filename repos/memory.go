@@ -0,0 +1,316 @@
+package repos
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+)
+
+// A store keeping feature flags and segments in memory
+type memoryStore struct {
+	lock sync.RWMutex
+	data memoryData
+}
+
+// The content of a memory store
+type memoryData struct {
+	features map[string]m.FeatureFlag
+	segments map[string]m.Segment
+	// The history of feature flags, oldest first
+	changes map[string][]m.FeatureChange
+}
+
+// A transaction of a memory store
+type memoryTx struct {
+	data     memoryData
+	writable bool
+}
+
+// NewMemoryStore creates an empty store keeping feature flags
+// and segments in memory. Data is lost when the process exits
+func NewMemoryStore() Store {
+	return &memoryStore{data: memoryData{
+		features: make(map[string]m.FeatureFlag),
+		segments: make(map[string]m.Segment),
+		changes:  make(map[string][]m.FeatureChange),
+	}}
+}
+
+func (s *memoryStore) View(fn func(Tx) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return fn(memoryTx{data: s.data})
+}
+
+func (s *memoryStore) Update(fn func(Tx) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Work on a copy, so that nothing changes if fn fails
+	data := memoryData{
+		features: make(map[string]m.FeatureFlag, len(s.data.features)),
+		segments: make(map[string]m.Segment, len(s.data.segments)),
+		changes:  make(map[string][]m.FeatureChange, len(s.data.changes)),
+	}
+	for key, feature := range s.data.features {
+		data.features[key] = feature
+	}
+	for key, segment := range s.data.segments {
+		data.segments[key] = segment
+	}
+	// Histories are only appended to with a full slice expression:
+	// they can be shared
+	for key, changes := range s.data.changes {
+		data.changes[key] = changes
+	}
+
+	if err := fn(memoryTx{data: data, writable: true}); err != nil {
+		return err
+	}
+
+	s.data = data
+	return nil
+}
+
+func (t memoryTx) GetFeature(featureKey string) (m.FeatureFlag, error) {
+	feature, ok := t.data.features[featureKey]
+	if !ok {
+		return m.FeatureFlag{}, fmt.Errorf("Unable to find feature")
+	}
+
+	return copyFeature(feature), nil
+}
+
+func (t memoryTx) GetFeatures() (m.FeatureFlags, error) {
+	features := make(m.FeatureFlags, 0, len(t.data.features))
+	for _, key := range sortedKeys(t.data.features) {
+		features = append(features, copyFeature(t.data.features[key]))
+	}
+
+	return features, nil
+}
+
+func (t memoryTx) FeatureExists(featureKey string) (bool, error) {
+	_, ok := t.data.features[featureKey]
+	return ok, nil
+}
+
+func (t memoryTx) PutFeature(feature m.FeatureFlag) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+
+	feature = copyFeature(feature)
+	sortUsers(feature.Users)
+	feature.Users = uniqueUsers(feature.Users)
+	t.data.features[feature.Key] = feature
+	return nil
+}
+
+func (t memoryTx) RemoveFeature(featureKey string) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+
+	delete(t.data.features, featureKey)
+	return nil
+}
+
+func (t memoryTx) AddUser(featureKey string, user uint32) error {
+	feature, err := t.GetFeature(featureKey)
+	if err != nil {
+		return err
+	}
+
+	feature.AddUser(user)
+	return t.PutFeature(feature)
+}
+
+func (t memoryTx) RemoveUser(featureKey string, user uint32) error {
+	feature, err := t.GetFeature(featureKey)
+	if err != nil {
+		return err
+	}
+
+	feature.RemoveUser(user)
+	return t.PutFeature(feature)
+}
+
+func (t memoryTx) GetSegment(segmentKey string) (m.Segment, error) {
+	segment, ok := t.data.segments[segmentKey]
+	if !ok {
+		return m.Segment{}, fmt.Errorf("Unable to find segment")
+	}
+
+	return copySegment(segment), nil
+}
+
+func (t memoryTx) GetSegments() (m.Segments, error) {
+	keys := make([]string, 0, len(t.data.segments))
+	for key := range t.data.segments {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	segments := make(m.Segments, 0, len(keys))
+	for _, key := range keys {
+		segments = append(segments, copySegment(t.data.segments[key]))
+	}
+
+	return segments, nil
+}
+
+func (t memoryTx) SegmentExists(segmentKey string) (bool, error) {
+	_, ok := t.data.segments[segmentKey]
+	return ok, nil
+}
+
+func (t memoryTx) PutSegment(segment m.Segment) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+
+	t.data.segments[segment.Key] = copySegment(segment)
+	return nil
+}
+
+func (t memoryTx) RemoveSegment(segmentKey string) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+
+	delete(t.data.segments, segmentKey)
+	return nil
+}
+
+func (t memoryTx) AddChange(change m.FeatureChange) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+
+	changes := t.data.changes[change.Feature.Key]
+	next := uint64(1)
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Revision + 1
+	}
+	if change.Revision < next {
+		change.Revision = next
+	}
+	change.Feature = copyFeature(change.Feature)
+
+	changes = append(changes[:len(changes):len(changes)], change)
+	for len(changes) > 0 && changes[0].Revision+maxChanges <= change.Revision {
+		changes = changes[1:]
+	}
+	t.data.changes[change.Feature.Key] = changes
+	return nil
+}
+
+func (t memoryTx) GetChanges(featureKey string) ([]m.FeatureChange, error) {
+	changes := t.data.changes[featureKey]
+
+	history := make([]m.FeatureChange, 0, len(changes))
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		change.Feature = copyFeature(change.Feature)
+		history = append(history, change)
+	}
+
+	return history, nil
+}
+
+func (t memoryTx) GetChangedFeatures() ([]string, error) {
+	keys := make([]string, 0, len(t.data.changes))
+	for key := range t.data.changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (t memoryTx) RemoveChanges(featureKey string) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+
+	delete(t.data.changes, featureKey)
+	return nil
+}
+
+// Refuse writes in read-only transactions, as bolt does
+func (t memoryTx) checkWritable() error {
+	if !t.writable {
+		return fmt.Errorf("tx not writable")
+	}
+	return nil
+}
+
+// Copy a feature flag so that the store and its callers never
+// share slices. Lists are empty or nil as they are after a JSON
+// round trip in the bolt store
+func copyFeature(feature m.FeatureFlag) m.FeatureFlag {
+	copied := feature
+	copied.Users = append(make([]uint32, 0, len(feature.Users)), feature.Users...)
+	copied.Groups = copyStrings(feature.Groups)
+	copied.Segments = append(make([]string, 0, len(feature.Segments)), feature.Segments...)
+	copied.IndexUsers()
+	return copied
+}
+
+// Copy a segment so that the store and its callers never share slices
+func copySegment(segment m.Segment) m.Segment {
+	copied := segment
+	copied.Groups = copyStrings(segment.Groups)
+	if segment.Users != nil {
+		copied.Users = append(make([]uint32, 0, len(segment.Users)), segment.Users...)
+	}
+	if segment.Rules != nil {
+		copied.Rules = make([]m.Rule, 0, len(segment.Rules))
+		for _, rule := range segment.Rules {
+			rule.Values = copyStrings(rule.Values)
+			copied.Rules = append(copied.Rules, rule)
+		}
+	}
+	return copied
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(values)), values...)
+}
+
+func sortedKeys(features map[string]m.FeatureFlag) []string {
+	keys := make([]string, 0, len(features))
+	for key := range features {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type usersByID []uint32
+
+func (u usersByID) Len() int           { return len(u) }
+func (u usersByID) Less(i, j int) bool { return u[i] < u[j] }
+func (u usersByID) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+func sortUsers(users []uint32) {
+	sort.Sort(usersByID(users))
+}
+
+// Remove duplicates from a sorted list of users
+func uniqueUsers(users []uint32) []uint32 {
+	unique := users[:0]
+	for i, user := range users {
+		if i == 0 || user != users[i-1] {
+			unique = append(unique, user)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,14 @@
+package repos_test
+
+import (
+	"testing"
+
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/antoineaugusti/feature-flags/repos/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.TestStore(t, func() (repos.Store, func()) {
+		return repos.NewMemoryStore(), func() {}
+	})
+}
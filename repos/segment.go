@@ -0,0 +1,84 @@
+package repos
+
+import (
+	"encoding/json"
+	"fmt"
+
+	db "github.com/antoineaugusti/feature-flags/db"
+	m "github.com/antoineaugusti/feature-flags/models"
+)
+
+// Update a segment
+func (t boltTx) PutSegment(segment m.Segment) error {
+	segments, err := t.tx.CreateBucketIfNotExists([]byte(db.GetSegmentsBucketName()))
+	if err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(segment)
+	if err != nil {
+		return err
+	}
+
+	return segments.Put([]byte(segment.Key), bytes)
+}
+
+// GetSegments gets a list of segments
+func (t boltTx) GetSegments() (m.Segments, error) {
+	segments := make(m.Segments, 0)
+
+	segmentsBucket := t.tx.Bucket([]byte(db.GetSegmentsBucketName()))
+	if segmentsBucket == nil {
+		return segments, nil
+	}
+
+	cursor := segmentsBucket.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		segment := m.Segment{}
+
+		err := json.Unmarshal(value, &segment)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+// Tell if a segment exists
+func (t boltTx) SegmentExists(segmentKey string) (bool, error) {
+	segments := t.tx.Bucket([]byte(db.GetSegmentsBucketName()))
+	return segments != nil && segments.Get([]byte(segmentKey)) != nil, nil
+}
+
+// GetSegment gets a segment thanks to its key
+func (t boltTx) GetSegment(segmentKey string) (m.Segment, error) {
+	segments := t.tx.Bucket([]byte(db.GetSegmentsBucketName()))
+	if segments == nil {
+		return m.Segment{}, fmt.Errorf("Unable to find segment")
+	}
+
+	bytes := segments.Get([]byte(segmentKey))
+	if bytes == nil {
+		return m.Segment{}, fmt.Errorf("Unable to find segment")
+	}
+
+	segment := m.Segment{}
+
+	err := json.Unmarshal(bytes, &segment)
+	if err != nil {
+		return m.Segment{}, err
+	}
+
+	return segment, nil
+}
+
+// Delete a segment thanks to its key
+func (t boltTx) RemoveSegment(segmentKey string) error {
+	segments := t.tx.Bucket([]byte(db.GetSegmentsBucketName()))
+	if segments == nil {
+		return nil
+	}
+	return segments.Delete([]byte(segmentKey))
+}
@@ -0,0 +1,556 @@
+package repos
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+)
+
+// SQL drivers supported by the SQL store
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// How many times a transaction is run when it cannot be serialized
+const maxAttempts = 5
+
+// The SQLSTATE of PostgreSQL transactions which cannot be serialized
+const serializationFailure = "40001"
+
+// Migrations creating the schema of the SQL store. The version of a
+// migration is its index plus one. Migrations must never be modified
+// once released, changes to the schema are made by new migrations
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS features (
+		feature_key VARCHAR(50) PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS feature_users (
+		feature_key VARCHAR(50) NOT NULL,
+		user_id BIGINT NOT NULL,
+		PRIMARY KEY (feature_key, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS segments (
+		segment_key VARCHAR(50) PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS feature_history (
+		feature_key VARCHAR(50) NOT NULL,
+		revision BIGINT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (feature_key, revision)
+	)`,
+}
+
+// A store backed by a SQL database. Several servers can share it
+type sqlStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// A transaction of a SQL database
+type sqlTx struct {
+	tx       *sql.Tx
+	driver   string
+	writable bool
+}
+
+// NewSQLStore creates a store backed by a SQL database opened with
+// the sqlite3 or the postgres driver. The schema is migrated to the
+// latest version
+func NewSQLStore(db *sql.DB, driver string) (Store, error) {
+	if driver != DriverSQLite && driver != DriverPostgres {
+		return nil, fmt.Errorf("Unsupported SQL driver %s", driver)
+	}
+
+	// SQLite supports a single writer at a time
+	if driver == DriverSQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	store := &sqlStore{db, driver}
+	if err := store.migrate(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *sqlStore) View(fn func(Tx) error) error {
+	return s.run(false, fn)
+}
+
+func (s *sqlStore) Update(fn func(Tx) error) error {
+	return s.run(true, fn)
+}
+
+// Run a function in a transaction, committed if the function succeeds
+// and the transaction is writable. Transactions which PostgreSQL cannot
+// serialize with concurrent transactions are run again
+func (s *sqlStore) run(writable bool, fn func(Tx) error) (err error) {
+	for attempt := 1; ; attempt++ {
+		err = s.runOnce(writable, fn)
+		if attempt == maxAttempts || !isSerializationFailure(err) {
+			return err
+		}
+
+		// Concurrent transactions should not conflict again
+		time.Sleep(time.Duration(attempt*10+rand.Intn(10)) * time.Millisecond)
+	}
+}
+
+// Tell if a transaction failed because of concurrent transactions
+func isSerializationFailure(err error) bool {
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && state.SQLState() == serializationFailure
+}
+
+func (s *sqlStore) runOnce(writable bool, fn func(Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if s.driver == DriverPostgres {
+		// Prevent other servers from changing what a transaction read
+		if _, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := fn(sqlTx{tx, s.driver, writable}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !writable {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+// Apply migrations which have not been applied yet
+func (s *sqlStore) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		err := s.Update(func(tx Tx) error {
+			t := tx.(sqlTx)
+			if _, err := t.exec(migrations[i]); err != nil {
+				return err
+			}
+
+			// Another server may have applied the migration concurrently
+			_, err := t.exec(`INSERT INTO schema_migrations (version) VALUES (?) ON CONFLICT DO NOTHING`, i+1)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to apply migration %d: %s", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (t sqlTx) GetFeature(featureKey string) (m.FeatureFlag, error) {
+	var value string
+	err := t.queryRow(`SELECT value FROM features WHERE feature_key = ?`, featureKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return m.FeatureFlag{}, fmt.Errorf("Unable to find feature")
+	}
+	if err != nil {
+		return m.FeatureFlag{}, err
+	}
+
+	feature, err := decodeSQLFeature(value)
+	if err != nil {
+		return m.FeatureFlag{}, err
+	}
+
+	if feature.Users, err = t.getUsers(featureKey); err != nil {
+		return m.FeatureFlag{}, err
+	}
+	feature.IndexUsers()
+
+	return feature, nil
+}
+
+func (t sqlTx) GetFeatures() (m.FeatureFlags, error) {
+	features := make(m.FeatureFlags, 0)
+
+	rows, err := t.query(`SELECT value FROM features ORDER BY feature_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	positions := make(map[string]int)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		feature, err := decodeSQLFeature(value)
+		if err != nil {
+			return nil, err
+		}
+		positions[feature.Key] = len(features)
+		features = append(features, feature)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Load the users of every feature in a single query
+	users, err := t.query(`SELECT feature_key, user_id FROM feature_users ORDER BY feature_key, user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer users.Close()
+
+	for users.Next() {
+		var featureKey string
+		var user uint32
+		if err := users.Scan(&featureKey, &user); err != nil {
+			return nil, err
+		}
+
+		if i, ok := positions[featureKey]; ok {
+			features[i].Users = append(features[i].Users, user)
+		}
+	}
+
+	for i := range features {
+		features[i].IndexUsers()
+	}
+
+	return features, users.Err()
+}
+
+func (t sqlTx) FeatureExists(featureKey string) (bool, error) {
+	return t.exists(`SELECT 1 FROM features WHERE feature_key = ?`, featureKey)
+}
+
+func (t sqlTx) PutFeature(feature m.FeatureFlag) error {
+	bytes, err := json.Marshal(storedFeature{FeatureFlag: feature})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.exec(`INSERT INTO features (feature_key, value) VALUES (?, ?)
+		ON CONFLICT (feature_key) DO UPDATE SET value = excluded.value`, feature.Key, string(bytes))
+	if err != nil {
+		return err
+	}
+
+	return t.putUsers(feature.Key, feature.Users)
+}
+
+func (t sqlTx) RemoveFeature(featureKey string) error {
+	if _, err := t.exec(`DELETE FROM features WHERE feature_key = ?`, featureKey); err != nil {
+		return err
+	}
+
+	_, err := t.exec(`DELETE FROM feature_users WHERE feature_key = ?`, featureKey)
+	return err
+}
+
+func (t sqlTx) AddUser(featureKey string, user uint32) error {
+	exists, err := t.FeatureExists(featureKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Unable to find feature")
+	}
+
+	return t.insertUser(featureKey, user)
+}
+
+func (t sqlTx) RemoveUser(featureKey string, user uint32) error {
+	exists, err := t.FeatureExists(featureKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Unable to find feature")
+	}
+
+	_, err = t.exec(`DELETE FROM feature_users WHERE feature_key = ? AND user_id = ?`, featureKey, user)
+	return err
+}
+
+func (t sqlTx) GetSegment(segmentKey string) (m.Segment, error) {
+	var value string
+	err := t.queryRow(`SELECT value FROM segments WHERE segment_key = ?`, segmentKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return m.Segment{}, fmt.Errorf("Unable to find segment")
+	}
+	if err != nil {
+		return m.Segment{}, err
+	}
+
+	segment := m.Segment{}
+	err = json.Unmarshal([]byte(value), &segment)
+	return segment, err
+}
+
+func (t sqlTx) GetSegments() (m.Segments, error) {
+	segments := make(m.Segments, 0)
+
+	rows, err := t.query(`SELECT value FROM segments ORDER BY segment_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		segment := m.Segment{}
+		if err := json.Unmarshal([]byte(value), &segment); err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, rows.Err()
+}
+
+func (t sqlTx) SegmentExists(segmentKey string) (bool, error) {
+	return t.exists(`SELECT 1 FROM segments WHERE segment_key = ?`, segmentKey)
+}
+
+func (t sqlTx) PutSegment(segment m.Segment) error {
+	bytes, err := json.Marshal(segment)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.exec(`INSERT INTO segments (segment_key, value) VALUES (?, ?)
+		ON CONFLICT (segment_key) DO UPDATE SET value = excluded.value`, segment.Key, string(bytes))
+	return err
+}
+
+func (t sqlTx) RemoveSegment(segmentKey string) error {
+	_, err := t.exec(`DELETE FROM segments WHERE segment_key = ?`, segmentKey)
+	return err
+}
+
+func (t sqlTx) AddChange(change m.FeatureChange) error {
+	var next uint64
+	err := t.queryRow(`SELECT COALESCE(MAX(revision), 0) + 1 FROM feature_history WHERE feature_key = ?`,
+		change.Feature.Key).Scan(&next)
+	if err != nil {
+		return err
+	}
+	if change.Revision < next {
+		change.Revision = next
+	}
+
+	bytes, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.exec(`INSERT INTO feature_history (feature_key, revision, value) VALUES (?, ?, ?)`,
+		change.Feature.Key, change.Revision, string(bytes))
+	if err != nil || change.Revision <= maxChanges {
+		return err
+	}
+
+	_, err = t.exec(`DELETE FROM feature_history WHERE feature_key = ? AND revision <= ?`,
+		change.Feature.Key, change.Revision-maxChanges)
+	return err
+}
+
+func (t sqlTx) GetChanges(featureKey string) ([]m.FeatureChange, error) {
+	history := make([]m.FeatureChange, 0)
+
+	rows, err := t.query(`SELECT value FROM feature_history WHERE feature_key = ? ORDER BY revision DESC`, featureKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		change := m.FeatureChange{}
+		if err := json.Unmarshal([]byte(value), &change); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+func (t sqlTx) GetChangedFeatures() ([]string, error) {
+	keys := make([]string, 0)
+
+	rows, err := t.query(`SELECT DISTINCT feature_key FROM feature_history ORDER BY feature_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (t sqlTx) RemoveChanges(featureKey string) error {
+	_, err := t.exec(`DELETE FROM feature_history WHERE feature_key = ?`, featureKey)
+	return err
+}
+
+// Get the allowed users of a feature flag, sorted by ID
+func (t sqlTx) getUsers(featureKey string) ([]uint32, error) {
+	users := make([]uint32, 0)
+
+	rows, err := t.query(`SELECT user_id FROM feature_users WHERE feature_key = ? ORDER BY user_id`, featureKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user uint32
+		if err := rows.Scan(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// Replace the allowed users of a feature flag. Only the users which
+// were added or removed are written
+func (t sqlTx) putUsers(featureKey string, users []uint32) error {
+	existing, err := t.getUsers(featureKey)
+	if err != nil {
+		return err
+	}
+
+	added := make(map[uint32]bool, len(users))
+	for _, user := range users {
+		added[user] = true
+	}
+
+	var removed []uint32
+	for _, user := range existing {
+		if added[user] {
+			delete(added, user)
+		} else {
+			removed = append(removed, user)
+		}
+	}
+
+	for _, user := range removed {
+		if _, err := t.exec(`DELETE FROM feature_users WHERE feature_key = ? AND user_id = ?`, featureKey, user); err != nil {
+			return err
+		}
+	}
+	for user := range added {
+		if err := t.insertUser(featureKey, user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Give access to a feature flag to a user, unless the user already has it
+func (t sqlTx) insertUser(featureKey string, user uint32) error {
+	_, err := t.exec(`INSERT INTO feature_users (feature_key, user_id) VALUES (?, ?)
+		ON CONFLICT DO NOTHING`, featureKey, user)
+	return err
+}
+
+// Tell if a query selects a row
+func (t sqlTx) exists(query string, args ...interface{}) (bool, error) {
+	var exists int
+	err := t.queryRow(query, args...).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t sqlTx) exec(query string, args ...interface{}) (sql.Result, error) {
+	if !t.writable {
+		return nil, fmt.Errorf("tx not writable")
+	}
+	return t.tx.Exec(t.rebind(query), args...)
+}
+
+func (t sqlTx) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.Query(t.rebind(query), args...)
+}
+
+func (t sqlTx) queryRow(query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRow(t.rebind(query), args...)
+}
+
+// Queries are written with ? placeholders, PostgreSQL expects $1, $2...
+func (t sqlTx) rebind(query string) string {
+	if t.driver != DriverPostgres {
+		return query
+	}
+
+	parts := strings.Split(query, "?")
+	rebound := parts[0]
+	for i, part := range parts[1:] {
+		rebound += "$" + strconv.Itoa(i+1) + part
+	}
+	return rebound
+}
+
+// Decode a feature flag stored in the features table
+func decodeSQLFeature(value string) (m.FeatureFlag, error) {
+	stored := storedFeature{}
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return m.FeatureFlag{}, err
+	}
+
+	feature := stored.FeatureFlag
+	feature.Users = make([]uint32, 0)
+	if feature.Segments == nil {
+		feature.Segments = []string{}
+	}
+
+	return feature, nil
+}
@@ -0,0 +1,140 @@
+package repos_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/antoineaugusti/feature-flags/repos/storetest"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLStore(t *testing.T) {
+	storetest.TestStore(t, func() (repos.Store, func()) {
+		database := getTestSQLDB()
+
+		store, err := repos.NewSQLStore(database, repos.DriverSQLite)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return store, func() { closeSQLDB(database) }
+	})
+}
+
+func TestSQLMigrations(t *testing.T) {
+	database := getTestSQLDB()
+	defer closeSQLDB(database)
+
+	// Migrations are applied once
+	_, err := repos.NewSQLStore(database, repos.DriverSQLite)
+	assert.Nil(t, err)
+	_, err = repos.NewSQLStore(database, repos.DriverSQLite)
+	assert.Nil(t, err)
+
+	var count int
+	database.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count)
+	assert.Equal(t, 4, count)
+
+	// Unknown drivers are refused
+	_, err = repos.NewSQLStore(database, "mysql")
+	assert.Equal(t, "Unsupported SQL driver mysql", err.Error())
+}
+
+// An error of PostgreSQL
+type stateError string
+
+func (e stateError) Error() string    { return "SQLSTATE " + string(e) }
+func (e stateError) SQLState() string { return string(e) }
+
+func TestSQLRetry(t *testing.T) {
+	database := getTestSQLDB()
+	defer closeSQLDB(database)
+	store, _ := repos.NewSQLStore(database, repos.DriverSQLite)
+
+	// Transactions which cannot be serialized are run again
+	calls := 0
+	err := store.Update(func(tx repos.Tx) error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("Unable to commit: %w", stateError("40001"))
+		}
+		return tx.PutFeature(m.FeatureFlag{Key: "foo"})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	// A few times at most
+	calls = 0
+	err = store.Update(func(tx repos.Tx) error {
+		calls++
+		return stateError("40001")
+	})
+	assert.Equal(t, stateError("40001"), err)
+	assert.Equal(t, 5, calls)
+
+	// Other errors are returned at once
+	calls = 0
+	err = store.Update(func(tx repos.Tx) error {
+		calls++
+		return stateError("23505")
+	})
+	assert.Equal(t, stateError("23505"), err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestSQLPutFeatureUsers(t *testing.T) {
+	database := getTestSQLDB()
+	defer closeSQLDB(database)
+	store, _ := repos.NewSQLStore(database, repos.DriverSQLite)
+
+	put := func(users ...uint32) map[uint32]int64 {
+		err := store.Update(func(tx repos.Tx) error {
+			return tx.PutFeature(m.FeatureFlag{Key: "foo", Users: users})
+		})
+		assert.Nil(t, err)
+
+		rows, _ := database.Query(`SELECT rowid, user_id FROM feature_users`)
+		defer rows.Close()
+		rowids := make(map[uint32]int64)
+		for rows.Next() {
+			var rowid int64
+			var user uint32
+			rows.Scan(&rowid, &user)
+			rowids[user] = rowid
+		}
+		return rowids
+	}
+
+	before := put(1, 2)
+	after := put(2, 3)
+	assert.Equal(t, 2, len(after))
+
+	// Users which were kept are not written again
+	assert.Equal(t, before[2], after[2])
+	_, ok := after[1]
+	assert.False(t, ok)
+}
+
+func getTestSQLDB() *sql.DB {
+	database, err := sql.Open("sqlite3", getSQLDBPath())
+	if err != nil {
+		panic(err)
+	}
+	return database
+}
+
+func getSQLDBPath() string {
+	return "/tmp/repos_test.sqlite"
+}
+
+func closeSQLDB(database *sql.DB) {
+	database.Close()
+	if err := os.Remove(getSQLDBPath()); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,65 @@
+package repos
+
+import (
+	m "github.com/antoineaugusti/feature-flags/models"
+)
+
+// How many changes of a feature flag are kept in its history
+const maxChanges = 1000
+
+// Store persists feature flags and segments
+type Store interface {
+	// View runs a read-only transaction
+	View(fn func(Tx) error) error
+	// Update runs a read-write transaction. Changes are
+	// committed if fn returns nil, discarded otherwise. Stores
+	// may run fn again when the transaction conflicts with
+	// another one: fn must not keep state between calls
+	Update(fn func(Tx) error) error
+}
+
+// Tx reads and writes feature flags and segments within a transaction
+type Tx interface {
+	// GetFeature gets a feature flag thanks to its key.
+	// Its users are sorted by ID
+	GetFeature(featureKey string) (m.FeatureFlag, error)
+	// GetFeatures gets every feature flag, sorted by key
+	GetFeatures() (m.FeatureFlags, error)
+	// FeatureExists tells if a feature flag exists
+	FeatureExists(featureKey string) (bool, error)
+	// PutFeature creates or replaces a feature flag and its users
+	PutFeature(feature m.FeatureFlag) error
+	// RemoveFeature deletes a feature flag and its users
+	RemoveFeature(featureKey string) error
+	// AddUser gives access to a feature flag to a user
+	// without rewriting the other users
+	AddUser(featureKey string, user uint32) error
+	// RemoveUser removes a user from the allowed users of a
+	// feature flag without rewriting the other users
+	RemoveUser(featureKey string, user uint32) error
+
+	// GetSegment gets a segment thanks to its key
+	GetSegment(segmentKey string) (m.Segment, error)
+	// GetSegments gets every segment, sorted by key
+	GetSegments() (m.Segments, error)
+	// SegmentExists tells if a segment exists
+	SegmentExists(segmentKey string) (bool, error)
+	// PutSegment creates or replaces a segment
+	PutSegment(segment m.Segment) error
+	// RemoveSegment deletes a segment
+	RemoveSegment(segmentKey string) error
+
+	// AddChange records a change in the history of its feature
+	// flag, with the revision following the previous change unless
+	// it already has a later one, as when a history is restored.
+	// Only the latest maxChanges changes of a feature flag are kept
+	AddChange(change m.FeatureChange) error
+	// GetChanges gets the history of a feature flag, newest first.
+	// It is kept once the feature flag is removed
+	GetChanges(featureKey string) ([]m.FeatureChange, error)
+	// GetChangedFeatures gets the keys of the feature flags having
+	// a history, removed ones included, sorted
+	GetChangedFeatures() ([]string, error)
+	// RemoveChanges deletes the history of a feature flag
+	RemoveChanges(featureKey string) error
+}
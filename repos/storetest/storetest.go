@@ -0,0 +1,333 @@
+// Package storetest checks that implementations of repos.Store behave
+// like the bolt store. Every store must pass TestStore
+package storetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewStore creates an empty store and a function releasing it
+type NewStore func() (repos.Store, func())
+
+// TestStore runs the conformance tests against stores created by newStore
+func TestStore(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name string
+		test func(*testing.T, repos.Store)
+	}{
+		{"Features", testFeatures},
+		{"Users", testUsers},
+		{"Segments", testSegments},
+		{"Rollback", testRollback},
+		{"ReadOnly", testReadOnly},
+		{"Isolation", testIsolation},
+		{"History", testHistory},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, release := newStore()
+			defer release()
+
+			test.test(t, store)
+		})
+	}
+}
+
+func testFeatures(t *testing.T, store repos.Store) {
+	// No features
+	features := getFeatures(t, store)
+	assert.Equal(t, 0, len(features))
+
+	update(t, store, func(tx repos.Tx) error {
+		if err := tx.PutFeature(getDummyFeature("foo")); err != nil {
+			return err
+		}
+		return tx.PutFeature(getDummyFeature("bar"))
+	})
+
+	// Features are sorted by key
+	features = getFeatures(t, store)
+	assert.Equal(t, 2, len(features))
+	assert.Equal(t, "bar", features[0].Key)
+	assert.Equal(t, "foo", features[1].Key)
+
+	_ = store.View(func(tx repos.Tx) error {
+		assert.True(t, exists(tx.FeatureExists("foo")))
+		assert.False(t, exists(tx.FeatureExists("baz")))
+
+		feature, err := tx.GetFeature("foo")
+		assert.Nil(t, err)
+		assert.Equal(t, "foo", feature.Key)
+		assert.Equal(t, uint32(42), feature.Percentage)
+		assert.Equal(t, []string{"dev"}, feature.Groups)
+		assert.Equal(t, []string{"beta_testers"}, feature.Segments)
+
+		_, err = tx.GetFeature("baz")
+		assert.Equal(t, "Unable to find feature", err.Error())
+		return nil
+	})
+
+	// Replace and remove a feature
+	update(t, store, func(tx repos.Tx) error {
+		feature := getDummyFeature("foo")
+		feature.Enabled = true
+		if err := tx.PutFeature(feature); err != nil {
+			return err
+		}
+		return tx.RemoveFeature("bar")
+	})
+
+	features = getFeatures(t, store)
+	assert.Equal(t, 1, len(features))
+	assert.True(t, features[0].Enabled)
+}
+
+func testUsers(t *testing.T, store repos.Store) {
+	feature := getDummyFeature("foo")
+	feature.Users = []uint32{1337, 22, 42, 22}
+	update(t, store, func(tx repos.Tx) error {
+		return tx.PutFeature(feature)
+	})
+
+	// Users are sorted and unique
+	assert.Equal(t, []uint32{22, 42, 1337}, getFeature(t, store, "foo").Users)
+	assert.True(t, getFeature(t, store, "foo").UserHasAccess(1337))
+
+	update(t, store, func(tx repos.Tx) error {
+		if err := tx.AddUser("foo", 1); err != nil {
+			return err
+		}
+		if err := tx.AddUser("foo", 42); err != nil {
+			return err
+		}
+		return tx.RemoveUser("foo", 22)
+	})
+	assert.Equal(t, []uint32{1, 42, 1337}, getFeature(t, store, "foo").Users)
+
+	// Users of an unexisting feature
+	err := store.Update(func(tx repos.Tx) error {
+		return tx.AddUser("bar", 1)
+	})
+	assert.Equal(t, "Unable to find feature", err.Error())
+
+	// Users are removed with the feature
+	update(t, store, func(tx repos.Tx) error {
+		if err := tx.RemoveFeature("foo"); err != nil {
+			return err
+		}
+		feature.Users = nil
+		return tx.PutFeature(feature)
+	})
+	assert.Equal(t, []uint32{}, getFeature(t, store, "foo").Users)
+}
+
+func testSegments(t *testing.T, store repos.Store) {
+	segment := m.Segment{
+		Key:    "beta_testers",
+		Users:  []uint32{42},
+		Groups: []string{"beta"},
+		Rules:  []m.Rule{{Attribute: "country", Operator: m.OperatorIn, Values: []string{"fr"}}},
+	}
+
+	_ = store.View(func(tx repos.Tx) error {
+		segments, err := tx.GetSegments()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(segments))
+
+		_, err = tx.GetSegment("beta_testers")
+		assert.Equal(t, "Unable to find segment", err.Error())
+		assert.False(t, exists(tx.SegmentExists("beta_testers")))
+		return nil
+	})
+
+	update(t, store, func(tx repos.Tx) error {
+		other := segment
+		other.Key = "alpha_testers"
+		if err := tx.PutSegment(other); err != nil {
+			return err
+		}
+		return tx.PutSegment(segment)
+	})
+
+	_ = store.View(func(tx repos.Tx) error {
+		segments, err := tx.GetSegments()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(segments))
+		assert.Equal(t, "alpha_testers", segments[0].Key)
+
+		stored, err := tx.GetSegment("beta_testers")
+		assert.Nil(t, err)
+		assert.Equal(t, segment, stored)
+		assert.True(t, exists(tx.SegmentExists("beta_testers")))
+		return nil
+	})
+
+	update(t, store, func(tx repos.Tx) error {
+		return tx.RemoveSegment("beta_testers")
+	})
+
+	_ = store.View(func(tx repos.Tx) error {
+		assert.False(t, exists(tx.SegmentExists("beta_testers")))
+		return nil
+	})
+}
+
+func testRollback(t *testing.T, store repos.Store) {
+	err := store.Update(func(tx repos.Tx) error {
+		if err := tx.PutFeature(getDummyFeature("foo")); err != nil {
+			return err
+		}
+		return fmt.Errorf("Something went wrong")
+	})
+	assert.Equal(t, "Something went wrong", err.Error())
+
+	// Nothing was written
+	assert.Equal(t, 0, len(getFeatures(t, store)))
+}
+
+func testReadOnly(t *testing.T, store repos.Store) {
+	err := store.View(func(tx repos.Tx) error {
+		return tx.PutFeature(getDummyFeature("foo"))
+	})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 0, len(getFeatures(t, store)))
+}
+
+func testIsolation(t *testing.T, store repos.Store) {
+	feature := getDummyFeature("foo")
+	update(t, store, func(tx repos.Tx) error {
+		return tx.PutFeature(feature)
+	})
+
+	// Modifying given or returned values does not modify the store
+	feature.Groups[0] = "admin"
+	stored := getFeature(t, store, "foo")
+	stored.Users[0] = 1
+	stored.Segments[0] = "other"
+
+	stored = getFeature(t, store, "foo")
+	assert.Equal(t, []string{"dev"}, stored.Groups)
+	assert.Equal(t, []uint32{22}, stored.Users)
+	assert.Equal(t, []string{"beta_testers"}, stored.Segments)
+}
+
+func testHistory(t *testing.T, store repos.Store) {
+	change := func(featureKey, action string) m.FeatureChange {
+		return m.FeatureChange{Time: time.Now(), Action: action, Feature: getDummyFeature(featureKey)}
+	}
+
+	update(t, store, func(tx repos.Tx) error {
+		for _, c := range []m.FeatureChange{
+			change("foo", m.ActionCreated),
+			change("bar", m.ActionCreated),
+			change("foo", m.ActionUpdated),
+			change("foo", m.ActionRemoved),
+		} {
+			if err := tx.AddChange(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	_ = store.View(func(tx repos.Tx) error {
+		history, err := tx.GetChanges("foo")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(history))
+		assert.Equal(t, uint64(3), history[0].Revision)
+		assert.Equal(t, m.ActionRemoved, history[0].Action)
+		assert.Equal(t, uint64(1), history[2].Revision)
+		assert.Equal(t, m.ActionCreated, history[2].Action)
+		assert.Equal(t, []uint32{22}, history[2].Feature.Users)
+
+		history, err = tx.GetChanges("unknown")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(history))
+
+		keys, err := tx.GetChangedFeatures()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"bar", "foo"}, keys)
+		return nil
+	})
+
+	// Restored revisions are kept, the oldest changes are forgotten
+	update(t, store, func(tx repos.Tx) error {
+		restored := change("foo", m.ActionUpdated)
+		restored.Revision = 1003
+		if err := tx.AddChange(restored); err != nil {
+			return err
+		}
+		if err := tx.AddChange(change("foo", m.ActionUpdated)); err != nil {
+			return err
+		}
+		return tx.RemoveChanges("bar")
+	})
+
+	_ = store.View(func(tx repos.Tx) error {
+		history, err := tx.GetChanges("foo")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(history))
+		assert.Equal(t, uint64(1004), history[0].Revision)
+		assert.Equal(t, uint64(1003), history[1].Revision)
+
+		keys, err := tx.GetChangedFeatures()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"foo"}, keys)
+		return nil
+	})
+}
+
+func update(t *testing.T, store repos.Store, fn func(repos.Tx) error) {
+	if err := store.Update(fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getFeatures(t *testing.T, store repos.Store) (features m.FeatureFlags) {
+	err := store.View(func(tx repos.Tx) (err error) {
+		features, err = tx.GetFeatures()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func getFeature(t *testing.T, store repos.Store, featureKey string) (feature m.FeatureFlag) {
+	err := store.View(func(tx repos.Tx) (err error) {
+		feature, err = tx.GetFeature(featureKey)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// Get the result of FeatureExists or SegmentExists, which must not fail
+func exists(exists bool, err error) bool {
+	if err != nil {
+		panic(err)
+	}
+	return exists
+}
+
+func getDummyFeature(featureKey string) m.FeatureFlag {
+	return m.FeatureFlag{
+		Key:        featureKey,
+		Enabled:    false,
+		Users:      []uint32{22},
+		Groups:     []string{"dev"},
+		Percentage: 42,
+		Segments:   []string{"beta_testers"},
+	}
+}
@@ -0,0 +1,183 @@
+package repos
+
+import (
+	"context"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/antoineaugusti/feature-flags/repos")
+
+// A store creating a span for every transaction and every call
+type tracedStore struct {
+	store Store
+	ctx   context.Context
+}
+
+// A transaction creating a span for every call
+type tracedTx struct {
+	tx  Tx
+	ctx context.Context
+}
+
+// Traced wraps a store so that its transactions and their calls
+// are traced as children of the span of a context
+func Traced(ctx context.Context, store Store) Store {
+	return tracedStore{store, ctx}
+}
+
+func (s tracedStore) View(fn func(Tx) error) error {
+	ctx, span := tracer.Start(s.ctx, "repos.View")
+	defer span.End()
+
+	return endSpan(span, s.store.View(func(tx Tx) error {
+		return fn(tracedTx{tx, ctx})
+	}))
+}
+
+func (s tracedStore) Update(fn func(Tx) error) error {
+	ctx, span := tracer.Start(s.ctx, "repos.Update")
+	defer span.End()
+
+	return endSpan(span, s.store.Update(func(tx Tx) error {
+		return fn(tracedTx{tx, ctx})
+	}))
+}
+
+func (t tracedTx) GetFeature(featureKey string) (m.FeatureFlag, error) {
+	span := t.start("GetFeature", attribute.String("feature.key", featureKey))
+	defer span.End()
+
+	feature, err := t.tx.GetFeature(featureKey)
+	return feature, endSpan(span, err)
+}
+
+func (t tracedTx) GetFeatures() (m.FeatureFlags, error) {
+	span := t.start("GetFeatures")
+	defer span.End()
+
+	features, err := t.tx.GetFeatures()
+	return features, endSpan(span, err)
+}
+
+func (t tracedTx) FeatureExists(featureKey string) (bool, error) {
+	span := t.start("FeatureExists", attribute.String("feature.key", featureKey))
+	defer span.End()
+
+	exists, err := t.tx.FeatureExists(featureKey)
+	return exists, endSpan(span, err)
+}
+
+func (t tracedTx) PutFeature(feature m.FeatureFlag) error {
+	span := t.start("PutFeature", attribute.String("feature.key", feature.Key))
+	defer span.End()
+
+	return endSpan(span, t.tx.PutFeature(feature))
+}
+
+func (t tracedTx) RemoveFeature(featureKey string) error {
+	span := t.start("RemoveFeature", attribute.String("feature.key", featureKey))
+	defer span.End()
+
+	return endSpan(span, t.tx.RemoveFeature(featureKey))
+}
+
+func (t tracedTx) AddUser(featureKey string, user uint32) error {
+	span := t.start("AddUser", attribute.String("feature.key", featureKey))
+	defer span.End()
+
+	return endSpan(span, t.tx.AddUser(featureKey, user))
+}
+
+func (t tracedTx) RemoveUser(featureKey string, user uint32) error {
+	span := t.start("RemoveUser", attribute.String("feature.key", featureKey))
+	defer span.End()
+
+	return endSpan(span, t.tx.RemoveUser(featureKey, user))
+}
+
+func (t tracedTx) GetSegment(segmentKey string) (m.Segment, error) {
+	span := t.start("GetSegment", attribute.String("segment.key", segmentKey))
+	defer span.End()
+
+	segment, err := t.tx.GetSegment(segmentKey)
+	return segment, endSpan(span, err)
+}
+
+func (t tracedTx) GetSegments() (m.Segments, error) {
+	span := t.start("GetSegments")
+	defer span.End()
+
+	segments, err := t.tx.GetSegments()
+	return segments, endSpan(span, err)
+}
+
+func (t tracedTx) SegmentExists(segmentKey string) (bool, error) {
+	span := t.start("SegmentExists", attribute.String("segment.key", segmentKey))
+	defer span.End()
+
+	exists, err := t.tx.SegmentExists(segmentKey)
+	return exists, endSpan(span, err)
+}
+
+func (t tracedTx) PutSegment(segment m.Segment) error {
+	span := t.start("PutSegment", attribute.String("segment.key", segment.Key))
+	defer span.End()
+
+	return endSpan(span, t.tx.PutSegment(segment))
+}
+
+func (t tracedTx) RemoveSegment(segmentKey string) error {
+	span := t.start("RemoveSegment", attribute.String("segment.key", segmentKey))
+	defer span.End()
+
+	return endSpan(span, t.tx.RemoveSegment(segmentKey))
+}
+
+func (t tracedTx) AddChange(change m.FeatureChange) error {
+	span := t.start("AddChange", attribute.String("feature.key", change.Feature.Key))
+	defer span.End()
+
+	return endSpan(span, t.tx.AddChange(change))
+}
+
+func (t tracedTx) GetChanges(featureKey string) ([]m.FeatureChange, error) {
+	span := t.start("GetChanges", attribute.String("feature.key", featureKey))
+	defer span.End()
+
+	history, err := t.tx.GetChanges(featureKey)
+	return history, endSpan(span, err)
+}
+
+func (t tracedTx) GetChangedFeatures() ([]string, error) {
+	span := t.start("GetChangedFeatures")
+	defer span.End()
+
+	keys, err := t.tx.GetChangedFeatures()
+	return keys, endSpan(span, err)
+}
+
+func (t tracedTx) RemoveChanges(featureKey string) error {
+	span := t.start("RemoveChanges", attribute.String("feature.key", featureKey))
+	defer span.End()
+
+	return endSpan(span, t.tx.RemoveChanges(featureKey))
+}
+
+func (t tracedTx) start(name string, attributes ...attribute.KeyValue) trace.Span {
+	_, span := tracer.Start(t.ctx, "repos."+name, trace.WithAttributes(attributes...))
+	return span
+}
+
+// Record the error of a span, if any
+func endSpan(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
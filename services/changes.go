@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+)
+
+// How many revisions are kept in the change log. A follower
+// lagging further behind fetches everything again
+const maxChangeEntries = 1000
+
+// Changes describes how feature flags and segments changed after
+// a revision. Followers apply them to their local copy
+type Changes struct {
+	// Identifies the change log. It changes when the server restarts,
+	// making revisions of different change logs incomparable
+	Epoch string `json:"epoch"`
+	// The revision after the changes
+	Revision uint64 `json:"revision"`
+	// When true, Features and Segments hold every feature flag and
+	// segment, and replace the local copy entirely
+	Full bool `json:"full"`
+	// Created or updated feature flags
+	Features m.FeatureFlags `json:"features"`
+	// Created or updated segments
+	Segments m.Segments `json:"segments"`
+	// Keys of deleted feature flags
+	RemovedFeatures []string `json:"removed_features"`
+	// Keys of deleted segments
+	RemovedSegments []string `json:"removed_segments"`
+}
+
+// What changed in a revision
+type changeEntry struct {
+	revision uint64
+	// Keys of feature flags and segments written in the revision
+	features []string
+	segments []string
+	// Unknown changes, everything must be fetched again
+	full bool
+}
+
+// The latest revisions, oldest first
+type changeLog struct {
+	epoch   string
+	entries []changeEntry
+	// Closed when a revision is appended
+	changed chan struct{}
+}
+
+// A transaction recording the keys of written feature flags and segments
+type recordingTx struct {
+	repos.Tx
+	entry *changeEntry
+	// Written feature flags before their first write and after their
+	// last one, nil when they do not exist, see recordHistory. They
+	// are kept to avoid reading feature flags again
+	previous map[string]*m.FeatureFlag
+	current  map[string]*m.FeatureFlag
+}
+
+func newChangeLog() changeLog {
+	return changeLog{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		changed: make(chan struct{}),
+	}
+}
+
+// Changes gets how feature flags and segments changed after a revision
+// of a change log. Everything is returned when the change log is not the
+// current one or when the revision is too old
+func (interactor *FeatureService) Changes(ctx context.Context, epoch string, revision uint64) (Changes, error) {
+	_, span := interactor.startSpan(ctx, "Changes")
+	defer span.End()
+
+	if interactor.cache == nil {
+		return Changes{}, fmt.Errorf("Changes are only recorded by services created by NewFeatureService")
+	}
+
+	interactor.cache.lock.Lock()
+	defer interactor.cache.lock.Unlock()
+
+	log := interactor.cache.log
+	snapshot := interactor.cache.value.Load().(*Snapshot)
+	changes := Changes{
+		Epoch:           log.epoch,
+		Revision:        snapshot.Revision,
+		Features:        make(m.FeatureFlags, 0),
+		Segments:        make(m.Segments, 0),
+		RemovedFeatures: make([]string, 0),
+		RemovedSegments: make([]string, 0),
+	}
+
+	entries, ok := log.since(epoch, revision)
+	if !ok {
+		changes.Full = true
+		changes.Features = snapshot.Features
+		for _, segment := range snapshot.Segments {
+			changes.Segments = append(changes.Segments, segment)
+		}
+		return changes, nil
+	}
+
+	features, segments := make(map[string]bool), make(map[string]bool)
+	for _, entry := range entries {
+		for _, key := range entry.features {
+			features[key] = true
+		}
+		for _, key := range entry.segments {
+			segments[key] = true
+		}
+	}
+
+	for key := range features {
+		if feature, ok := snapshot.Feature(key); ok {
+			changes.Features = append(changes.Features, feature)
+		} else {
+			changes.RemovedFeatures = append(changes.RemovedFeatures, key)
+		}
+	}
+	for key := range segments {
+		if segment, ok := snapshot.Segments[key]; ok {
+			changes.Segments = append(changes.Segments, segment)
+		} else {
+			changes.RemovedSegments = append(changes.RemovedSegments, key)
+		}
+	}
+
+	return changes, nil
+}
+
+// WaitForChanges blocks until there are changes after a revision of a
+// change log, the timeout expires or the context is done
+func (interactor *FeatureService) WaitForChanges(ctx context.Context, epoch string, revision uint64, timeout time.Duration) {
+	if interactor.cache == nil {
+		return
+	}
+
+	interactor.cache.lock.Lock()
+	log := interactor.cache.log
+	current := interactor.cache.value.Load().(*Snapshot).Revision
+	interactor.cache.lock.Unlock()
+
+	if epoch != log.epoch || revision != current {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-log.changed:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// ApplyChanges applies changes fetched from another server
+func (interactor *FeatureService) ApplyChanges(ctx context.Context, changes Changes) error {
+	ctx, span := interactor.startSpan(ctx, "ApplyChanges")
+	defer span.End()
+
+	return interactor.update(ctx, func(tx repos.Tx) error {
+
+		if changes.Full {
+			if err := removeEverything(tx); err != nil {
+				return err
+			}
+		}
+
+		for _, key := range changes.RemovedFeatures {
+			if err := tx.RemoveFeature(key); err != nil {
+				return err
+			}
+		}
+		for _, key := range changes.RemovedSegments {
+			if err := tx.RemoveSegment(key); err != nil {
+				return err
+			}
+		}
+		for _, feature := range changes.Features {
+			if err := tx.PutFeature(feature); err != nil {
+				return err
+			}
+		}
+		for _, segment := range changes.Segments {
+			if err := tx.PutSegment(segment); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete every feature flag and segment
+func removeEverything(tx repos.Tx) error {
+	features, err := tx.GetFeatures()
+	if err != nil {
+		return err
+	}
+	for _, feature := range features {
+		if err := tx.RemoveFeature(feature.Key); err != nil {
+			return err
+		}
+	}
+
+	segments, err := tx.GetSegments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err := tx.RemoveSegment(segment.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Append a revision and wake up waiting followers
+func (log *changeLog) append(entry changeEntry) {
+	log.entries = append(log.entries, entry)
+	if len(log.entries) > maxChangeEntries {
+		log.entries = log.entries[len(log.entries)-maxChangeEntries:]
+	}
+
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+// Get the revisions after a revision. It fails when the
+// revisions are not all in the log or when one of them
+// has unknown changes
+func (log changeLog) since(epoch string, revision uint64) ([]changeEntry, bool) {
+	if epoch != log.epoch {
+		return nil, false
+	}
+
+	// Nothing changed since the service started
+	if len(log.entries) == 0 {
+		return nil, revision == 0
+	}
+
+	last := log.entries[len(log.entries)-1].revision
+	first := log.entries[0].revision
+	if revision > last || revision+1 < first {
+		return nil, false
+	}
+
+	entries := log.entries[len(log.entries)-int(last-revision):]
+	for _, entry := range entries {
+		if entry.full {
+			return nil, false
+		}
+	}
+	return entries, true
+}
+
+func (e *changeEntry) isEmpty() bool {
+	return !e.full && len(e.features) == 0 && len(e.segments) == 0
+}
+
+func (t recordingTx) GetFeature(featureKey string) (m.FeatureFlag, error) {
+	feature, err := t.Tx.GetFeature(featureKey)
+	if _, ok := t.previous[featureKey]; !ok {
+		switch {
+		case err == nil:
+			t.previous[featureKey] = withoutIndex(feature)
+		case err.Error() == "Unable to find feature":
+			t.previous[featureKey] = nil
+		}
+	}
+	return feature, err
+}
+
+func (t recordingTx) PutFeature(feature m.FeatureFlag) error {
+	if err := t.remember(feature.Key); err != nil {
+		return err
+	}
+	if err := t.Tx.PutFeature(feature); err != nil {
+		return err
+	}
+
+	t.current[feature.Key] = withoutIndex(feature)
+	return nil
+}
+
+func (t recordingTx) RemoveFeature(featureKey string) error {
+	if err := t.remember(featureKey); err != nil {
+		return err
+	}
+	if err := t.Tx.RemoveFeature(featureKey); err != nil {
+		return err
+	}
+
+	t.current[featureKey] = nil
+	return nil
+}
+
+func (t recordingTx) AddUser(featureKey string, user uint32) error {
+	if err := t.remember(featureKey); err != nil {
+		return err
+	}
+	if err := t.Tx.AddUser(featureKey, user); err != nil {
+		return err
+	}
+
+	if feature := t.current[featureKey]; feature != nil {
+		feature.AddUser(user)
+	}
+	return nil
+}
+
+func (t recordingTx) RemoveUser(featureKey string, user uint32) error {
+	if err := t.remember(featureKey); err != nil {
+		return err
+	}
+	if err := t.Tx.RemoveUser(featureKey, user); err != nil {
+		return err
+	}
+
+	if feature := t.current[featureKey]; feature != nil {
+		feature.RemoveUser(user)
+	}
+	return nil
+}
+
+func (t recordingTx) PutSegment(segment m.Segment) error {
+	t.entry.segments = append(t.entry.segments, segment.Key)
+	return t.Tx.PutSegment(segment)
+}
+
+func (t recordingTx) RemoveSegment(segmentKey string) error {
+	t.entry.segments = append(t.entry.segments, segmentKey)
+	return t.Tx.RemoveSegment(segmentKey)
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	service, _ := NewFeatureService(repos.NewMemoryStore())
+
+	// Everything is returned for an unknown change log
+	changes, err := service.Changes(context.Background(), "", 0)
+	assert.Nil(t, err)
+	assert.True(t, changes.Full)
+	assert.Equal(t, uint64(0), changes.Revision)
+
+	epoch := changes.Epoch
+
+	_ = service.AddFeature(context.Background(), getDummyFeature())
+	_ = service.AddSegment(context.Background(), getDummySegment())
+	_, _ = service.AddUser(context.Background(), "foo", 1)
+
+	// A failed write is not a change
+	_ = service.AddFeature(context.Background(), getDummyFeature())
+
+	changes, _ = service.Changes(context.Background(), epoch, 0)
+	assert.False(t, changes.Full)
+	assert.Equal(t, uint64(3), changes.Revision)
+	assert.Equal(t, 1, len(changes.Features))
+	assert.Equal(t, []uint32{1, 22}, changes.Features[0].Users)
+	assert.Equal(t, 1, len(changes.Segments))
+
+	// Removed features
+	_ = service.RemoveFeature(context.Background(), "foo")
+
+	changes, _ = service.Changes(context.Background(), epoch, 3)
+	assert.Equal(t, 0, len(changes.Features))
+	assert.Equal(t, []string{"foo"}, changes.RemovedFeatures)
+
+	// Nothing changed
+	changes, _ = service.Changes(context.Background(), epoch, 4)
+	assert.False(t, changes.Full)
+	assert.Equal(t, 0, len(changes.Features)+len(changes.RemovedFeatures))
+
+	// A revision from the future
+	changes, _ = service.Changes(context.Background(), epoch, 5)
+	assert.True(t, changes.Full)
+
+	// Changes made by other servers sharing the store
+	_ = service.Store.Update(func(tx repos.Tx) error {
+		return tx.PutFeature(m.FeatureFlag{Key: "bar"})
+	})
+	assert.Nil(t, service.Refresh(context.Background()))
+
+	changes, _ = service.Changes(context.Background(), epoch, 4)
+	assert.True(t, changes.Full)
+	assert.Equal(t, uint64(5), changes.Revision)
+	assert.Equal(t, "bar", changes.Features[0].Key)
+}
+
+func TestChangeLogIsBounded(t *testing.T) {
+	service, _ := NewFeatureService(repos.NewMemoryStore())
+	changes, _ := service.Changes(context.Background(), "", 0)
+
+	_ = service.AddFeature(context.Background(), getDummyFeature())
+	for i := 0; i < maxChangeEntries; i++ {
+		_, _ = service.AddUser(context.Background(), "foo", uint32(i))
+	}
+
+	// The first revision was dropped
+	assert.True(t, getChanges(service, changes.Epoch, 0).Full)
+	assert.False(t, getChanges(service, changes.Epoch, 1).Full)
+}
+
+func getChanges(service *FeatureService, epoch string, revision uint64) Changes {
+	changes, err := service.Changes(context.Background(), epoch, revision)
+	if err != nil {
+		panic(err)
+	}
+	return changes
+}
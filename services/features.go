@@ -1,22 +1,50 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 
+	analytics "github.com/antoineaugusti/feature-flags/analytics"
+	metrics "github.com/antoineaugusti/feature-flags/metrics"
 	m "github.com/antoineaugusti/feature-flags/models"
 	repos "github.com/antoineaugusti/feature-flags/repos"
-	"github.com/boltdb/bolt"
 )
 
 type FeatureService struct {
-	DB *bolt.DB
+	// Where feature flags and segments are stored
+	Store repos.Store
+	// Counts evaluations of feature flags. The counts of removed
+	// feature flags are deleted. Nil when they are not counted
+	Analytics *analytics.Recorder
+	// The snapshot used to check access to features,
+	// nil when the service was not created by NewFeatureService
+	cache *snapshotCache
+}
+
+// NewFeatureService creates a service keeping in memory a snapshot
+// of the feature flags and segments of a store
+func NewFeatureService(store repos.Store) (*FeatureService, error) {
+	interactor := &FeatureService{Store: store, cache: &snapshotCache{log: newChangeLog()}}
+
+	snapshot, err := interactor.loadSnapshot(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	interactor.cache.value.Store(snapshot)
+	metrics.Features.Set(float64(len(snapshot.Features)))
+
+	return interactor, nil
 }
 
 // Store a new feature flag in the database
-func (interactor *FeatureService) AddFeature(newFeature m.FeatureFlag) error {
-	return interactor.DB.Update(func(tx *bolt.Tx) error {
+func (interactor *FeatureService) AddFeature(ctx context.Context, newFeature m.FeatureFlag) error {
+	ctx, span := interactor.startSpan(ctx, "AddFeature")
+	defer span.End()
+
+	return interactor.update(ctx, func(tx repos.Tx) error {
 
-		feature, err := repos.GetFeature(tx, newFeature.Key)
+		feature, err := tx.GetFeature(newFeature.Key)
 		if err != nil && err.Error() != "Unable to find feature" {
 			return err
 		}
@@ -25,15 +53,24 @@ func (interactor *FeatureService) AddFeature(newFeature m.FeatureFlag) error {
 			return fmt.Errorf("Feature already exists")
 		}
 
-		return repos.PutFeature(tx, newFeature)
+		if err := checkSegments(tx, newFeature.Segments); err != nil {
+			return err
+		}
+
+		// Only configuration files define managed features
+		newFeature.Managed = false
+		return tx.PutFeature(newFeature)
 	})
 }
 
 // GetFeatures gets a list of feature flags
-func (interactor *FeatureService) GetFeatures() (features m.FeatureFlags, err error) {
-	_ = interactor.DB.View(func(tx *bolt.Tx) error {
+func (interactor *FeatureService) GetFeatures(ctx context.Context) (features m.FeatureFlags, err error) {
+	ctx, span := interactor.startSpan(ctx, "GetFeatures")
+	defer span.End()
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
 
-		features, err = repos.GetFeatures(tx)
+		features, err = tx.GetFeatures()
 		return err
 	})
 
@@ -41,10 +78,13 @@ func (interactor *FeatureService) GetFeatures() (features m.FeatureFlags, err er
 }
 
 // GetFeature gets a single feature flag thanks to its key
-func (interactor *FeatureService) GetFeature(featureKey string) (feature m.FeatureFlag, err error) {
-	_ = interactor.DB.View(func(tx *bolt.Tx) error {
+func (interactor *FeatureService) GetFeature(ctx context.Context, featureKey string) (feature m.FeatureFlag, err error) {
+	ctx, span := interactor.startSpan(ctx, "GetFeature")
+	defer span.End()
 
-		feature, err = repos.GetFeature(tx, featureKey)
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
+
+		feature, err = tx.GetFeature(featureKey)
 		return err
 	})
 
@@ -52,46 +92,208 @@ func (interactor *FeatureService) GetFeature(featureKey string) (feature m.Featu
 }
 
 // Update a feature flag
-func (interactor *FeatureService) UpdateFeature(featureKey string, newFeature m.FeatureFlag) (feature m.FeatureFlag, err error) {
-	_ = interactor.DB.Update(func(tx *bolt.Tx) error {
+func (interactor *FeatureService) UpdateFeature(ctx context.Context, featureKey string, newFeature m.FeatureFlag) (feature m.FeatureFlag, err error) {
+	ctx, span := interactor.startSpan(ctx, "UpdateFeature")
+	defer span.End()
+
+	_ = interactor.update(ctx, func(tx repos.Tx) error {
 
-		if feature, err = repos.GetFeature(tx, featureKey); err != nil {
+		if feature, err = getUnmanagedFeature(tx, featureKey); err != nil {
 			return err
 		}
 
-		feature.Enabled = newFeature.Enabled
+		feature, err = replaceFeature(tx, feature, newFeature)
+		return err
+	})
+
+	return
+}
+
+// PatchFeature reads a feature flag, changes it thanks to patch and
+// stores the result in a single transaction, so that concurrent changes
+// are never lost. The key of the feature flag cannot be changed
+func (interactor *FeatureService) PatchFeature(ctx context.Context, featureKey string, patch func(m.FeatureFlag) (m.FeatureFlag, error)) (feature m.FeatureFlag, err error) {
+	ctx, span := interactor.startSpan(ctx, "PatchFeature")
+	defer span.End()
 
-		if len(newFeature.Users) > 0 {
-			feature.Users = newFeature.Users
+	_ = interactor.update(ctx, func(tx repos.Tx) error {
+
+		if feature, err = getUnmanagedFeature(tx, featureKey); err != nil {
+			return err
 		}
 
-		if len(newFeature.Groups) > 0 {
-			feature.Groups = newFeature.Groups
+		var newFeature m.FeatureFlag
+		if newFeature, err = patch(feature); err != nil {
+			return err
 		}
 
-		if newFeature.Percentage > 0 {
-			feature.Percentage = newFeature.Percentage
+		feature, err = replaceFeature(tx, feature, newFeature)
+		return err
+	})
+
+	return
+}
+
+// AddUser gives access to a feature flag to a specific user
+func (interactor *FeatureService) AddUser(ctx context.Context, featureKey string, user uint32) (feature m.FeatureFlag, err error) {
+	ctx, span := interactor.startSpan(ctx, "AddUser")
+	defer span.End()
+
+	_ = interactor.update(ctx, func(tx repos.Tx) error {
+
+		if _, err = getUnmanagedFeature(tx, featureKey); err != nil {
+			return err
 		}
 
-		return repos.PutFeature(tx, feature)
+		if err = tx.AddUser(featureKey, user); err != nil {
+			return err
+		}
+
+		feature, err = tx.GetFeature(featureKey)
+		return err
 	})
 
 	return
 }
 
+// RemoveUser removes a specific user from the allowed users of a feature flag
+func (interactor *FeatureService) RemoveUser(ctx context.Context, featureKey string, user uint32) (feature m.FeatureFlag, err error) {
+	ctx, span := interactor.startSpan(ctx, "RemoveUser")
+	defer span.End()
+
+	_ = interactor.update(ctx, func(tx repos.Tx) error {
+
+		if _, err = getUnmanagedFeature(tx, featureKey); err != nil {
+			return err
+		}
+
+		if err = tx.RemoveUser(featureKey, user); err != nil {
+			return err
+		}
+
+		feature, err = tx.GetFeature(featureKey)
+		return err
+	})
+
+	return
+}
+
+// AddGroup gives access to a feature flag to a specific group
+func (interactor *FeatureService) AddGroup(ctx context.Context, featureKey string, group string) (m.FeatureFlag, error) {
+	ctx, span := interactor.startSpan(ctx, "AddGroup")
+	defer span.End()
+
+	return interactor.editFeature(ctx, featureKey, func(feature *m.FeatureFlag) {
+		feature.AddGroup(group)
+	})
+}
+
+// RemoveGroup removes a specific group from the allowed groups of a feature flag
+func (interactor *FeatureService) RemoveGroup(ctx context.Context, featureKey string, group string) (m.FeatureFlag, error) {
+	ctx, span := interactor.startSpan(ctx, "RemoveGroup")
+	defer span.End()
+
+	return interactor.editFeature(ctx, featureKey, func(feature *m.FeatureFlag) {
+		feature.RemoveGroup(group)
+	})
+}
+
 // Delete a feature flag
-func (interactor *FeatureService) RemoveFeature(featureKey string) error {
-	return interactor.DB.Update(func(tx *bolt.Tx) error {
-		return repos.RemoveFeature(tx, featureKey)
+func (interactor *FeatureService) RemoveFeature(ctx context.Context, featureKey string) error {
+	ctx, span := interactor.startSpan(ctx, "RemoveFeature")
+	defer span.End()
+
+	err := interactor.update(ctx, func(tx repos.Tx) error {
+		if _, err := getUnmanagedFeature(tx, featureKey); err != nil {
+			return err
+		}
+
+		return tx.RemoveFeature(featureKey)
 	})
+	if err != nil {
+		return err
+	}
+
+	interactor.removeStats(featureKey)
+	return nil
+}
+
+// Delete the evaluation counts of removed feature flags. The feature
+// flags are already removed, so failures are only logged
+func (interactor *FeatureService) removeStats(featureKeys ...string) {
+	for _, featureKey := range featureKeys {
+		if err := interactor.Analytics.Remove(featureKey); err != nil {
+			slog.Error("Unable to delete evaluation counts", "feature", featureKey, "error", err)
+		}
+	}
 }
 
 // Tell if a feature flag exists thanks to a key
-func (interactor *FeatureService) FeatureExists(featureKey string) (exists bool) {
-	_ = interactor.DB.View(func(tx *bolt.Tx) error {
-		exists = repos.FeatureExists(tx, featureKey)
-		return nil
+func (interactor *FeatureService) FeatureExists(ctx context.Context, featureKey string) (exists bool) {
+	ctx, span := interactor.startSpan(ctx, "FeatureExists")
+	defer span.End()
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) (err error) {
+		exists, err = tx.FeatureExists(featureKey)
+		return err
 	})
 
 	return
 }
+
+// Read, modify and store a feature flag in a single transaction
+func (interactor *FeatureService) editFeature(ctx context.Context, featureKey string, edit func(*m.FeatureFlag)) (feature m.FeatureFlag, err error) {
+	_ = interactor.update(ctx, func(tx repos.Tx) error {
+
+		if feature, err = getUnmanagedFeature(tx, featureKey); err != nil {
+			return err
+		}
+
+		edit(&feature)
+
+		err = tx.PutFeature(feature)
+		return err
+	})
+
+	return
+}
+
+// Overwrite every property of a feature flag but its key, even with
+// an empty value
+func replaceFeature(tx repos.Tx, feature, newFeature m.FeatureFlag) (m.FeatureFlag, error) {
+	if err := checkSegments(tx, newFeature.Segments); err != nil {
+		return feature, err
+	}
+
+	feature.Enabled = newFeature.Enabled
+	feature.Users = newFeature.Users
+	feature.Groups = newFeature.Groups
+	feature.Percentage = newFeature.Percentage
+	feature.Segments = newFeature.Segments
+
+	// Cleared lists are stored as empty lists
+	if feature.Users == nil {
+		feature.Users = []uint32{}
+	}
+	if feature.Groups == nil {
+		feature.Groups = []string{}
+	}
+	if feature.Segments == nil {
+		feature.Segments = []string{}
+	}
+
+	return feature, tx.PutFeature(feature)
+}
+
+// Get a feature flag which can be changed through the API
+func getUnmanagedFeature(tx repos.Tx, featureKey string) (m.FeatureFlag, error) {
+	feature, err := tx.GetFeature(featureKey)
+	if err != nil {
+		return feature, err
+	}
+
+	if feature.Managed {
+		return feature, fmt.Errorf("Feature is managed by configuration files")
+	}
+	return feature, nil
+}
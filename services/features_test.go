@@ -1,13 +1,18 @@
 package services
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
+	analytics "github.com/antoineaugusti/feature-flags/analytics"
 	db "github.com/antoineaugusti/feature-flags/db"
 	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
 	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
 )
@@ -15,7 +20,7 @@ import (
 func TestGetFeaturesEmpty(t *testing.T) {
 	db := getTestDB()
 	defer closeDB(db)
-	features, err := getService(db).GetFeatures()
+	features, err := getService(db).GetFeatures(context.Background())
 
 	// No features
 	assert.Equal(t, len(features), 0)
@@ -25,23 +30,29 @@ func TestGetFeaturesEmpty(t *testing.T) {
 func TestAddFeature(t *testing.T) {
 	db := getTestDB()
 	defer closeDB(db)
-	features, _ := getService(db).GetFeatures()
+	features, _ := getService(db).GetFeatures(context.Background())
 
 	// No features
 	assert.Equal(t, len(features), 0)
 
 	// Create a new feature
-	err := getService(db).AddFeature(getDummyFeature())
+	err := getService(db).AddFeature(context.Background(), getDummyFeature())
 	assert.Nil(t, err)
 
 	// We can get the feature
-	features, _ = getService(db).GetFeatures()
+	features, _ = getService(db).GetFeatures(context.Background())
 	assert.Equal(t, len(features), 1)
 	assert.Equal(t, features[0].Key, "foo")
 
 	// I cannot add a feature with the same key
-	err = getService(db).AddFeature(getDummyFeature())
+	err = getService(db).AddFeature(context.Background(), getDummyFeature())
 	assert.Equal(t, err.Error(), "Feature already exists")
+
+	// Added features are never managed
+	err = getService(db).AddFeature(context.Background(), m.FeatureFlag{Key: "managed", Managed: true})
+	assert.Nil(t, err)
+	feature, _ := getService(db).GetFeature(context.Background(), "managed")
+	assert.False(t, feature.Managed)
 }
 
 func TestGetFeature(t *testing.T) {
@@ -49,15 +60,15 @@ func TestGetFeature(t *testing.T) {
 	defer closeDB(db)
 
 	// Create a new feature
-	_ = getService(db).AddFeature(getDummyFeature())
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
 
 	// Get an existing feature
-	f, err := getService(db).GetFeature("foo")
+	f, err := getService(db).GetFeature(context.Background(), "foo")
 	assert.Nil(t, err)
 	assert.Equal(t, f.Key, "foo")
 
 	// Try to find an unexisting feature
-	f, err = getService(db).GetFeature("bar")
+	f, err = getService(db).GetFeature(context.Background(), "bar")
 	assert.Equal(t, err.Error(), "Unable to find feature")
 	assert.Equal(t, len(f.Key), 0)
 }
@@ -67,7 +78,7 @@ func TestUpdateFeature(t *testing.T) {
 	defer closeDB(db)
 
 	// Create a new feature
-	_ = getService(db).AddFeature(getDummyFeature())
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
 
 	newFeature := getDummyFeature()
 	newFeature.Enabled = true
@@ -76,49 +87,164 @@ func TestUpdateFeature(t *testing.T) {
 	newFeature.Percentage = uint32(22)
 
 	// Update the feature
-	f, err := getService(db).UpdateFeature(newFeature.Key, newFeature)
+	f, err := getService(db).UpdateFeature(context.Background(), newFeature.Key, newFeature)
 	assert.Nil(t, err)
 	assert.True(t, f.Enabled)
 	assert.Equal(t, f.Users, []uint32{1, 2})
 	assert.Equal(t, f.Groups, []string{"c", "d"})
 	assert.Equal(t, f.Percentage, uint32(22))
 
+	// Empty values are stored
+	newFeature.Enabled = false
+	newFeature.Users = nil
+	newFeature.Groups = []string{}
+	newFeature.Percentage = 0
+
+	f, err = getService(db).UpdateFeature(context.Background(), newFeature.Key, newFeature)
+	assert.Nil(t, err)
+	assert.False(t, f.Enabled)
+	assert.Equal(t, f.Users, []uint32{})
+	assert.Equal(t, f.Groups, []string{})
+	assert.Equal(t, f.Percentage, uint32(0))
+
 	// Update an unexisting feature
-	_, err = getService(db).UpdateFeature("bar", newFeature)
+	_, err = getService(db).UpdateFeature(context.Background(), "bar", newFeature)
 	assert.NotNil(t, err)
 }
 
+func TestPatchFeature(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
+
+	// Concurrent patches see the changes of each other
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(user uint32) {
+			defer wg.Done()
+			_, err := getService(db).PatchFeature(context.Background(), "foo", func(feature m.FeatureFlag) (m.FeatureFlag, error) {
+				feature.Users = append(feature.Users[:len(feature.Users):len(feature.Users)], user)
+				return feature, nil
+			})
+			assert.Nil(t, err)
+		}(uint32(i))
+	}
+	wg.Wait()
+
+	f, _ := getService(db).GetFeature(context.Background(), "foo")
+	assert.Equal(t, 11, len(f.Users))
+
+	// Nothing is stored when the patch fails
+	_, err := getService(db).PatchFeature(context.Background(), "foo", func(feature m.FeatureFlag) (m.FeatureFlag, error) {
+		feature.Enabled = true
+		return feature, fmt.Errorf("Invalid patch")
+	})
+	assert.Equal(t, "Invalid patch", err.Error())
+	f, _ = getService(db).GetFeature(context.Background(), "foo")
+	assert.False(t, f.Enabled)
+
+	// The key cannot be changed
+	f, err = getService(db).PatchFeature(context.Background(), "foo", func(feature m.FeatureFlag) (m.FeatureFlag, error) {
+		feature.Key = "bar"
+		return feature, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", f.Key)
+
+	_, err = getService(db).PatchFeature(context.Background(), "unknown", func(feature m.FeatureFlag) (m.FeatureFlag, error) {
+		return feature, nil
+	})
+	assert.Equal(t, "Unable to find feature", err.Error())
+}
+
+func TestEditUsersAndGroups(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	// Create a new feature
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
+
+	f, err := getService(db).AddUser(context.Background(), "foo", 42)
+	assert.Nil(t, err)
+	assert.Equal(t, f.Users, []uint32{22, 42})
+
+	f, err = getService(db).RemoveUser(context.Background(), "foo", 22)
+	assert.Nil(t, err)
+	assert.Equal(t, f.Users, []uint32{42})
+
+	f, err = getService(db).AddGroup(context.Background(), "foo", "dev")
+	assert.Nil(t, err)
+	assert.Equal(t, f.Groups, []string{"dev"})
+
+	f, err = getService(db).RemoveGroup(context.Background(), "foo", "dev")
+	assert.Nil(t, err)
+	assert.Equal(t, f.Groups, []string{})
+
+	// Changes are stored
+	f, _ = getService(db).GetFeature(context.Background(), "foo")
+	assert.Equal(t, f.Users, []uint32{42})
+	assert.Equal(t, f.Groups, []string{})
+
+	// Edit an unexisting feature
+	_, err = getService(db).AddUser(context.Background(), "bar", 42)
+	assert.Equal(t, err.Error(), "Unable to find feature")
+}
+
 func TestRemoveFeature(t *testing.T) {
 	db := getTestDB()
 	defer closeDB(db)
 
 	// Create a new feature
-	err := getService(db).AddFeature(getDummyFeature())
-	features, _ := getService(db).GetFeatures()
+	err := getService(db).AddFeature(context.Background(), getDummyFeature())
+	features, _ := getService(db).GetFeatures(context.Background())
 	assert.Equal(t, len(features), 1)
 
 	// Delete the feature
-	err = getService(db).RemoveFeature("foo")
-	features, _ = getService(db).GetFeatures()
+	err = getService(db).RemoveFeature(context.Background(), "foo")
+	features, _ = getService(db).GetFeatures(context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, len(features), 0)
 }
 
+func TestRemoveFeatureStats(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	service := getService(db)
+	service.Analytics = analytics.NewRecorder(db, 10)
+	_ = service.AddFeature(context.Background(), getDummyFeature())
+
+	service.Analytics.Record(analytics.Event{Feature: "foo", Result: analytics.ResultHasAccess, Variant: "user", Time: time.Now()})
+	service.Analytics.Record(analytics.Event{Feature: "bar", Result: analytics.ResultHasAccess, Variant: "user", Time: time.Now()})
+	stop := make(chan struct{})
+	close(stop)
+	service.Analytics.Run(time.Hour, stop)
+
+	// The counts of the removed feature are deleted
+	assert.Nil(t, service.RemoveFeature(context.Background(), "foo"))
+	stats, _ := service.Analytics.Stats("foo", time.Now())
+	assert.Equal(t, 0, len(stats))
+	stats, _ = service.Analytics.Stats("bar", time.Now())
+	assert.Equal(t, 1, len(stats))
+}
+
 func TestFeatureExists(t *testing.T) {
 	db := getTestDB()
 	defer closeDB(db)
 
 	// Create a new feature
-	_ = getService(db).AddFeature(getDummyFeature())
-	assert.True(t, getService(db).FeatureExists("foo"))
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
+	assert.True(t, getService(db).FeatureExists(context.Background(), "foo"))
 
 	// Delete the feature
-	_ = getService(db).RemoveFeature("foo")
-	assert.False(t, getService(db).FeatureExists("foo"))
+	_ = getService(db).RemoveFeature(context.Background(), "foo")
+	assert.False(t, getService(db).FeatureExists(context.Background(), "foo"))
 }
 
 func getService(db *bolt.DB) *FeatureService {
-	return &FeatureService{db}
+	return &FeatureService{Store: repos.NewBoltStore(db)}
 }
 
 func getTestDB() *bolt.DB {
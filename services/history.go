@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+)
+
+// FeatureHistory gets the latest changes of a feature flag, newest first
+func (interactor *FeatureService) FeatureHistory(ctx context.Context, featureKey string) (history []m.FeatureChange, err error) {
+	ctx, span := interactor.startSpan(ctx, "FeatureHistory")
+	defer span.End()
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
+
+		history, err = tx.GetChanges(featureKey)
+		return err
+	})
+
+	return
+}
+
+// Record in the history how the feature flags written in the
+// transaction changed, before it is committed. Only the users which
+// were added or removed are recorded
+func (t recordingTx) recordHistory() error {
+	now := time.Now()
+	seen := make(map[string]bool)
+
+	for _, key := range t.entry.features {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		previous, current := t.previous[key], t.current[key]
+		change := m.FeatureChange{Time: now, AddedUsers: []uint32{}, RemovedUsers: []uint32{}}
+		switch {
+		case previous != nil && current == nil:
+			change.Action = m.ActionRemoved
+			change.Feature = historyFeature(*previous)
+			change.UserCount = len(userSet(previous.Users))
+		case previous == nil && current != nil:
+			change.Action = m.ActionCreated
+			change.Feature = historyFeature(*current)
+			change.AddedUsers, _, change.UserCount = diffUsers(nil, current.Users)
+		case current != nil:
+			change.Action = m.ActionUpdated
+			change.Feature = historyFeature(*current)
+			change.AddedUsers, change.RemovedUsers, change.UserCount = diffUsers(previous.Users, current.Users)
+			if len(change.AddedUsers) == 0 && len(change.RemovedUsers) == 0 &&
+				reflect.DeepEqual(historyFeature(*previous), change.Feature) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if err := t.Tx.AddChange(change); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Keep a feature flag as it was before its first write in the
+// transaction, unless it was already read
+func (t recordingTx) remember(featureKey string) error {
+	t.entry.features = append(t.entry.features, featureKey)
+	if _, ok := t.previous[featureKey]; !ok {
+		if _, err := t.GetFeature(featureKey); err != nil && err.Error() != "Unable to find feature" {
+			return err
+		}
+	}
+
+	if _, ok := t.current[featureKey]; !ok {
+		if previous := t.previous[featureKey]; previous != nil {
+			t.current[featureKey] = withoutIndex(*previous)
+		} else {
+			t.current[featureKey] = nil
+		}
+	}
+	return nil
+}
+
+// Copy a feature flag without its users index, which
+// would be rebuilt by every AddUser or RemoveUser
+func withoutIndex(feature m.FeatureFlag) *m.FeatureFlag {
+	return &m.FeatureFlag{
+		Key:        feature.Key,
+		Enabled:    feature.Enabled,
+		Users:      feature.Users,
+		Groups:     feature.Groups,
+		Percentage: feature.Percentage,
+		Segments:   feature.Segments,
+		Managed:    feature.Managed,
+	}
+}
+
+// The feature flag kept by the history, without its users. Lists
+// are never nil, so that stored and written versions compare equal
+func historyFeature(feature m.FeatureFlag) m.FeatureFlag {
+	recorded := *withoutIndex(feature)
+	recorded.Users = []uint32{}
+	if recorded.Groups == nil {
+		recorded.Groups = []string{}
+	}
+	if recorded.Segments == nil {
+		recorded.Segments = []string{}
+	}
+	return recorded
+}
+
+// Find the users which were added and removed, sorted by ID, and
+// count the users after the change
+func diffUsers(before, after []uint32) (added, removed []uint32, count int) {
+	previous, current := userSet(before), userSet(after)
+
+	added, removed = make([]uint32, 0), make([]uint32, 0)
+	for user := range current {
+		if !previous[user] {
+			added = append(added, user)
+		}
+	}
+	for user := range previous {
+		if !current[user] {
+			removed = append(removed, user)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+
+	return added, removed, len(current)
+}
+
+func userSet(users []uint32) map[uint32]bool {
+	set := make(map[uint32]bool, len(users))
+	for _, user := range users {
+		set[user] = true
+	}
+	return set
+}
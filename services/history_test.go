@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureHistory(t *testing.T) {
+	store := repos.NewMemoryStore()
+	service, _ := NewFeatureService(store)
+
+	_ = service.AddFeature(context.Background(), getDummyFeature())
+	_, _ = service.AddUser(context.Background(), "foo", 1)
+	// Nothing changed
+	_, _ = service.AddUser(context.Background(), "foo", 1)
+	_ = service.AddSegment(context.Background(), getDummySegment())
+	_ = service.RemoveFeature(context.Background(), "foo")
+
+	// The history is kept by the store
+	service, _ = NewFeatureService(store)
+	history, err := service.FeatureHistory(context.Background(), "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+
+	// Users are not copied in the feature flags of the history
+	assert.Equal(t, m.ActionRemoved, history[0].Action)
+	assert.Equal(t, uint64(3), history[0].Revision)
+	assert.Equal(t, []uint32{}, history[0].Feature.Users)
+	assert.Equal(t, 2, history[0].UserCount)
+	assert.Equal(t, []uint32{}, history[0].RemovedUsers)
+
+	assert.Equal(t, m.ActionUpdated, history[1].Action)
+	assert.Equal(t, uint64(2), history[1].Revision)
+	assert.Equal(t, []uint32{1}, history[1].AddedUsers)
+	assert.Equal(t, []uint32{}, history[1].RemovedUsers)
+	assert.Equal(t, 2, history[1].UserCount)
+
+	assert.Equal(t, m.ActionCreated, history[2].Action)
+	assert.Equal(t, uint64(1), history[2].Revision)
+	assert.Equal(t, []uint32{22}, history[2].AddedUsers)
+	assert.Equal(t, 1, history[2].UserCount)
+	assert.Equal(t, uint32(42), history[2].Feature.Percentage)
+	assert.False(t, history[2].Time.IsZero())
+
+	history, err = service.FeatureHistory(context.Background(), "unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(history))
+}
+
+func TestFeatureHistoryWithoutSnapshot(t *testing.T) {
+	service := &FeatureService{Store: repos.NewMemoryStore()}
+
+	_ = service.AddFeature(context.Background(), getDummyFeature())
+	_, _ = service.UpdateFeature(context.Background(), "foo", m.FeatureFlag{Key: "foo", Enabled: true})
+
+	history, err := service.FeatureHistory(context.Background(), "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, m.ActionUpdated, history[0].Action)
+	assert.True(t, history[0].Feature.Enabled)
+	assert.Equal(t, m.ActionCreated, history[1].Action)
+}
+
+func TestFeatureHistoryOfLargeFeatureFlag(t *testing.T) {
+	service, _ := NewFeatureService(repos.NewMemoryStore())
+
+	feature := getDummyFeature()
+	feature.Users = make([]uint32, 100000)
+	for i := range feature.Users {
+		feature.Users[i] = uint32(i)
+	}
+	_ = service.AddFeature(context.Background(), feature)
+
+	// Adding a user records this user only
+	_, err := service.AddUser(context.Background(), "foo", 100000)
+	assert.Nil(t, err)
+	_, err = service.RemoveUser(context.Background(), "foo", 42)
+	assert.Nil(t, err)
+
+	history, _ := service.FeatureHistory(context.Background(), "foo")
+	assert.Equal(t, 3, len(history))
+	for _, change := range history[:2] {
+		bytes, _ := json.Marshal(change)
+		assert.True(t, len(bytes) < 512, string(bytes))
+	}
+
+	assert.Equal(t, []uint32{}, history[0].AddedUsers)
+	assert.Equal(t, []uint32{42}, history[0].RemovedUsers)
+	assert.Equal(t, 100000, history[0].UserCount)
+	assert.Equal(t, []uint32{100000}, history[1].AddedUsers)
+	assert.Equal(t, 100001, history[1].UserCount)
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+)
+
+// Describes how stored feature flags differ from their definitions
+type ReconcileReport struct {
+	DryRun bool `json:"dry_run"`
+	// Defined feature flags which did not exist
+	Created []string `json:"created"`
+	// Defined feature flags which were different
+	Updated []string `json:"updated"`
+	// Managed feature flags without definition, deleted when pruning
+	Removed []string `json:"removed"`
+	// Managed feature flags without definition, kept and no longer
+	// managed when not pruning
+	Undefined []string `json:"undefined"`
+}
+
+func newReconcileReport(dryRun bool) ReconcileReport {
+	return ReconcileReport{DryRun: dryRun, Created: []string{}, Updated: []string{}, Removed: []string{}, Undefined: []string{}}
+}
+
+// InSync tells if stored feature flags matched their definitions
+func (r ReconcileReport) InSync() bool {
+	return len(r.Created) == 0 && len(r.Updated) == 0 && len(r.Removed) == 0
+}
+
+// Reconcile makes stored feature flags match their definitions, read from
+// configuration files, in a single transaction. Defined feature flags are
+// marked as managed. Managed feature flags without definition are deleted
+// when pruning, and are no longer managed otherwise. A dry run reports
+// differences without changing anything
+func (interactor *FeatureService) Reconcile(ctx context.Context, definitions m.FeatureFlags, prune, dryRun bool) (ReconcileReport, error) {
+	ctx, span := interactor.startSpan(ctx, "Reconcile")
+	defer span.End()
+
+	report := newReconcileReport(dryRun)
+
+	defined := make(map[string]bool, len(definitions))
+	for _, definition := range definitions {
+		if err := definition.Validate(); err != nil {
+			return report, fmt.Errorf("Invalid feature %s: %s", definition.Key, err)
+		}
+
+		if defined[definition.Key] {
+			return report, fmt.Errorf("Feature %s is defined more than once", definition.Key)
+		}
+		defined[definition.Key] = true
+	}
+
+	err := interactor.update(ctx, func(tx repos.Tx) error {
+		// The transaction can be run again
+		report = newReconcileReport(dryRun)
+
+		for _, definition := range definitions {
+			definition = normalizeDefinition(definition)
+
+			missing, err := missingSegment(tx, definition.Segments)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return fmt.Errorf("Segment %s does not exist", missing)
+			}
+
+			feature, err := tx.GetFeature(definition.Key)
+			if err != nil && err.Error() != "Unable to find feature" {
+				return err
+			}
+
+			if err != nil {
+				report.Created = append(report.Created, definition.Key)
+			} else if !feature.Managed || !sameDefinition(normalizeDefinition(feature), definition) {
+				report.Updated = append(report.Updated, definition.Key)
+			} else {
+				continue
+			}
+
+			if err := tx.PutFeature(definition); err != nil {
+				return err
+			}
+		}
+
+		features, err := tx.GetFeatures()
+		if err != nil {
+			return err
+		}
+
+		for _, feature := range features {
+			// Feature flags created through the API are left alone
+			if defined[feature.Key] || !feature.Managed {
+				continue
+			}
+
+			// Without definition, the feature flag can be changed through the API again
+			if !prune {
+				report.Undefined = append(report.Undefined, feature.Key)
+				feature.Managed = false
+				if err := tx.PutFeature(feature); err != nil {
+					return err
+				}
+				continue
+			}
+
+			report.Removed = append(report.Removed, feature.Key)
+			if err := tx.RemoveFeature(feature.Key); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	if err == errDryRun {
+		return report, nil
+	}
+	if err == nil {
+		interactor.removeStats(report.Removed...)
+	}
+	return report, err
+}
+
+// Give a definition the form of a stored feature flag: managed,
+// with sorted unique users and empty lists instead of nil
+func normalizeDefinition(feature m.FeatureFlag) m.FeatureFlag {
+	users := make([]uint32, 0, len(feature.Users))
+	seen := make(map[uint32]bool, len(feature.Users))
+	for _, user := range feature.Users {
+		if !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+	sort.Sort(usersByID(users))
+
+	normalized := m.FeatureFlag{
+		Key:        feature.Key,
+		Enabled:    feature.Enabled,
+		Users:      users,
+		Groups:     feature.Groups,
+		Percentage: feature.Percentage,
+		Segments:   feature.Segments,
+		Managed:    true,
+	}
+	if normalized.Groups == nil {
+		normalized.Groups = []string{}
+	}
+	if normalized.Segments == nil {
+		normalized.Segments = []string{}
+	}
+
+	return normalized
+}
+
+// Tell if a normalized stored feature flag matches a definition
+func sameDefinition(feature, definition m.FeatureFlag) bool {
+	return feature.Enabled == definition.Enabled &&
+		feature.Percentage == definition.Percentage &&
+		reflect.DeepEqual(feature.Users, definition.Users) &&
+		reflect.DeepEqual(feature.Groups, definition.Groups) &&
+		reflect.DeepEqual(feature.Segments, definition.Segments)
+}
+
+type usersByID []uint32
+
+func (u usersByID) Len() int           { return len(u) }
+func (u usersByID) Less(i, j int) bool { return u[i] < u[j] }
+func (u usersByID) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
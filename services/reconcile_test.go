@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
+	_ = getService(db).AddFeature(context.Background(), m.FeatureFlag{Key: "manual"})
+
+	definitions := m.FeatureFlags{
+		{Key: "foo", Users: []uint32{22}, Percentage: 42},
+		{Key: "bar", Enabled: true, Users: []uint32{3, 1, 3}},
+	}
+
+	// A dry run reports the drift
+	report, err := getService(db).Reconcile(context.Background(), definitions, false, true)
+	assert.Nil(t, err)
+	assert.False(t, report.InSync())
+	assert.Equal(t, []string{"bar"}, report.Created)
+	assert.Equal(t, []string{"foo"}, report.Updated)
+	assert.Equal(t, []string{}, report.Undefined)
+	assert.False(t, getService(db).FeatureExists(context.Background(), "bar"))
+
+	// Defined features are created and marked as managed
+	report, err = getService(db).Reconcile(context.Background(), definitions, false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bar"}, report.Created)
+	feature, _ := getService(db).GetFeature(context.Background(), "bar")
+	assert.True(t, feature.Managed)
+	assert.Equal(t, []uint32{1, 3}, feature.Users)
+	feature, _ = getService(db).GetFeature(context.Background(), "foo")
+	assert.True(t, feature.Managed)
+
+	// Nothing changes the second time
+	report, err = getService(db).Reconcile(context.Background(), definitions, false, false)
+	assert.Nil(t, err)
+	assert.True(t, report.InSync())
+
+	// Managed features cannot be changed through the service
+	_, err = getService(db).AddUser(context.Background(), "foo", 1)
+	assert.Equal(t, "Feature is managed by configuration files", err.Error())
+	_, err = getService(db).AddGroup(context.Background(), "foo", "dev")
+	assert.Equal(t, "Feature is managed by configuration files", err.Error())
+	_, err = getService(db).UpdateFeature(context.Background(), "foo", m.FeatureFlag{Enabled: true})
+	assert.Equal(t, "Feature is managed by configuration files", err.Error())
+	err = getService(db).RemoveFeature(context.Background(), "foo")
+	assert.Equal(t, "Feature is managed by configuration files", err.Error())
+
+	_, err = getService(db).Import(context.Background(), m.Export{Version: m.ExportVersion, Features: m.FeatureFlags{{Key: "foo"}}}, ConflictOverwrite, false)
+	assert.Equal(t, ImportError{Conflict: true, message: "Feature foo is managed by configuration files"}, err)
+
+	// Undefined managed features are released when not pruning
+	report, err = getService(db).Reconcile(context.Background(), definitions[:1], false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bar"}, report.Undefined)
+	feature, _ = getService(db).GetFeature(context.Background(), "bar")
+	assert.False(t, feature.Managed)
+	_, err = getService(db).AddUser(context.Background(), "bar", 2)
+	assert.Nil(t, err)
+
+	// Undefined managed features are pruned, features created
+	// through the API are kept
+	report, err = getService(db).Reconcile(context.Background(), definitions[1:], true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bar"}, report.Updated)
+	assert.Equal(t, []string{"foo"}, report.Removed)
+	assert.False(t, getService(db).FeatureExists(context.Background(), "foo"))
+	assert.True(t, getService(db).FeatureExists(context.Background(), "manual"))
+}
+
+func TestReconcileInvalidDefinitions(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	_, err := getService(db).Reconcile(context.Background(), m.FeatureFlags{{Key: "ab"}}, false, false)
+	assert.Equal(t, "Invalid feature ab: Feature key must be between 3 and 50 characters", err.Error())
+
+	_, err = getService(db).Reconcile(context.Background(), m.FeatureFlags{{Key: "foo"}, {Key: "foo"}}, false, false)
+	assert.Equal(t, "Feature foo is defined more than once", err.Error())
+
+	_, err = getService(db).Reconcile(context.Background(), m.FeatureFlags{{Key: "foo"}, {Key: "bar", Segments: []string{"unknown"}}}, false, false)
+	assert.Equal(t, "Segment unknown does not exist", err.Error())
+	assert.False(t, getService(db).FeatureExists(context.Background(), "foo"))
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	helpers "github.com/antoineaugusti/feature-flags/helpers"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+)
+
+// Store a new segment in the database
+func (interactor *FeatureService) AddSegment(ctx context.Context, newSegment m.Segment) error {
+	ctx, span := interactor.startSpan(ctx, "AddSegment")
+	defer span.End()
+
+	return interactor.update(ctx, func(tx repos.Tx) error {
+
+		exists, err := tx.SegmentExists(newSegment.Key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("Segment already exists")
+		}
+
+		return tx.PutSegment(newSegment)
+	})
+}
+
+// GetSegments gets a list of segments
+func (interactor *FeatureService) GetSegments(ctx context.Context) (segments m.Segments, err error) {
+	ctx, span := interactor.startSpan(ctx, "GetSegments")
+	defer span.End()
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
+
+		segments, err = tx.GetSegments()
+		return err
+	})
+
+	return
+}
+
+// GetSegment gets a single segment thanks to its key
+func (interactor *FeatureService) GetSegment(ctx context.Context, segmentKey string) (segment m.Segment, err error) {
+	ctx, span := interactor.startSpan(ctx, "GetSegment")
+	defer span.End()
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
+
+		segment, err = tx.GetSegment(segmentKey)
+		return err
+	})
+
+	return
+}
+
+// Update a segment. Every feature flag referencing
+// the segment sees the new version
+func (interactor *FeatureService) UpdateSegment(ctx context.Context, segmentKey string, newSegment m.Segment) (segment m.Segment, err error) {
+	ctx, span := interactor.startSpan(ctx, "UpdateSegment")
+	defer span.End()
+
+	_ = interactor.update(ctx, func(tx repos.Tx) error {
+
+		if segment, err = tx.GetSegment(segmentKey); err != nil {
+			return err
+		}
+
+		// The key cannot be changed
+		segment.Users = newSegment.Users
+		segment.Groups = newSegment.Groups
+		segment.Rules = newSegment.Rules
+
+		err = tx.PutSegment(segment)
+		return err
+	})
+
+	return
+}
+
+// Delete a segment. A segment referenced by feature flags cannot be deleted
+func (interactor *FeatureService) RemoveSegment(ctx context.Context, segmentKey string) error {
+	ctx, span := interactor.startSpan(ctx, "RemoveSegment")
+	defer span.End()
+
+	return interactor.update(ctx, func(tx repos.Tx) error {
+
+		exists, err := tx.SegmentExists(segmentKey)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("Unable to find segment")
+		}
+
+		features, err := segmentFeatures(tx, segmentKey)
+		if err != nil {
+			return err
+		}
+
+		if len(features) > 0 {
+			return fmt.Errorf("Segment is used by %d feature flags", len(features))
+		}
+
+		return tx.RemoveSegment(segmentKey)
+	})
+}
+
+// Tell if a segment exists thanks to a key
+func (interactor *FeatureService) SegmentExists(ctx context.Context, segmentKey string) (exists bool) {
+	ctx, span := interactor.startSpan(ctx, "SegmentExists")
+	defer span.End()
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) (err error) {
+		exists, err = tx.SegmentExists(segmentKey)
+		return err
+	})
+
+	return
+}
+
+// Make sure that segments referenced by a feature flag exist
+func checkSegments(tx repos.Tx, segmentKeys []string) error {
+	missing, err := missingSegment(tx, segmentKeys)
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("Segment %s does not exist", missing)
+	}
+	return err
+}
+
+// Find the first segment which does not exist, empty when they all exist
+func missingSegment(tx repos.Tx, segmentKeys []string) (string, error) {
+	for _, segmentKey := range segmentKeys {
+		exists, err := tx.SegmentExists(segmentKey)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return segmentKey, nil
+		}
+	}
+	return "", nil
+}
+
+// GetSegmentFeatures gets the feature flags referencing a segment
+func (interactor *FeatureService) GetSegmentFeatures(ctx context.Context, segmentKey string) (features m.FeatureFlags, err error) {
+	ctx, span := interactor.startSpan(ctx, "GetSegmentFeatures")
+	defer span.End()
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
+
+		features, err = segmentFeatures(tx, segmentKey)
+		return err
+	})
+
+	return
+}
+
+// Find the feature flags referencing a segment
+func segmentFeatures(tx repos.Tx, segmentKey string) (m.FeatureFlags, error) {
+	features, err := tx.GetFeatures()
+	if err != nil {
+		return nil, err
+	}
+
+	referencing := make(m.FeatureFlags, 0)
+	for _, feature := range features {
+		if helpers.StringInSlice(segmentKey, feature.Segments) {
+			referencing = append(referencing, feature)
+		}
+	}
+
+	return referencing, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddSegment(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+	segments, err := getService(db).GetSegments(context.Background())
+
+	// No segments
+	assert.Equal(t, len(segments), 0)
+	assert.Nil(t, err)
+
+	// Create a new segment
+	err = getService(db).AddSegment(context.Background(), getDummySegment())
+	assert.Nil(t, err)
+	assert.True(t, getService(db).SegmentExists(context.Background(), "beta_testers"))
+
+	segments, _ = getService(db).GetSegments(context.Background())
+	assert.Equal(t, len(segments), 1)
+	assert.Equal(t, segments[0].Key, "beta_testers")
+
+	// I cannot add a segment with the same key
+	err = getService(db).AddSegment(context.Background(), getDummySegment())
+	assert.Equal(t, err.Error(), "Segment already exists")
+}
+
+func TestUpdateSegment(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	_ = getService(db).AddSegment(context.Background(), getDummySegment())
+
+	newSegment := getDummySegment()
+	newSegment.Users = []uint32{}
+	newSegment.Groups = []string{"dev"}
+
+	s, err := getService(db).UpdateSegment(context.Background(), "beta_testers", newSegment)
+	assert.Nil(t, err)
+	assert.Equal(t, s.Users, []uint32{})
+	assert.Equal(t, s.Groups, []string{"dev"})
+
+	s, _ = getService(db).GetSegment(context.Background(), "beta_testers")
+	assert.Equal(t, s.Groups, []string{"dev"})
+
+	// Update an unexisting segment
+	_, err = getService(db).UpdateSegment(context.Background(), "unknown", newSegment)
+	assert.Equal(t, err.Error(), "Unable to find segment")
+}
+
+func TestSegmentFeatures(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	_ = getService(db).AddSegment(context.Background(), getDummySegment())
+
+	// Feature flags cannot reference unknown segments
+	feature := getDummyFeature()
+	feature.Segments = []string{"beta_testers", "unknown"}
+	assert.Equal(t, getService(db).AddFeature(context.Background(), feature).Error(), "Segment unknown does not exist")
+
+	feature.Segments = []string{"beta_testers"}
+	assert.Nil(t, getService(db).AddFeature(context.Background(), feature))
+
+	feature.Segments = []string{"unknown"}
+	_, err := getService(db).UpdateFeature(context.Background(), "foo", feature)
+	assert.Equal(t, err.Error(), "Segment unknown does not exist")
+
+	other := getDummyFeature()
+	other.Key = "bar"
+	_ = getService(db).AddFeature(context.Background(), other)
+
+	features, err := getService(db).GetSegmentFeatures(context.Background(), "beta_testers")
+	assert.Nil(t, err)
+	assert.Equal(t, len(features), 1)
+	assert.Equal(t, features[0].Key, "foo")
+
+	// A segment used by a feature cannot be deleted
+	err = getService(db).RemoveSegment(context.Background(), "beta_testers")
+	assert.Equal(t, err.Error(), "Segment is used by 1 feature flags")
+
+	_ = getService(db).RemoveFeature(context.Background(), "foo")
+	assert.Nil(t, getService(db).RemoveSegment(context.Background(), "beta_testers"))
+	assert.False(t, getService(db).SegmentExists(context.Background(), "beta_testers"))
+
+	err = getService(db).RemoveSegment(context.Background(), "beta_testers")
+	assert.Equal(t, err.Error(), "Unable to find segment")
+}
+
+func getDummySegment() m.Segment {
+	return m.Segment{
+		Key:    "beta_testers",
+		Users:  []uint32{22},
+		Groups: []string{"beta"},
+		Rules:  []m.Rule{},
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	metrics "github.com/antoineaugusti/feature-flags/metrics"
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+)
+
+// An immutable view of every feature flag and segment, decoded
+// once and shared by concurrent requests. It must not be modified
+type Snapshot struct {
+	// Incremented every time feature flags or segments change
+	Revision uint64
+	// Every feature flag, sorted by key
+	Features m.FeatureFlags
+	// Every segment, indexed by key
+	Segments map[string]m.Segment
+	// Feature flags indexed by key
+	byKey map[string]m.FeatureFlag
+}
+
+// Holds the latest snapshot
+type snapshotCache struct {
+	// Serializes writes so that snapshots are stored in commit order
+	lock  sync.Mutex
+	value atomic.Value
+	// What changed in the latest revisions
+	log changeLog
+}
+
+// Feature gets a feature flag thanks to its key
+func (s *Snapshot) Feature(featureKey string) (m.FeatureFlag, bool) {
+	feature, ok := s.byKey[featureKey]
+	return feature, ok
+}
+
+// Snapshot gets the latest view of the feature flags and segments.
+// It is read from memory when the service was created by
+// NewFeatureService, from the store otherwise
+func (interactor *FeatureService) Snapshot(ctx context.Context) (*Snapshot, error) {
+	ctx, span := interactor.startSpan(ctx, "Snapshot")
+	defer span.End()
+
+	if interactor.cache != nil {
+		return interactor.cache.value.Load().(*Snapshot), nil
+	}
+
+	return interactor.loadSnapshot(ctx)
+}
+
+// Refresh reloads the snapshot from the store, to see changes
+// made by other servers sharing the same store
+func (interactor *FeatureService) Refresh(ctx context.Context) error {
+	ctx, span := interactor.startSpan(ctx, "Refresh")
+	defer span.End()
+
+	if interactor.cache == nil {
+		return nil
+	}
+
+	interactor.cache.lock.Lock()
+	defer interactor.cache.lock.Unlock()
+
+	snapshot, err := interactor.loadSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Changes made by other servers are unknown: followers
+	// must fetch everything again
+	current := interactor.cache.value.Load().(*Snapshot)
+	if !reflect.DeepEqual(current.Features, snapshot.Features) || !reflect.DeepEqual(current.Segments, snapshot.Segments) {
+		interactor.publish(snapshot, &changeEntry{full: true})
+	}
+	return nil
+}
+
+// Read a snapshot from the store
+func (interactor *FeatureService) loadSnapshot(ctx context.Context) (snapshot *Snapshot, err error) {
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
+
+		snapshot, err = readSnapshot(tx)
+		return err
+	})
+
+	return
+}
+
+// Run a read-write transaction, recording the changes of feature
+// flags in their history. Once committed, the snapshot is replaced
+// by a copy where the feature flags and segments written by the
+// transaction are updated
+func (interactor *FeatureService) update(ctx context.Context, fn func(repos.Tx) error) error {
+	if interactor.cache == nil {
+		return interactor.store(ctx).Update(func(tx repos.Tx) error {
+			return record(tx, &changeEntry{}, fn)
+		})
+	}
+
+	interactor.cache.lock.Lock()
+	defer interactor.cache.lock.Unlock()
+
+	current := interactor.cache.value.Load().(*Snapshot)
+	var snapshot *Snapshot
+	entry := &changeEntry{}
+	err := interactor.store(ctx).Update(func(tx repos.Tx) (err error) {
+		// The transaction can be run again
+		*entry = changeEntry{}
+		if err = record(tx, entry, fn); err != nil {
+			return err
+		}
+
+		snapshot, err = current.apply(tx, entry)
+		return err
+	})
+
+	if err == nil {
+		interactor.publish(snapshot, entry)
+	}
+	return err
+}
+
+// Run a function in a transaction, recording what it writes in a
+// change entry and in the history of feature flags
+func record(tx repos.Tx, entry *changeEntry, fn func(repos.Tx) error) error {
+	recording := recordingTx{tx, entry, make(map[string]*m.FeatureFlag), make(map[string]*m.FeatureFlag)}
+	if err := fn(recording); err != nil {
+		return err
+	}
+
+	return recording.recordHistory()
+}
+
+// Replace the snapshot and record what changed. The lock of
+// the cache must be held
+func (interactor *FeatureService) publish(snapshot *Snapshot, entry *changeEntry) {
+	current := interactor.cache.value.Load().(*Snapshot)
+	snapshot.Revision = current.Revision
+	metrics.Features.Set(float64(len(snapshot.Features)))
+
+	if entry.isEmpty() {
+		interactor.cache.value.Store(snapshot)
+		return
+	}
+
+	snapshot.Revision++
+	entry.revision = snapshot.Revision
+	interactor.cache.value.Store(snapshot)
+	interactor.cache.log.append(*entry)
+}
+
+// Build a snapshot from the data seen by a transaction
+func readSnapshot(tx repos.Tx) (*Snapshot, error) {
+	features, err := tx.GetFeatures()
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := tx.GetSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Features: features,
+		Segments: make(map[string]m.Segment, len(segments)),
+		byKey:    make(map[string]m.FeatureFlag, len(features)),
+	}
+	for _, segment := range segments {
+		snapshot.Segments[segment.Key] = segment
+	}
+	for _, feature := range features {
+		snapshot.byKey[feature.Key] = feature
+	}
+
+	return snapshot, nil
+}
+
+// Copy a snapshot, reading again from a transaction the feature
+// flags and segments written in a change entry
+func (s *Snapshot) apply(tx repos.Tx, entry *changeEntry) (*Snapshot, error) {
+	snapshot := *s
+
+	if len(entry.features) > 0 {
+		snapshot.Features = append(make(m.FeatureFlags, 0, len(s.Features)), s.Features...)
+		snapshot.byKey = make(map[string]m.FeatureFlag, len(s.byKey))
+		for key, feature := range s.byKey {
+			snapshot.byKey[key] = feature
+		}
+	}
+	for _, key := range entry.features {
+		feature, err := tx.GetFeature(key)
+		if err != nil && err.Error() != "Unable to find feature" {
+			return nil, err
+		}
+
+		i := sort.Search(len(snapshot.Features), func(i int) bool { return snapshot.Features[i].Key >= key })
+		exists := i < len(snapshot.Features) && snapshot.Features[i].Key == key
+		switch {
+		case err != nil && exists:
+			snapshot.Features = append(snapshot.Features[:i], snapshot.Features[i+1:]...)
+			delete(snapshot.byKey, key)
+		case err != nil:
+		case exists:
+			snapshot.Features[i] = feature
+			snapshot.byKey[key] = feature
+		default:
+			snapshot.Features = append(snapshot.Features[:i], append(m.FeatureFlags{feature}, snapshot.Features[i:]...)...)
+			snapshot.byKey[key] = feature
+		}
+	}
+
+	if len(entry.segments) > 0 {
+		snapshot.Segments = make(map[string]m.Segment, len(s.Segments))
+		for key, segment := range s.Segments {
+			snapshot.Segments[key] = segment
+		}
+	}
+	for _, key := range entry.segments {
+		segment, err := tx.GetSegment(key)
+		if err != nil && err.Error() != "Unable to find segment" {
+			return nil, err
+		}
+
+		if err != nil {
+			delete(snapshot.Segments, key)
+		} else {
+			snapshot.Segments[key] = segment
+		}
+	}
+
+	return &snapshot, nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	// Existing features are loaded
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
+
+	service, err := NewFeatureService(repos.NewBoltStore(db))
+	assert.Nil(t, err)
+
+	snapshot, _ := service.Snapshot(context.Background())
+	assert.Equal(t, len(snapshot.Features), 1)
+
+	feature, ok := snapshot.Feature("foo")
+	assert.True(t, ok)
+	assert.True(t, feature.UserHasAccess(22))
+
+	// Writes made through the service replace the snapshot
+	_ = service.AddSegment(context.Background(), getDummySegment())
+	_, _ = service.AddUser(context.Background(), "foo", 42)
+
+	snapshot, _ = service.Snapshot(context.Background())
+	feature, _ = snapshot.Feature("foo")
+	assert.True(t, feature.UserHasAccess(42))
+	assert.Equal(t, len(snapshot.Segments), 1)
+
+	// A failed write keeps the snapshot
+	err = service.AddFeature(context.Background(), getDummyFeature())
+	assert.NotNil(t, err)
+	assert.True(t, snapshot == getSnapshot(service))
+
+	// Writes made outside the service are not seen
+	_ = getService(db).RemoveFeature(context.Background(), "foo")
+	_, ok = getSnapshot(service).Feature("foo")
+	assert.True(t, ok)
+
+	// Refreshing the snapshot sees writes made outside the service
+	assert.Nil(t, service.Refresh(context.Background()))
+	_, ok = getSnapshot(service).Feature("foo")
+	assert.False(t, ok)
+
+	// Without a cache, the snapshot is read from the database
+	_, ok = getSnapshot(getService(db)).Feature("foo")
+	assert.False(t, ok)
+}
+
+func TestSnapshotUpdate(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	service, err := NewFeatureService(repos.NewBoltStore(db))
+	assert.Nil(t, err)
+
+	_ = service.AddSegment(context.Background(), getDummySegment())
+	_ = service.AddFeature(context.Background(), m.FeatureFlag{Key: "foo", Users: []uint32{3, 1}})
+	_ = service.AddFeature(context.Background(), m.FeatureFlag{Key: "baz"})
+	_ = service.AddFeature(context.Background(), m.FeatureFlag{Key: "bar", Segments: []string{"beta_testers"}})
+	_, _ = service.AddUser(context.Background(), "foo", 2)
+	_ = service.RemoveFeature(context.Background(), "baz")
+
+	previous := getSnapshot(service)
+	_, _ = service.UpdateFeature(context.Background(), "bar", m.FeatureFlag{Enabled: true})
+	_ = service.RemoveSegment(context.Background(), "beta_testers")
+
+	// Only written keys are read again, the result is the same
+	snapshot := getSnapshot(service)
+	loaded, err := service.loadSnapshot(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, loaded.Features, snapshot.Features)
+	assert.Equal(t, loaded.Segments, snapshot.Segments)
+	assert.Equal(t, loaded.byKey, snapshot.byKey)
+	assert.Equal(t, []string{"bar", "foo"}, []string{snapshot.Features[0].Key, snapshot.Features[1].Key})
+
+	// Previous snapshots are not changed
+	feature, _ := previous.Feature("bar")
+	assert.False(t, feature.Enabled)
+	assert.False(t, previous.Features[0].Enabled)
+	assert.Len(t, previous.Segments, 1)
+}
+
+func getSnapshot(service *FeatureService) *Snapshot {
+	snapshot, err := service.Snapshot(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return snapshot
+}
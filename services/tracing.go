@@ -0,0 +1,22 @@
+package services
+
+import (
+	"context"
+
+	repos "github.com/antoineaugusti/feature-flags/repos"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/antoineaugusti/feature-flags/services")
+
+// Start the span of a call to the service, as a child of the
+// span of a context, like the span of a request
+func (interactor *FeatureService) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "FeatureService."+name)
+}
+
+// The store, tracing transactions as children of the span of a context
+func (interactor *FeatureService) store(ctx context.Context) repos.Store {
+	return repos.Traced(ctx, interactor.Store)
+}
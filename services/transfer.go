@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	repos "github.com/antoineaugusti/feature-flags/repos"
+)
+
+// What to do when an imported feature flag or segment already exists
+const (
+	// Keep the existing version
+	ConflictSkip = "skip"
+	// Replace the existing version
+	ConflictOverwrite = "overwrite"
+	// Import nothing
+	ConflictFail = "fail"
+)
+
+// Describes what an import changed, or would change for a dry run
+type ImportReport struct {
+	DryRun   bool         `json:"dry_run"`
+	Features ImportResult `json:"features"`
+	Segments ImportResult `json:"segments"`
+}
+
+// Keys of the imported feature flags or segments, by outcome
+type ImportResult struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Skipped []string `json:"skipped"`
+}
+
+// Error returned when a document cannot be imported
+type ImportError struct {
+	// Tells if the error comes from an existing feature flag or segment
+	Conflict bool
+	message  string
+}
+
+func (e ImportError) Error() string {
+	return e.message
+}
+
+// Returned by the transaction of a dry run to discard its changes
+var errDryRun = fmt.Errorf("dry run")
+
+// Export gets every feature flag and segment, as seen by a single transaction
+func (interactor *FeatureService) Export(ctx context.Context) (export m.Export, err error) {
+	ctx, span := interactor.startSpan(ctx, "Export")
+	defer span.End()
+
+	export.Version = m.ExportVersion
+
+	_ = interactor.store(ctx).View(func(tx repos.Tx) error {
+		if export.Features, err = tx.GetFeatures(); err != nil {
+			return err
+		}
+
+		export.Segments, err = tx.GetSegments()
+		return err
+	})
+
+	return
+}
+
+// Import stores the feature flags and segments of a document in a single
+// transaction: either everything is imported, or nothing is. A dry run
+// reports what would be imported without changing anything
+func (interactor *FeatureService) Import(ctx context.Context, export m.Export, conflict string, dryRun bool) (ImportReport, error) {
+	ctx, span := interactor.startSpan(ctx, "Import")
+	defer span.End()
+
+	report := ImportReport{DryRun: dryRun, Features: newImportResult(), Segments: newImportResult()}
+
+	if conflict != ConflictSkip && conflict != ConflictOverwrite && conflict != ConflictFail {
+		return report, ImportError{message: fmt.Sprintf("Unknown conflict strategy %s", conflict)}
+	}
+
+	if err := export.Validate(); err != nil {
+		return report, ImportError{message: err.Error()}
+	}
+
+	err := interactor.update(ctx, func(tx repos.Tx) error {
+		// The transaction can be run again
+		report = ImportReport{DryRun: dryRun, Features: newImportResult(), Segments: newImportResult()}
+
+		// Segments first, so that imported feature flags can reference them
+		for _, segment := range export.Segments {
+			exists, err := tx.SegmentExists(segment.Key)
+			if err != nil {
+				return err
+			}
+
+			put, err := importAction(exists, "Segment", segment.Key, conflict, &report.Segments)
+			if err != nil {
+				return err
+			}
+			if !put {
+				continue
+			}
+
+			if err := tx.PutSegment(segment); err != nil {
+				return err
+			}
+		}
+
+		for _, feature := range export.Features {
+			existing, err := tx.GetFeature(feature.Key)
+			if err != nil && err.Error() != "Unable to find feature" {
+				return err
+			}
+			exists := err == nil
+
+			// Only configuration files change managed feature flags
+			if exists && existing.Managed && conflict != ConflictSkip {
+				return ImportError{Conflict: true, message: fmt.Sprintf("Feature %s is managed by configuration files", feature.Key)}
+			}
+
+			put, err := importAction(exists, "Feature", feature.Key, conflict, &report.Features)
+			if err != nil {
+				return err
+			}
+			if !put {
+				continue
+			}
+
+			missing, err := missingSegment(tx, feature.Segments)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return ImportError{message: fmt.Sprintf("Segment %s does not exist", missing)}
+			}
+
+			// Only configuration files define managed features
+			feature.Managed = false
+			if err := tx.PutFeature(feature); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	if err == errDryRun {
+		err = nil
+	}
+	return report, err
+}
+
+// Tell if an imported feature flag or segment must be stored, and record the outcome
+func importAction(exists bool, kind, key, conflict string, result *ImportResult) (bool, error) {
+	if !exists {
+		result.Created = append(result.Created, key)
+		return true, nil
+	}
+
+	switch conflict {
+	case ConflictSkip:
+		result.Skipped = append(result.Skipped, key)
+		return false, nil
+	case ConflictOverwrite:
+		result.Updated = append(result.Updated, key)
+		return true, nil
+	}
+
+	return false, ImportError{Conflict: true, message: fmt.Sprintf("%s %s already exists", kind, key)}
+}
+
+func newImportResult() ImportResult {
+	return ImportResult{Created: []string{}, Updated: []string{}, Skipped: []string{}}
+}
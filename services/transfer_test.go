@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	m "github.com/antoineaugusti/feature-flags/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	_ = getService(db).AddSegment(context.Background(), getDummySegment())
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
+
+	export, err := getService(db).Export(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, m.ExportVersion, export.Version)
+	assert.Equal(t, 1, len(export.Features))
+	assert.Equal(t, "foo", export.Features[0].Key)
+	assert.Equal(t, []uint32{22}, export.Features[0].Users)
+	assert.Equal(t, 1, len(export.Segments))
+}
+
+func TestImport(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	_ = getService(db).AddFeature(context.Background(), getDummyFeature())
+
+	imported := getDummyFeature()
+	imported.Enabled = true
+	imported.Segments = []string{"beta_testers"}
+	export := m.Export{
+		Version:  m.ExportVersion,
+		Features: m.FeatureFlags{imported, {Key: "new_feature", Managed: true}},
+		Segments: m.Segments{getDummySegment()},
+	}
+
+	// Nothing is imported when a feature flag exists
+	report, err := getService(db).Import(context.Background(), export, ConflictFail, false)
+	assert.Equal(t, ImportError{Conflict: true, message: "Feature foo already exists"}, err)
+	assert.False(t, getService(db).SegmentExists(context.Background(), "beta_testers"))
+
+	// A dry run changes nothing
+	report, err = getService(db).Import(context.Background(), export, ConflictOverwrite, true)
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"foo"}, report.Features.Updated)
+	assert.Equal(t, []string{"new_feature"}, report.Features.Created)
+	assert.False(t, getService(db).FeatureExists(context.Background(), "new_feature"))
+
+	// Existing feature flags are kept
+	report, err = getService(db).Import(context.Background(), export, ConflictSkip, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, report.Features.Skipped)
+	assert.Equal(t, []string{"new_feature"}, report.Features.Created)
+	assert.Equal(t, []string{"beta_testers"}, report.Segments.Created)
+	feature, _ := getService(db).GetFeature(context.Background(), "foo")
+	assert.False(t, feature.Enabled)
+	// Imported feature flags are never managed
+	feature, _ = getService(db).GetFeature(context.Background(), "new_feature")
+	assert.False(t, feature.Managed)
+
+	// Existing feature flags are replaced
+	report, err = getService(db).Import(context.Background(), export, ConflictOverwrite, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"foo", "new_feature"}, report.Features.Updated)
+	assert.Equal(t, []string{"beta_testers"}, report.Segments.Updated)
+	feature, _ = getService(db).GetFeature(context.Background(), "foo")
+	assert.True(t, feature.Enabled)
+	assert.Equal(t, []string{"beta_testers"}, feature.Segments)
+}
+
+func TestImportInvalidDocument(t *testing.T) {
+	db := getTestDB()
+	defer closeDB(db)
+
+	export := m.Export{Version: m.ExportVersion, Features: m.FeatureFlags{{Key: "homepage_v2", Segments: []string{"unknown"}}}}
+
+	_, err := getService(db).Import(context.Background(), export, "merge", false)
+	assert.Equal(t, "Unknown conflict strategy merge", err.Error())
+
+	_, err = getService(db).Import(context.Background(), export, ConflictFail, false)
+	assert.Equal(t, ImportError{message: "Segment unknown does not exist"}, err)
+	assert.False(t, getService(db).FeatureExists(context.Background(), "homepage_v2"))
+
+	export.Version = 0
+	_, err = getService(db).Import(context.Background(), export, ConflictFail, false)
+	assert.Equal(t, "Unsupported export version 0", err.Error())
+}
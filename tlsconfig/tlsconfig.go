@@ -0,0 +1,208 @@
+// Package tlsconfig serves the API over TLS, with certificates
+// reloaded when their files change
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Files holds the paths of PEM encoded files
+type Files struct {
+	// The certificate of the server and its chain
+	Certificate string
+	// The private key of the certificate
+	Key string
+	// The CA certificates signing client certificates. Clients
+	// must present a certificate when it is set
+	ClientCA string
+}
+
+// Reloader holds the certificates read from files. They are
+// read again by Reload, or by Run when the files change
+type Reloader struct {
+	files Files
+
+	mu sync.RWMutex
+	// Modification times and sizes of the files loaded
+	stamp string
+	// Configuration of the server, for every connection
+	server *tls.Config
+	// Transport presenting the certificate of the server as a
+	// client certificate to other servers
+	transport *http.Transport
+}
+
+// NewReloader reads the certificates of files
+func NewReloader(files Files) (*Reloader, error) {
+	r := &Reloader{files: files}
+	return r, r.Reload()
+}
+
+// Reload reads the files again. Certificates in use are kept on error
+func (r *Reloader) Reload() error {
+	stamp := r.currentStamp()
+
+	certificate, err := tls.LoadX509KeyPair(r.files.Certificate, r.files.Key)
+	if err != nil {
+		return err
+	}
+
+	server := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	client := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if len(r.files.ClientCA) > 0 {
+		bytes, err := os.ReadFile(r.files.ClientCA)
+		if err != nil {
+			return err
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(bytes) {
+			return fmt.Errorf("No certificate found in %s", r.files.ClientCA)
+		}
+		// Clients without a certificate are accepted for health checks:
+		// requests without a verified certificate must be refused later
+		server.ClientAuth = tls.VerifyClientCertIfGiven
+		server.ClientCAs = clientCAs
+
+		// Other servers are trusted when signed by the same CA
+		client.RootCAs, _ = systemCAsWith(bytes)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = client
+
+	r.mu.Lock()
+	previous := r.transport
+	r.stamp, r.server, r.transport = stamp, server, transport
+	r.mu.Unlock()
+
+	if previous != nil {
+		previous.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Run reloads the certificates when their files change, checking
+// every interval until stop is closed
+func (r *Reloader) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.mu.RLock()
+			changed := r.stamp != r.currentStamp()
+			r.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				slog.Error("Unable to reload the TLS certificates", "error", err)
+				continue
+			}
+			slog.Info("Reloaded the TLS certificates", "certificate", r.files.Certificate)
+		}
+	}
+}
+
+// ServerConfig gives the TLS configuration of an HTTP server,
+// using the latest certificates for every connection
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.server, nil
+		},
+	}
+}
+
+// RoundTrip sends a request to another server, presenting the
+// certificate of this server when a client certificate is asked
+func (r *Reloader) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.RLock()
+	transport := r.transport
+	r.mu.RUnlock()
+
+	return transport.RoundTrip(req)
+}
+
+// ClientFiles holds the paths of the PEM encoded files of a client
+type ClientFiles struct {
+	// CA certificates trusted in addition to the system ones
+	CA string
+	// The certificate presented to servers asking for one
+	Certificate string
+	// The private key of the certificate
+	Key string
+}
+
+// NewTransport creates a transport trusting the CA of files, and
+// presenting their certificate when one is given
+func NewTransport(files ClientFiles) (*http.Transport, error) {
+	client := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(files.CA) > 0 {
+		bytes, err := os.ReadFile(files.CA)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if client.RootCAs, ok = systemCAsWith(bytes); !ok {
+			return nil, fmt.Errorf("No certificate found in %s", files.CA)
+		}
+	}
+
+	if len(files.Certificate) > 0 || len(files.Key) > 0 {
+		certificate, err := tls.LoadX509KeyPair(files.Certificate, files.Key)
+		if err != nil {
+			return nil, err
+		}
+		client.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = client
+	return transport, nil
+}
+
+// The system CA certificates and the PEM encoded certificates of
+// bytes. Tell if bytes hold a certificate
+func systemCAsWith(bytes []byte) (*x509.CertPool, bool) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	return pool, pool.AppendCertsFromPEM(bytes)
+}
+
+// Describe the files, to tell when they change
+func (r *Reloader) currentStamp() string {
+	stamp := ""
+	for _, path := range []string{r.files.Certificate, r.files.Key, r.files.ClientCA} {
+		if info, err := os.Stat(path); err == nil {
+			stamp += fmt.Sprintf("%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamp
+}
@@ -0,0 +1,190 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type authority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+}
+
+func newAuthority(t *testing.T) authority {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	certificate, _ := x509.ParseCertificate(der)
+
+	return authority{certificate, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// Sign a certificate valid for localhost, returning PEM encoded
+// certificate and key
+func (a authority) sign(t *testing.T, name string, serial int64) ([]byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.certificate, &key.PublicKey, a.key)
+	assert.Nil(t, err)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	assert.Nil(t, os.WriteFile(path, content, 0600))
+}
+
+// Write the certificate and key of the server, and the client CA
+func writeFiles(t *testing.T, ca authority, serial int64) Files {
+	directory := t.TempDir()
+	files := Files{
+		Certificate: filepath.Join(directory, "server.pem"),
+		Key:         filepath.Join(directory, "server-key.pem"),
+		ClientCA:    filepath.Join(directory, "ca.pem"),
+	}
+
+	certificate, key := ca.sign(t, "server", serial)
+	writeFile(t, files.Certificate, certificate)
+	writeFile(t, files.Key, key)
+	writeFile(t, files.ClientCA, ca.pem)
+	return files
+}
+
+func startServer(t *testing.T, reloader *Reloader) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) == 0 {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = reloader.ServerConfig()
+	server.StartTLS()
+	return server
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newAuthority(t)
+	reloader, err := NewReloader(writeFiles(t, ca, 2))
+	assert.Nil(t, err)
+
+	server := startServer(t, reloader)
+	defer server.Close()
+
+	// The reloader presents the certificate of the server
+	res, err := (&http.Client{Transport: reloader}).Get(server.URL)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, big.NewInt(2), res.TLS.PeerCertificates[0].SerialNumber)
+
+	// Clients without a certificate are accepted, without identity
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.certificate)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}}}
+	res, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "anonymous", string(body))
+
+	// Clients with a certificate signed by another CA are refused
+	certificate, key := newAuthority(t).sign(t, "intruder", 3)
+	pair, _ := tls.X509KeyPair(certificate, key)
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{pair}}}}
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err)
+}
+
+func TestReload(t *testing.T) {
+	ca := newAuthority(t)
+	files := writeFiles(t, ca, 2)
+	reloader, err := NewReloader(files)
+	assert.Nil(t, err)
+
+	server := startServer(t, reloader)
+	defer server.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.Run(10*time.Millisecond, stop)
+
+	// Invalid files are ignored
+	writeFile(t, files.Key, []byte("not a key"))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, big.NewInt(2), servedSerial(t, reloader, server))
+
+	certificate, key := ca.sign(t, "server", 4)
+	writeFile(t, files.Certificate, certificate)
+	writeFile(t, files.Key, key)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, big.NewInt(4), servedSerial(t, reloader, server))
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	ca := newAuthority(t)
+	files := writeFiles(t, ca, 2)
+
+	_, err := NewReloader(Files{Certificate: files.Certificate, Key: files.Certificate})
+	assert.NotNil(t, err)
+
+	writeFile(t, files.ClientCA, []byte("nothing"))
+	_, err = NewReloader(files)
+	assert.Equal(t, "No certificate found in "+files.ClientCA, err.Error())
+
+	// Client certificates are optional without a client CA
+	files.ClientCA = ""
+	reloader, err := NewReloader(files)
+	assert.Nil(t, err)
+	assert.Equal(t, tls.NoClientCert, reloader.server.ClientAuth)
+}
+
+// Get the serial number of the certificate served on a new connection
+func servedSerial(t *testing.T, reloader *Reloader, server *httptest.Server) *big.Int {
+	reloader.mu.RLock()
+	reloader.transport.CloseIdleConnections()
+	reloader.mu.RUnlock()
+
+	res, err := (&http.Client{Transport: reloader}).Get(server.URL)
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	defer res.Body.Close()
+	return res.TLS.PeerCertificates[0].SerialNumber
+}
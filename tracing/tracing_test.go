@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	// A local collector
+	requests := make(chan *http.Request, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup("otlp", collector.URL+"/v1/traces", nil)
+	assert.Nil(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "FeatureService.GetFeature")
+	span.End()
+	assert.Nil(t, shutdown(context.Background()))
+
+	request := <-requests
+	assert.Equal(t, "/v1/traces", request.URL.Path)
+	assert.Equal(t, "application/x-protobuf", request.Header.Get("Content-Type"))
+
+	_, err = Setup("zipkin", "", nil)
+	assert.Equal(t, "Unknown trace exporter zipkin, expected none, stdout or otlp", err.Error())
+}
+
+func TestSetupStdout(t *testing.T) {
+	var buffer bytes.Buffer
+
+	shutdown, err := Setup("stdout", "", &buffer)
+	assert.Nil(t, err)
+
+	tracer := otel.GetTracerProvider().Tracer("test")
+	_, span := tracer.Start(context.Background(), "FeatureService.GetFeature")
+	span.End()
+	assert.Nil(t, shutdown(context.Background()))
+
+	assert.Contains(t, buffer.String(), `"Name":"FeatureService.GetFeature"`)
+}